@@ -0,0 +1,114 @@
+package cgroup
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rateWindow is a period the read rate is averaged over
+const rateWindow = time.Minute
+
+// Activity tracks consumer group reads and sessions reading it
+type Activity struct {
+	reads    uint64
+	lastRead int64
+	sessions int64
+	started  time.Time
+	// saved is the last read time persisted last
+	saved int64
+
+	mu        sync.Mutex
+	rate      float64
+	rateReads uint64
+	rateTime  time.Time
+}
+
+func newActivity(now time.Time) *Activity {
+	return &Activity{started: now}
+}
+
+// IdleSince returns time of the last read or the time tracking
+// was started at if there were no reads since
+func (a *Activity) IdleSince() time.Time {
+	if last := a.LastRead(); !last.IsZero() {
+		return last
+	}
+	return a.started
+}
+
+// Reads returns total number of items read
+func (a *Activity) Reads() uint64 {
+	return atomic.LoadUint64(&a.reads)
+}
+
+// LastRead returns time of the last read, zero time if there were no reads
+func (a *Activity) LastRead() time.Time {
+	if ts := atomic.LoadInt64(&a.lastRead); ts > 0 {
+		return time.Unix(0, ts)
+	}
+	return time.Time{}
+}
+
+// Rate returns read rate in items per second averaged over the last minute
+func (a *Activity) Rate() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.rate
+}
+
+// Sessions returns number of client sessions reading the consumer group
+func (a *Activity) Sessions() int64 {
+	return atomic.LoadInt64(&a.sessions)
+}
+
+// UpdateSessions changes number of client sessions reading the consumer group
+func (a *Activity) UpdateSessions(delta int64) {
+	atomic.AddInt64(&a.sessions, delta)
+}
+
+// encode serializes the time tracking was started at and
+// the time of the last read
+func (a *Activity) encode() []byte {
+	value := make([]byte, 16)
+	binary.BigEndian.PutUint64(value[:8], uint64(a.started.UnixNano()))
+	binary.BigEndian.PutUint64(value[8:], uint64(atomic.LoadInt64(&a.lastRead)))
+	return value
+}
+
+// decode restores times serialized by encode
+func (a *Activity) decode(value []byte) {
+	if len(value) != 16 {
+		return
+	}
+	a.started = time.Unix(0, int64(binary.BigEndian.Uint64(value[:8])))
+	a.lastRead = int64(binary.BigEndian.Uint64(value[8:]))
+	a.saved = a.lastRead
+}
+
+func (a *Activity) read(now time.Time) {
+	atomic.AddUint64(&a.reads, 1)
+	atomic.StoreInt64(&a.lastRead, now.UnixNano())
+}
+
+// updateRate recalculates exponentially weighted read rate,
+// it's expected to be called periodically
+func (a *Activity) updateRate(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	reads := a.Reads()
+	if a.rateTime.IsZero() {
+		a.rateReads, a.rateTime = reads, now
+		return
+	}
+	elapsed := now.Sub(a.rateTime)
+	if elapsed <= 0 {
+		return
+	}
+	current := float64(reads-a.rateReads) / elapsed.Seconds()
+	alpha := 1 - math.Exp(-float64(elapsed)/float64(rateWindow))
+	a.rate += alpha * (current - a.rate)
+	a.rateReads, a.rateTime = reads, now
+}
@@ -0,0 +1,57 @@
+package cgroup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Activity(t *testing.T) {
+	a := &Activity{}
+	assert.True(t, a.LastRead().IsZero())
+
+	now := time.Now()
+	a.updateRate(now)
+	for i := 0; i < 60; i++ {
+		a.read(now)
+	}
+	assert.EqualValues(t, 60, a.Reads())
+	assert.Equal(t, now.UnixNano(), a.LastRead().UnixNano())
+
+	a.updateRate(now.Add(time.Minute))
+	assert.InDelta(t, 0.63, a.Rate(), 0.01)
+
+	// rate decays without reads
+	a.updateRate(now.Add(time.Hour))
+	assert.InDelta(t, 0, a.Rate(), 0.01)
+
+	a.UpdateSessions(2)
+	a.UpdateSessions(-1)
+	assert.EqualValues(t, 1, a.Sessions())
+}
+
+func Test_ConsumerGroup_Lag(t *testing.T) {
+	q, err := setupTimestampedCGQueue(t, 3)
+	defer cleanupCGQueue(q)
+	assert.NoError(t, err)
+
+	cg, err := q.ConsumerGroup(cgName)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, cg.LagBytes())
+	assert.False(t, cg.OldestUnread().IsZero())
+
+	value, err := cg.GetNext()
+	assert.NoError(t, err)
+	assert.NoError(t, cg.PutBack(value))
+	assert.EqualValues(t, 2, cg.LagBytes())
+	assert.EqualValues(t, 1, cg.FailedReads())
+	assert.EqualValues(t, 1, cg.Activity().Reads())
+
+	assert.NoError(t, q.Enqueue([]byte("1234")))
+	assert.EqualValues(t, 6, cg.LagBytes())
+
+	assert.NoError(t, cg.SetCursor(q.Tail()))
+	assert.EqualValues(t, 0, cg.LagBytes())
+	assert.True(t, cg.OldestUnread().IsZero())
+}
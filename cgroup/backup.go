@@ -0,0 +1,264 @@
+package cgroup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/syndtr/goleveldb/leveldb"
+
+	"github.com/bogdanovich/siberite/queue"
+)
+
+// ErrInvalidBackup is returned when a backup archive can't be read
+var ErrInvalidBackup = errors.New("cgroup: invalid backup archive")
+
+// backupMagic starts every backup archive
+const backupMagic = "siberite-backup-1\n"
+
+// backup archive record types, every record is
+// <type><uvarint key length><key><uvarint value length><value>
+const (
+	recordQueue byte = 'Q' // starts a queue, key is the queue name
+	recordItem  byte = 'I' // queue database record
+	recordMeta  byte = 'M' // metadata database record
+)
+
+// restoreBatchSize limits the number of records written at once by Restore
+const restoreBatchSize = 1000
+
+// BackupWriter writes queues to a gzip compressed backup archive
+type BackupWriter struct {
+	gz  *gzip.Writer
+	w   *bufio.Writer
+	buf []byte
+}
+
+// NewBackupWriter starts a backup archive
+func NewBackupWriter(w io.Writer) (*BackupWriter, error) {
+	gz := gzip.NewWriter(w)
+	b := &BackupWriter{gz: gz, w: bufio.NewWriter(gz), buf: make([]byte, binary.MaxVarintLen64)}
+	_, err := b.w.WriteString(backupMagic)
+	return b, err
+}
+
+// Close flushes the archive, it doesn't close the underlying writer
+func (b *BackupWriter) Close() error {
+	if err := b.w.Flush(); err != nil {
+		return err
+	}
+	return b.gz.Close()
+}
+
+// Backup writes consistent snapshots of the queue
+// and its consumer groups metadata to the archive
+func (q *CGQueue) Backup(b *BackupWriter) error {
+	snapshots, err := q.Queue.Snapshot(q.CGManager.storage)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, s := range snapshots {
+			s.Release()
+		}
+	}()
+
+	if err = b.write(recordQueue, []byte(q.Name), nil); err != nil {
+		return err
+	}
+	for i, recordType := range []byte{recordItem, recordMeta} {
+		iter := snapshots[i].NewIterator(nil, nil)
+		for iter.Next() {
+			if err = b.write(recordType, iter.Key(), iter.Value()); err != nil {
+				break
+			}
+		}
+		iter.Release()
+		if err == nil {
+			err = iter.Error()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *BackupWriter) write(recordType byte, key, value []byte) error {
+	if err := b.w.WriteByte(recordType); err != nil {
+		return err
+	}
+	for _, field := range [][]byte{key, value} {
+		if _, err := b.w.Write(b.buf[:binary.PutUvarint(b.buf, uint64(len(field)))]); err != nil {
+			return err
+		}
+		if _, err := b.w.Write(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore loads queues from a backup archive into the data directory.
+// Rename returns the name a queue is restored under, queues renamed
+// to an empty name are skipped. Existing queues are not overwritten.
+// Returns names of restored queues.
+func Restore(r io.Reader, dataDir string, rename func(name string) string) ([]string, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, ErrInvalidBackup
+	}
+	defer gz.Close()
+	br := bufio.NewReader(gz)
+
+	magic := make([]byte, len(backupMagic))
+	if _, err = io.ReadFull(br, magic); err != nil || string(magic) != backupMagic {
+		return nil, ErrInvalidBackup
+	}
+
+	restored := []string{}
+	var target *restoreTarget
+	finish := func() error {
+		if err := target.finish(); err != nil || target == nil {
+			return err
+		}
+		restored = append(restored, target.name)
+		return nil
+	}
+	for {
+		recordType, key, value, err := readRecord(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			target.abort()
+			return restored, err
+		}
+		if recordType == recordQueue {
+			if err = finish(); err != nil {
+				return restored, err
+			}
+			if target, err = newRestoreTarget(dataDir, rename(string(key))); err != nil {
+				return restored, err
+			}
+			continue
+		}
+		if err = target.put(recordType, key, value); err != nil {
+			target.abort()
+			return restored, err
+		}
+	}
+	return restored, finish()
+}
+
+func readRecord(r *bufio.Reader) (byte, []byte, []byte, error) {
+	recordType, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	fields := [][]byte{}
+	for i := 0; i < 2; i++ {
+		length, err := binary.ReadUvarint(r)
+		if err != nil {
+			return 0, nil, nil, ErrInvalidBackup
+		}
+		field := make([]byte, length)
+		if _, err = io.ReadFull(r, field); err != nil {
+			return 0, nil, nil, ErrInvalidBackup
+		}
+		fields = append(fields, field)
+	}
+	return recordType, fields[0], fields[1], nil
+}
+
+// restoreTarget writes records of a restored queue,
+// a nil target skips records
+type restoreTarget struct {
+	name    string
+	path    string
+	dbs     map[byte]*leveldb.DB
+	batches map[byte]*leveldb.Batch
+}
+
+func newRestoreTarget(dataDir, name string) (*restoreTarget, error) {
+	if name == "" {
+		return nil, nil
+	}
+	t := &restoreTarget{
+		name:    name,
+		path:    dataDir + "/" + name,
+		dbs:     map[byte]*leveldb.DB{},
+		batches: map[byte]*leveldb.Batch{},
+	}
+	if err := queue.ValidateName(name); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(t.path); err == nil {
+		return nil, ErrExists
+	}
+	var err error
+	paths := map[byte]string{
+		recordItem: t.path + "/" + name,
+		recordMeta: t.path + "/_.metadata",
+	}
+	for recordType, path := range paths {
+		if t.dbs[recordType], err = leveldb.OpenFile(path, nil); err != nil {
+			t.abort()
+			return nil, err
+		}
+		t.batches[recordType] = new(leveldb.Batch)
+	}
+	return t, nil
+}
+
+func (t *restoreTarget) put(recordType byte, key, value []byte) error {
+	if t == nil {
+		return nil
+	}
+	batch, ok := t.batches[recordType]
+	if !ok {
+		return ErrInvalidBackup
+	}
+	batch.Put(key, value)
+	if batch.Len() < restoreBatchSize {
+		return nil
+	}
+	if err := t.dbs[recordType].Write(batch, nil); err != nil {
+		return err
+	}
+	batch.Reset()
+	return nil
+}
+
+func (t *restoreTarget) finish() error {
+	if t == nil {
+		return nil
+	}
+	for recordType, db := range t.dbs {
+		err := db.Write(t.batches[recordType], nil)
+		if err == nil {
+			err = db.Close()
+		}
+		if err != nil {
+			t.abort()
+			return err
+		}
+	}
+	return nil
+}
+
+// abort removes partially restored queue
+func (t *restoreTarget) abort() {
+	if t == nil {
+		return
+	}
+	for _, db := range t.dbs {
+		if db != nil {
+			db.Close()
+		}
+	}
+	os.RemoveAll(t.path)
+}
@@ -0,0 +1,69 @@
+package cgroup
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CGQueue_BackupRestore(t *testing.T) {
+	q, err := CGQueueOpen("backup", dir)
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir + "/backup")
+	defer os.RemoveAll(dir + "/restored")
+
+	for i := 1; i <= 5; i++ {
+		q.Enqueue([]byte(strconv.Itoa(i)))
+	}
+	q.GetNext()
+	cg, _ := q.ConsumerGroup("cg1")
+	cg.GetNext()
+	value, _ := cg.GetNext()
+	cg.PutBack(value)
+	assert.NoError(t, q.SetOption("dedup_window", "1m"))
+
+	archive := &bytes.Buffer{}
+	b, err := NewBackupWriter(archive)
+	assert.NoError(t, err)
+	assert.NoError(t, q.Backup(b))
+	assert.NoError(t, b.Close())
+	q.Close()
+	data := archive.Bytes()
+
+	// existing queue is not overwritten
+	restored, err := Restore(bytes.NewReader(data), dir, func(name string) string { return name })
+	assert.Equal(t, ErrExists, err)
+	assert.Empty(t, restored)
+
+	restored, err = Restore(bytes.NewReader(data), dir, func(name string) string { return "restored" })
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"restored"}, restored)
+
+	q, err = CGQueueOpen("restored", dir)
+	assert.NoError(t, err)
+	defer q.Close()
+	assert.EqualValues(t, 4, q.Length())
+	assert.EqualValues(t, 5, q.Tail())
+	assert.Equal(t, "1m0s", q.Options().DedupWindow.String())
+	cg, err = q.FindConsumerGroup("cg1")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, cg.Cursor())
+	assert.EqualValues(t, 1, cg.FailedReads())
+	value, _ = cg.GetNext()
+	assert.Equal(t, "3", string(value))
+
+	restored, err = Restore(bytes.NewReader(data), dir, func(name string) string { return "" })
+	assert.NoError(t, err)
+	assert.Empty(t, restored)
+
+	_, err = Restore(bytes.NewReader(data[:len(data)/2]), dir, func(name string) string { return "partial" })
+	assert.Error(t, err)
+	_, err = os.Stat(dir + "/partial")
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = Restore(bytes.NewReader([]byte("garbage")), dir, func(name string) string { return name })
+	assert.Equal(t, ErrInvalidBackup, err)
+}
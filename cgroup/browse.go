@@ -0,0 +1,216 @@
+package cgroup
+
+import (
+	"github.com/bogdanovich/siberite/queue"
+)
+
+// MaxPageSize limits the number of items returned by Browse
+const MaxPageSize = 1000
+
+// Item locations reported by Browse
+const (
+	LocationSource = "source"
+	LocationFailed = "failed"
+	LocationGroup  = "group:"
+)
+
+// PageItem represents an item returned by Browse
+type PageItem struct {
+	*queue.Item
+	// Location is LocationSource for source queue items, LocationFailed
+	// for failed reliable reads of a consumer group or LocationGroup
+	// followed by group name for items of busy message groups
+	Location string
+}
+
+// collector collects items of queues added in the order they are served
+type collector interface {
+	add(q *queue.Queue, after uint64, location string) error
+}
+
+// itemReader reads items of a queue or of its view
+type itemReader interface {
+	Head() uint64
+	Tail() uint64
+	ReadItemAfter(id uint64) (*queue.Item, error)
+}
+
+// page collects a page of items from a sequence of queues
+// in the order they are going to be served. If visit is set,
+// items are passed to it instead of being collected.
+type page struct {
+	offset uint64
+	limit  int
+	items  []*PageItem
+	visit  func(*PageItem) error
+}
+
+func newPage(offset uint64, limit int) *page {
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+	return &page{offset: offset, limit: limit}
+}
+
+func (p *page) full() bool {
+	return p.visit == nil && len(p.items) >= p.limit
+}
+
+func (p *page) add(q *queue.Queue, after uint64, location string) error {
+	return p.read(q, after, location)
+}
+
+// read appends items of the queue with ID greater than after.
+// Offset counts item positions, so deleted items are counted as well.
+func (p *page) read(q itemReader, after uint64, location string) error {
+	if after < q.Head() {
+		after = q.Head()
+	}
+	if p.full() || after >= q.Tail() {
+		return nil
+	}
+	positions := q.Tail() - after
+	if p.offset >= positions {
+		p.offset -= positions
+		return nil
+	}
+	id := after + p.offset
+	p.offset = 0
+	for !p.full() {
+		item, err := q.ReadItemAfter(id)
+		if err == queue.ErrIDOutOfBounds || err == queue.ErrIsEmpty {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if p.visit == nil {
+			p.items = append(p.items, &PageItem{item, location})
+		} else if err = p.visit(&PageItem{item, location}); err != nil {
+			return err
+		}
+		id = item.ID
+	}
+	return nil
+}
+
+// Browse returns up to limit items starting from offset without
+// removing them from the queue. Items of busy message groups are
+// listed first, as they are served first. Items retained
+// for consumer groups are not listed.
+func (q *CGQueue) Browse(offset uint64, limit int) ([]*PageItem, error) {
+	p := newPage(offset, limit)
+	if err := q.browse(p); err != nil {
+		return nil, err
+	}
+	return p.items, nil
+}
+
+// Export calls fn for every pending item in the order they are going
+// to be served, see Browse. Items are read from views of the queues,
+// so the queue is not locked while fn is called.
+func (q *CGQueue) Export(fn func(*PageItem) error) error {
+	v := &views{}
+	defer v.release()
+	if err := q.browse(v); err != nil {
+		return err
+	}
+	return v.export(fn)
+}
+
+func (q *CGQueue) browse(c collector) error {
+	if err := q.groups.browse(c); err != nil {
+		return err
+	}
+	if !q.Options().ProtectCursors {
+		return c.add(q.Queue, 0, LocationSource)
+	}
+	if err := c.add(q.reader.putBack, 0, LocationFailed); err != nil {
+		return err
+	}
+	return c.add(q.Queue, q.reader.position(), LocationSource)
+}
+
+// Browse returns up to limit pending items of the consumer group
+// starting from offset without moving the cursor. Items are listed
+// in the order they are going to be served: items of busy message
+// groups, failed reads and then source queue items after the cursor.
+func (cg *ConsumerGroup) Browse(offset uint64, limit int) ([]*PageItem, error) {
+	p := newPage(offset, limit)
+	if err := cg.browse(p); err != nil {
+		return nil, err
+	}
+	return p.items, nil
+}
+
+// Export calls fn for every pending item of the consumer group in the
+// order they are going to be served, see Browse. Views of the queues
+// and the cursor are taken under the lock, so the consumer group is
+// not locked while fn is called.
+func (cg *ConsumerGroup) Export(fn func(*PageItem) error) error {
+	v := &views{}
+	defer v.release()
+	if err := cg.browse(v); err != nil {
+		return err
+	}
+	return v.export(fn)
+}
+
+func (cg *ConsumerGroup) browse(c collector) error {
+	cg.RLock()
+	defer cg.RUnlock()
+
+	if err := cg.groups.browse(c); err != nil {
+		return err
+	}
+	if err := c.add(cg.failedReads, 0, LocationFailed); err != nil {
+		return err
+	}
+	return c.add(cg.source, cg.cursor, LocationSource)
+}
+
+func (g *messageGroups) browse(c collector) error {
+	g.Lock()
+	defer g.Unlock()
+	for _, group := range g.order {
+		if err := c.add(g.held[group], 0, LocationGroup+group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// views collects views of queues in the order they are served,
+// so their items can be exported without locking the queues
+type views struct {
+	views     []*queue.View
+	after     []uint64
+	locations []string
+}
+
+func (v *views) add(q *queue.Queue, after uint64, location string) error {
+	view, err := q.View()
+	if err != nil {
+		return err
+	}
+	v.views = append(v.views, view)
+	v.after = append(v.after, after)
+	v.locations = append(v.locations, location)
+	return nil
+}
+
+func (v *views) export(fn func(*PageItem) error) error {
+	p := &page{visit: fn}
+	for i, view := range v.views {
+		if err := p.read(view, v.after[i], v.locations[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *views) release() {
+	for _, view := range v.views {
+		view.Release()
+	}
+}
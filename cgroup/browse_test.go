@@ -0,0 +1,107 @@
+package cgroup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bogdanovich/siberite/queue"
+)
+
+func pageValues(items []*PageItem) []string {
+	values := []string{}
+	for _, item := range items {
+		values = append(values, item.Location+":"+string(item.Value))
+	}
+	return values
+}
+
+func Test_CGQueue_Browse(t *testing.T) {
+	q, err := setupCGQueue(t, 6)
+	defer cleanupCGQueue(q)
+	assert.NoError(t, err)
+	assert.NoError(t, q.DeleteItemByID(4))
+
+	items, err := q.Browse(0, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"source:1", "source:2", "source:4"}, pageValues(items))
+	assert.EqualValues(t, 2, items[0].ID)
+	assert.EqualValues(t, 5, items[2].ID)
+
+	items, err = q.Browse(4, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"source:5", "source:6"}, pageValues(items))
+
+	items, err = q.Browse(10, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, items)
+
+	// nothing is removed
+	assert.EqualValues(t, 5, q.Length())
+}
+
+func Test_ConsumerGroup_Browse(t *testing.T) {
+	q, err := setupCGQueue(t, 0)
+	defer cleanupCGQueue(q)
+	assert.NoError(t, err)
+
+	cg, err := q.ConsumerGroup("cg")
+	assert.NoError(t, err)
+
+	q.EnqueueItem(&queue.Item{Value: []byte("a1"), Group: "a"})
+	q.EnqueueItem(&queue.Item{Value: []byte("a2"), Group: "a"})
+	q.Enqueue([]byte("x"))
+	_, err = cg.OpenNext()
+	assert.NoError(t, err)
+	value, err := cg.GetNext()
+	assert.NoError(t, err)
+	assert.Equal(t, "x", string(value))
+
+	q.Enqueue([]byte("y"))
+	q.Enqueue([]byte("z"))
+	value, err = cg.GetNext()
+	assert.NoError(t, err)
+	assert.NoError(t, cg.PutBack(value))
+
+	items, err := cg.Browse(0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"group:a:a1", "group:a:a2", "failed:y", "source:z"}, pageValues(items))
+
+	items, err = cg.Browse(2, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"failed:y"}, pageValues(items))
+	assert.EqualValues(t, 3, cg.Length())
+}
+
+func Test_ConsumerGroup_Export(t *testing.T) {
+	q, err := setupCGQueue(t, 3)
+	defer cleanupCGQueue(q)
+	assert.NoError(t, err)
+
+	cg, err := q.ConsumerGroup("cg")
+	assert.NoError(t, err)
+	assert.NoError(t, cg.PutBack([]byte("f")))
+
+	// the consumer group is read and written while it's exported,
+	// the export keeps the state it was started with
+	values := []string{}
+	err = cg.Export(func(item *PageItem) error {
+		values = append(values, item.Location+":"+string(item.Value))
+		if _, err := cg.GetNext(); err != nil {
+			return err
+		}
+		return q.Enqueue([]byte("z"))
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"failed:f", "source:1", "source:2", "source:3"}, values)
+	assert.EqualValues(t, 4, cg.Length())
+
+	values = []string{}
+	err = q.Export(func(item *PageItem) error {
+		values = append(values, string(item.Value))
+		_, err := q.GetNext()
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1", "2", "3", "z", "z", "z", "z"}, values)
+}
@@ -1,6 +1,7 @@
 package cgroup
 
 import (
+	"errors"
 	"strings"
 	"sync"
 
@@ -12,6 +13,14 @@ import (
 	"github.com/bogdanovich/siberite/queue"
 )
 
+var (
+	// ErrNotFound is returned when consumer group doesn't exist
+	ErrNotFound = errors.New("cgroup: consumer group not found")
+
+	// ErrExists is returned when consumer group already exists
+	ErrExists = errors.New("cgroup: consumer group already exists")
+)
+
 // CGManager represents multiple consumer group manager
 type CGManager struct {
 	cmap        cmap.ConcurrentMap
@@ -52,6 +61,43 @@ func (m *CGManager) ConsumerGroup(name string) (*ConsumerGroup, error) {
 	return cg, nil
 }
 
+// FindConsumerGroup returns an existing consumer group
+func (m *CGManager) FindConsumerGroup(name string) (*ConsumerGroup, error) {
+	cg, ok := m.get(name)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return cg, nil
+}
+
+// CreateConsumerGroup creates a new consumer group with provided cursor
+func (m *CGManager) CreateConsumerGroup(name string, cursor uint64) (*ConsumerGroup, error) {
+	m.Lock()
+	defer m.Unlock()
+	if _, ok := m.get(name); ok {
+		return nil, ErrExists
+	}
+	cg, err := NewConsumerGroup(name, m.source, m.storage)
+	if err != nil {
+		return nil, err
+	}
+	if err = cg.SetCursor(cursor); err != nil {
+		return nil, err
+	}
+	m.cmap.Set(name, cg)
+	return cg, nil
+}
+
+// CloneConsumerGroup creates a new consumer group
+// with the cursor position of the existing one
+func (m *CGManager) CloneConsumerGroup(name, newName string) (*ConsumerGroup, error) {
+	cg, err := m.FindConsumerGroup(name)
+	if err != nil {
+		return nil, err
+	}
+	return m.CreateConsumerGroup(newName, cg.Cursor())
+}
+
 // DeleteConsumerGroup deletes specified consumer group
 func (m *CGManager) DeleteConsumerGroup(name string) error {
 	cg, ok := m.get(name)
@@ -71,8 +117,18 @@ func (m *CGManager) ConsumerGroupIterator() <-chan cmap.Tuple {
 	return m.cmap.IterBuffered()
 }
 
-// Close consumer group manager
+// Close consumer group manager, read activity
+// of consumer groups is persisted
 func (m *CGManager) Close() {
+	if m.cmap != nil {
+		for pair := range m.ConsumerGroupIterator() {
+			cg := pair.Val.(*ConsumerGroup)
+			if cg.groups != nil {
+				cg.groups.releaseAll()
+			}
+			cg.saveActivity()
+		}
+	}
 	m.storage.Close()
 	m.cmap = nil
 }
@@ -132,3 +132,47 @@ func Test_CGManager_DeleteConsumerGroup(t *testing.T) {
 	assert.NoError(t, err)
 	assert.EqualValues(t, 10, cg.Length())
 }
+
+func Test_CGManager_CloneConsumerGroup(t *testing.T) {
+	m, err := setupCGManager(t, 10)
+	defer cleanupCGManager(m)
+	assert.NoError(t, err)
+
+	_, err = m.CloneConsumerGroup("test_cgroup", "clone")
+	assert.Equal(t, ErrNotFound, err)
+
+	cg, err := m.ConsumerGroup("test_cgroup")
+	assert.NoError(t, err)
+	assert.NoError(t, cg.SetCursor(4))
+
+	clone, err := m.CloneConsumerGroup("test_cgroup", "clone")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 4, clone.Cursor())
+	value, err := clone.GetNext()
+	assert.NoError(t, err)
+	assert.Equal(t, "4", string(value))
+	assert.EqualValues(t, 4, cg.Cursor())
+
+	_, err = m.CloneConsumerGroup("test_cgroup", "clone")
+	assert.Equal(t, ErrExists, err)
+}
+
+func Test_CGManager_CreateConsumerGroup(t *testing.T) {
+	m, err := setupCGManager(t, 10)
+	defer cleanupCGManager(m)
+	assert.NoError(t, err)
+
+	_, err = m.FindConsumerGroup("test_cgroup")
+	assert.Equal(t, ErrNotFound, err)
+
+	cg, err := m.CreateConsumerGroup("test_cgroup", 11)
+	assert.NoError(t, err)
+	assert.True(t, cg.IsEmpty())
+
+	found, err := m.FindConsumerGroup("test_cgroup")
+	assert.NoError(t, err)
+	assert.Equal(t, cg, found)
+
+	_, err = m.CreateConsumerGroup("test_cgroup", 0)
+	assert.Equal(t, ErrExists, err)
+}
@@ -1,20 +1,41 @@
 package cgroup
 
 import (
+	"errors"
+	"log"
 	"os"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
 
 	"github.com/bogdanovich/siberite/queue"
 )
 
-// make sure CGQueue implements Consumer interface
-var _ queue.Consumer = (*CGQueue)(nil)
+// make sure CGQueue implements GroupConsumer interface
+var _ GroupConsumer = (*CGQueue)(nil)
+
+// ErrLogMode is returned on an attempt to consume items
+// of a log mode queue without a durable cursor
+var ErrLogMode = errors.New("cgroup: queue is in log mode, read it with a durable cursor")
+
+// maxTrimItems limits the number of items removed
+// by retention during a single maintenance pass
+const maxTrimItems = 10000
 
 // CGQueue represents queue with multiple consumer groups
 type CGQueue struct {
-	sync.Mutex
+	sync.RWMutex
 	Name    string
 	dataDir string
+	options *optionStore
+	dedup   *dedupIndex
+	groups  *messageGroups
+	reader  *protectedReader
+	fanout  *fanout
+	expired uint64
 	*queue.Queue
 	*CGManager
 }
@@ -22,13 +43,41 @@ type CGQueue struct {
 // CGQueueOpen opens a queue with multiple consumer groups
 func CGQueueOpen(name string, dataDir string) (*CGQueue, error) {
 	q := &CGQueue{Name: name, dataDir: dataDir + "/" + name}
-	return q, q.initialize()
+	if err := q.initialize(); err != nil {
+		q.Close()
+		return nil, err
+	}
+	return q, nil
+}
+
+// CGQueueRecover rebuilds leveldb manifests of a queue that fails to open,
+// data that can't be recovered is dropped
+func CGQueueRecover(name string, dataDir string) error {
+	path := dataDir + "/" + name
+	for _, dbPath := range []string{path + "/" + name, path + "/_.metadata"} {
+		if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+			continue
+		}
+		db, err := leveldb.RecoverFile(dbPath, nil)
+		if err != nil {
+			return err
+		}
+		db.Close()
+	}
+	return nil
 }
 
 // Close closes the queue
 func (q *CGQueue) Close() {
-	q.CGManager.Close()
-	q.Queue.Close()
+	if q.CGManager != nil && q.CGManager.storage != nil {
+		if q.groups != nil {
+			q.groups.releaseAll()
+		}
+		q.CGManager.Close()
+	}
+	if q.Queue != nil {
+		q.Queue.Close()
+	}
 }
 
 // Drop closes the queue and removes it's data directory
@@ -37,12 +86,34 @@ func (q *CGQueue) Drop() {
 	os.RemoveAll(q.Path())
 }
 
-// Flush drops all queue data
+// Flush drops all queue data, queue options
+// and fanout children are preserved
 func (q *CGQueue) Flush() error {
 	q.Lock()
 	defer q.Unlock()
+	values, err := q.options.raw()
+	if err != nil {
+		return err
+	}
+	children := q.FanoutChildren()
 	q.Drop()
-	return q.initialize()
+	if err = q.initialize(); err != nil {
+		return err
+	}
+	for name, value := range values {
+		if err = q.options.set(name, value); err != nil {
+			return err
+		}
+	}
+	if err = q.Queue.SetTimestamps(q.Options().NeedsTimestamps()); err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err = q.AddFanout(child); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Path returns queue data directory path
@@ -50,6 +121,270 @@ func (q *CGQueue) Path() string {
 	return q.dataDir
 }
 
+// Compact compacts queue and metadata databases
+func (q *CGQueue) Compact() error {
+	if err := q.Queue.Compact(); err != nil {
+		return err
+	}
+	return q.CGManager.storage.CompactRange(util.Range{})
+}
+
+// Options returns current queue options
+func (q *CGQueue) Options() Options {
+	return q.options.get()
+}
+
+// SetOption updates and persists a queue option
+func (q *CGQueue) SetOption(name, value string) error {
+	q.Lock()
+	defer q.Unlock()
+	protected := q.Options().ProtectCursors
+	if err := q.options.set(name, value); err != nil {
+		return err
+	}
+	if err := q.Queue.SetTimestamps(q.Options().NeedsTimestamps()); err != nil {
+		return err
+	}
+	switch {
+	case !protected && q.Options().ProtectCursors:
+		return q.reader.reset()
+	case protected && !q.Options().ProtectCursors:
+		return q.reader.release()
+	}
+	return nil
+}
+
+// GetConsumerGroup returns a consumer group, a new consumer group
+// is created only if auto_create_cursors option is enabled
+func (q *CGQueue) GetConsumerGroup(name string) (*ConsumerGroup, error) {
+	if q.Options().AutoCreateCursors {
+		return q.ConsumerGroup(name)
+	}
+	return q.FindConsumerGroup(name)
+}
+
+// GetNext returns next value honoring message groups
+func (q *CGQueue) GetNext() ([]byte, error) {
+	item, err := q.GetNextItem()
+	if err != nil {
+		return nil, err
+	}
+	return item.Value, nil
+}
+
+// GetNextItem returns next item honoring message groups
+func (q *CGQueue) GetNextItem() (*queue.Item, error) {
+	if q.Options().Mode == ModeLog {
+		return nil, ErrLogMode
+	}
+	return q.groups.next(q.source(), false)
+}
+
+// OpenNext returns next item for a reliable read. The message group
+// of the item stays open until it's closed or aborted.
+func (q *CGQueue) OpenNext() (*queue.Item, error) {
+	if q.Options().Mode == ModeLog {
+		return nil, ErrLogMode
+	}
+	return q.groups.next(q.source(), true)
+}
+
+// CloseGroup confirms an open item of the message group
+func (q *CGQueue) CloseGroup(group string) error {
+	return q.groups.close(group)
+}
+
+// CloseGroupItem confirms an item of the message group by its ID,
+// it's safe to call it again for the same item
+func (q *CGQueue) CloseGroupItem(group string, id uint64) error {
+	return q.groups.closeItem(group, id)
+}
+
+// AbortGroup returns an open item of the message group back to the queue
+func (q *CGQueue) AbortGroup(group string) error {
+	return q.groups.abort(group)
+}
+
+// Peek returns next value without removing it from the queue
+func (q *CGQueue) Peek() ([]byte, error) {
+	if item, ok := q.groups.peek(); ok {
+		return item.Value, nil
+	}
+	if q.Options().ProtectCursors {
+		item, err := q.reader.peek()
+		return item.Value, err
+	}
+	return q.Queue.Peek()
+}
+
+// PutBack returns value to the queue
+func (q *CGQueue) PutBack(value []byte) error {
+	if q.Options().ProtectCursors {
+		return q.reader.putBack.Enqueue(value)
+	}
+	return q.Queue.PutBack(value)
+}
+
+// ReadItemByID returns an item by ID, items that were consumed,
+// but are kept in the queue for consumer groups are not returned
+func (q *CGQueue) ReadItemByID(id uint64) (*queue.Item, error) {
+	if q.Options().ProtectCursors && id <= q.reader.position() {
+		return &queue.Item{}, queue.ErrIDOutOfBounds
+	}
+	return q.Queue.ReadItemByID(id)
+}
+
+// Length returns current length of the queue including
+// items of busy message groups
+func (q *CGQueue) Length() uint64 {
+	if q.Options().ProtectCursors {
+		return q.reader.length() + q.groups.length()
+	}
+	return q.Queue.Length() + q.groups.length()
+}
+
+// Retained returns number of items that were consumed,
+// but are kept in the queue for consumer groups
+func (q *CGQueue) Retained() uint64 {
+	if !q.Options().ProtectCursors {
+		return 0
+	}
+	q.reader.Lock()
+	defer q.reader.Unlock()
+	return q.reader.retained()
+}
+
+// IsEmpty returns true if queue is empty
+func (q *CGQueue) IsEmpty() bool {
+	return q.Length() < 1
+}
+
+// EnqueueOnce adds an item to the queue unless the same idempotency key
+// was already stored within queue dedup window.
+// Returns false if the item was recognized as a duplicate,
+// in that case item ID is set to the ID of originally stored item.
+// The item and its key are written to separate databases, the key right
+// after the item, so a crash in between lets a retry store the item again.
+func (q *CGQueue) EnqueueOnce(key string, item *queue.Item) (bool, error) {
+	q.RLock()
+	defer q.RUnlock()
+	window := q.Options().DedupWindow
+	if window == 0 {
+		return true, q.EnqueueItem(item)
+	}
+
+	q.dedup.Lock()
+	defer q.dedup.Unlock()
+
+	now := time.Now()
+	id, seen, err := q.dedup.seen(key, now.Add(-window))
+	if err != nil || seen {
+		item.ID = id
+		return false, err
+	}
+	if err = q.EnqueueItem(item); err != nil {
+		return false, err
+	}
+	return true, q.dedup.add(key, now, item.ID)
+}
+
+// Maintain runs periodic background tasks: expires idempotency keys,
+// updates and persists consumer group activity, deletes idle consumer groups
+// and removes items according to retention settings
+func (q *CGQueue) Maintain() error {
+	q.Lock()
+	defer q.Unlock()
+	opts := q.Options()
+	now := time.Now()
+	if _, err := q.dedup.expire(now.Add(-opts.DedupWindow)); err != nil {
+		return err
+	}
+	for pair := range q.ConsumerGroupIterator() {
+		cg := pair.Val.(*ConsumerGroup)
+		cg.activity.updateRate(now)
+		if err := cg.saveActivity(); err != nil {
+			return err
+		}
+	}
+	if opts.CursorTTL > 0 {
+		if err := q.expireConsumerGroups(now.Add(-opts.CursorTTL)); err != nil {
+			return err
+		}
+	}
+	if opts.HasRetention() {
+		if _, err := q.Queue.Trim(retention(opts, now), maxTrimItems); err != nil {
+			return err
+		}
+	}
+	if opts.ProtectCursors {
+		cursor := q.consumedCursor()
+		_, err := q.Queue.Trim(func(item *queue.Item, length, size uint64) bool {
+			return item.ID <= cursor
+		}, maxTrimItems)
+		return err
+	}
+	return nil
+}
+
+// ExpiredConsumerGroups returns number of consumer groups
+// deleted because of inactivity
+func (q *CGQueue) ExpiredConsumerGroups() uint64 {
+	return atomic.LoadUint64(&q.expired)
+}
+
+// expireConsumerGroups deletes consumer groups that were
+// not read since the cutoff time and are not being read now
+func (q *CGQueue) expireConsumerGroups(cutoff time.Time) error {
+	expired := []*ConsumerGroup{}
+	for pair := range q.ConsumerGroupIterator() {
+		cg := pair.Val.(*ConsumerGroup)
+		if cg.activity.IdleSince().Before(cutoff) &&
+			cg.activity.Sessions() == 0 && cg.stats.OpenReads == 0 {
+			expired = append(expired, cg)
+		}
+	}
+	for _, cg := range expired {
+		if err := q.DeleteConsumerGroup(cg.Name); err != nil {
+			return err
+		}
+		atomic.AddUint64(&q.expired, 1)
+		log.Printf("queue \"%s\": cursor \"%s\" expired, idle since %s",
+			q.Name, cg.Name, cg.activity.IdleSince().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// source returns a function that consumes next item of the queue
+func (q *CGQueue) source() itemSource {
+	if q.Options().ProtectCursors {
+		return q.reader.next
+	}
+	return q.Queue.GetNextItemFunc
+}
+
+// consumedCursor returns ID of the last item that was
+// read by plain reads and all consumer groups
+func (q *CGQueue) consumedCursor() uint64 {
+	cursor := q.reader.position()
+	for pair := range q.ConsumerGroupIterator() {
+		if cgCursor := pair.Val.(*ConsumerGroup).Cursor(); cgCursor < cursor {
+			cursor = cgCursor
+		}
+	}
+	return cursor
+}
+
+// retention returns a function that checks if the head item
+// exceeds any of retention limits
+func retention(opts Options, now time.Time) func(*queue.Item, uint64, uint64) bool {
+	cutoff := now.Add(-opts.RetentionAge)
+	return func(item *queue.Item, length, size uint64) bool {
+		return (opts.RetentionItems > 0 && length > opts.RetentionItems) ||
+			(opts.RetentionBytes > 0 && size > opts.RetentionBytes) ||
+			(opts.RetentionAge > 0 && !item.Timestamp.IsZero() && item.Timestamp.Before(cutoff))
+	}
+}
+
 func (q *CGQueue) initialize() error {
 	var err error
 	q.Queue, err = queue.Open(q.Name, q.dataDir, &queue.Options{})
@@ -58,5 +393,28 @@ func (q *CGQueue) initialize() error {
 	}
 
 	q.CGManager, err = NewCGManager(q.dataDir+"/_.metadata", q.Queue)
-	return err
+	if err != nil {
+		return err
+	}
+
+	q.dedup = newDedupIndex(q.CGManager.storage)
+	q.groups, err = newMessageGroups(q.CGManager.storage, "")
+	if err != nil {
+		return err
+	}
+	q.reader, err = newProtectedReader(q.Queue, q.CGManager.storage)
+	if err != nil {
+		return err
+	}
+	q.fanout, err = newFanout(q.CGManager.storage)
+	if err != nil {
+		return err
+	}
+	q.options, err = newOptionStore(q.CGManager.storage)
+	if err != nil {
+		return err
+	}
+	// timestamps cost an extra record per item, so they are stored
+	// only if queue options need them
+	return q.Queue.SetTimestamps(q.Options().NeedsTimestamps())
 }
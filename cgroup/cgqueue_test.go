@@ -4,6 +4,7 @@ import (
 	"os"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -24,6 +25,20 @@ func setupCGQueue(t *testing.T, numItems int) (*CGQueue, error) {
 	return q, nil
 }
 
+// setupTimestampedCGQueue is setupCGQueue for a queue with timestamps option
+func setupTimestampedCGQueue(t *testing.T, numItems int) (*CGQueue, error) {
+	q, err := CGQueueOpen(cgQueueName, dir)
+	assert.NoError(t, err)
+	assert.NoError(t, q.SetOption("timestamps", "true"))
+
+	for i := 0; i < numItems+1; i++ {
+		q.Enqueue([]byte(strconv.Itoa(i)))
+	}
+
+	q.GetNext()
+	return q, nil
+}
+
 func cleanupCGQueue(q *CGQueue) {
 	q.Close()
 	os.RemoveAll(dir)
@@ -112,6 +127,186 @@ func Test_CGQueue_ConsumerGroups(t *testing.T) {
 
 }
 
+func Test_CGQueue_LogMode(t *testing.T) {
+	q, err := setupCGQueue(t, 3)
+	defer cleanupCGQueue(q)
+	assert.NoError(t, err)
+	assert.NoError(t, q.SetOption("mode", ModeLog))
+
+	_, err = q.GetNext()
+	assert.Equal(t, ErrLogMode, err)
+	_, err = q.OpenNext()
+	assert.Equal(t, ErrLogMode, err)
+
+	value, err := q.Peek()
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(value))
+
+	// cursors read independently
+	for _, name := range []string{"cg1", "cg2"} {
+		cg, err := q.ConsumerGroup(name)
+		assert.NoError(t, err)
+		for i := 1; i <= 3; i++ {
+			value, err = cg.GetNext()
+			assert.NoError(t, err)
+			assert.Equal(t, strconv.Itoa(i), string(value))
+		}
+	}
+	assert.EqualValues(t, 3, q.Length())
+}
+
+func Test_CGQueue_Maintain_Retention(t *testing.T) {
+	q, err := setupTimestampedCGQueue(t, 10)
+	defer cleanupCGQueue(q)
+	assert.NoError(t, err)
+	assert.NoError(t, q.SetOption("mode", ModeLog))
+
+	// no retention
+	assert.NoError(t, q.Maintain())
+	assert.EqualValues(t, 10, q.Length())
+
+	assert.NoError(t, q.SetOption("retention_items", "8"))
+	assert.NoError(t, q.Maintain())
+	assert.EqualValues(t, 8, q.Length())
+
+	// 8 items of 1 byte, "10" is 2 bytes
+	assert.NoError(t, q.SetOption("retention_bytes", "5"))
+	assert.NoError(t, q.Maintain())
+	assert.EqualValues(t, 4, q.Length())
+
+	value, err := q.Peek()
+	assert.NoError(t, err)
+	assert.Equal(t, "7", string(value))
+
+	assert.NoError(t, q.SetOption("retention_age", "1ms"))
+	time.Sleep(5 * time.Millisecond)
+	assert.NoError(t, q.Enqueue([]byte("11")))
+	assert.NoError(t, q.Maintain())
+	assert.EqualValues(t, 1, q.Length())
+}
+
+func Test_CGQueue_Timestamps(t *testing.T) {
+	q, err := setupCGQueue(t, 1)
+	defer cleanupCGQueue(q)
+	assert.NoError(t, err)
+
+	// items of a regular queue are stored without timestamps
+	item, err := q.Queue.PeekItem()
+	assert.NoError(t, err)
+	assert.True(t, item.Timestamp.IsZero())
+
+	defaults := DefaultOptions()
+	for _, option := range [][2]string{{"mode", ModeLog}, {"retention_age", "1h"},
+		{"retention_bytes", "100"}, {"timestamps", "true"}} {
+		assert.NoError(t, q.SetOption(option[0], option[1]))
+		assert.NoError(t, q.Enqueue([]byte("1")))
+		item, err = q.Queue.ReadItemByID(q.Tail())
+		assert.NoError(t, err)
+		assert.False(t, item.Timestamp.IsZero(), option[0])
+		assert.NoError(t, q.Flush())
+		assert.True(t, q.Options().NeedsTimestamps())
+		value, err := defaults.Get(option[0])
+		assert.NoError(t, err)
+		assert.NoError(t, q.SetOption(option[0], value))
+	}
+	assert.False(t, q.Options().NeedsTimestamps())
+}
+
+func Test_retention(t *testing.T) {
+	now := time.Now()
+	opts := Options{RetentionAge: time.Minute}
+	expired := retention(opts, now)
+	assert.True(t, expired(&queue.Item{Timestamp: now.Add(-time.Hour)}, 1, 1))
+	assert.False(t, expired(&queue.Item{Timestamp: now}, 1, 1))
+	// items without timestamp are kept
+	assert.False(t, expired(&queue.Item{}, 1, 1))
+}
+
+func Test_CGQueue_GetConsumerGroup(t *testing.T) {
+	q, err := setupCGQueue(t, 3)
+	defer cleanupCGQueue(q)
+	assert.NoError(t, err)
+
+	cg, err := q.GetConsumerGroup("cg1")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, cg.Length())
+
+	assert.NoError(t, q.SetOption("auto_create_cursors", "false"))
+	_, err = q.GetConsumerGroup("cg2")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = q.GetConsumerGroup("cg1")
+	assert.NoError(t, err)
+}
+
+func Test_CGQueue_Maintain_ExpiresConsumerGroups(t *testing.T) {
+	q, err := setupCGQueue(t, 3)
+	defer cleanupCGQueue(q)
+	assert.NoError(t, err)
+
+	idle, err := q.ConsumerGroup("idle")
+	assert.NoError(t, err)
+	active, err := q.ConsumerGroup("active")
+	assert.NoError(t, err)
+	reading, err := q.ConsumerGroup("reading")
+	assert.NoError(t, err)
+	reading.Activity().UpdateSessions(1)
+
+	// no expiration by default
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, q.Maintain())
+	assert.EqualValues(t, 0, q.ExpiredConsumerGroups())
+
+	assert.NoError(t, q.SetOption("cursor_ttl", "5ms"))
+	_, err = active.GetNext()
+	assert.NoError(t, err)
+	assert.NoError(t, q.Maintain())
+	assert.EqualValues(t, 1, q.ExpiredConsumerGroups())
+
+	_, err = q.FindConsumerGroup(idle.Name)
+	assert.Equal(t, ErrNotFound, err)
+	_, err = q.FindConsumerGroup(active.Name)
+	assert.NoError(t, err)
+	_, err = q.FindConsumerGroup(reading.Name)
+	assert.NoError(t, err)
+
+	// deleted consumer group is not restored on open
+	q.Close()
+	q, err = CGQueueOpen(cgQueueName, dir)
+	assert.NoError(t, err)
+	_, err = q.FindConsumerGroup(idle.Name)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func Test_CGQueue_Maintain_ExpiresConsumerGroupsAfterRestart(t *testing.T) {
+	q, err := setupCGQueue(t, 3)
+	defer func() { cleanupCGQueue(q) }()
+	assert.NoError(t, err)
+
+	idle, err := q.ConsumerGroup("idle")
+	assert.NoError(t, err)
+	active, err := q.ConsumerGroup("active")
+	assert.NoError(t, err)
+	_, err = active.GetNext()
+	assert.NoError(t, err)
+	lastRead := active.Activity().LastRead()
+	assert.NoError(t, q.SetOption("cursor_ttl", "20ms"))
+	assert.NoError(t, q.Maintain())
+	time.Sleep(20 * time.Millisecond)
+
+	// idle time is not reset by reopening the queue
+	q.Close()
+	q, err = CGQueueOpen(cgQueueName, dir)
+	assert.NoError(t, err)
+	active, err = q.FindConsumerGroup(active.Name)
+	assert.NoError(t, err)
+	assert.Equal(t, lastRead.UnixNano(), active.Activity().LastRead().UnixNano())
+
+	assert.NoError(t, q.Maintain())
+	assert.EqualValues(t, 2, q.ExpiredConsumerGroups())
+	_, err = q.FindConsumerGroup(idle.Name)
+	assert.Equal(t, ErrNotFound, err)
+}
+
 func Test_CGQueue_Path(t *testing.T) {
 	q, err := setupCGQueue(t, 10)
 	defer cleanupCGQueue(q)
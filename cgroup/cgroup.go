@@ -5,6 +5,8 @@ import (
 	"errors"
 	"regexp"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/syndtr/goleveldb/leveldb"
 
@@ -14,11 +16,12 @@ import (
 const (
 	cgCursorPrefix      = "_c:"
 	cgFailedReadsPrefix = "_r:"
+	cgActivityPrefix    = "_a:"
 )
 
 var (
-	// make sure ConsumerGroup implements queue.Consumer interface
-	_ queue.Consumer = (*ConsumerGroup)(nil)
+	// make sure ConsumerGroup implements GroupConsumer interface
+	_ GroupConsumer = (*ConsumerGroup)(nil)
 
 	alphaNumericRegexp = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
 )
@@ -37,17 +40,20 @@ type ConsumerGroup struct {
 	storage     *leveldb.DB
 	cursor      uint64
 	failedReads *queue.Queue
+	groups      *messageGroups
 	cursorKey   []byte
+	activity    *Activity
 }
 
 // NewConsumerGroup initializes a consumer group
 func NewConsumerGroup(name string, source *queue.Queue,
 	storage *leveldb.DB) (*ConsumerGroup, error) {
 	cg := &ConsumerGroup{
-		Name:    name,
-		stats:   &queue.Stats{},
-		source:  source,
-		storage: storage,
+		Name:     name,
+		stats:    &queue.Stats{},
+		activity: newActivity(time.Now()),
+		source:   source,
+		storage:  storage,
 	}
 	cg.cursorKey = []byte(cgCursorPrefix + cg.Name)
 	return cg, cg.initialize()
@@ -55,20 +61,42 @@ func NewConsumerGroup(name string, source *queue.Queue,
 
 // GetNext returns next value for that particular consumer group
 func (cg *ConsumerGroup) GetNext() ([]byte, error) {
+	item, err := cg.GetNextItem()
+	if err != nil {
+		return nil, err
+	}
+	return item.Value, nil
+}
+
+// GetNextItem returns next item for that particular consumer group
+func (cg *ConsumerGroup) GetNextItem() (*queue.Item, error) {
 	cg.Lock()
 	defer cg.Unlock()
+	return cg.read(cg.groups.next(cg.readNext, false))
+}
 
-	// serve from failedReads first
-	if !cg.failedReads.IsEmpty() {
-		return cg.failedReads.GetNext()
-	}
+// OpenNext returns next item for a reliable read. The message group
+// of the item stays open until it's closed or aborted.
+func (cg *ConsumerGroup) OpenNext() (*queue.Item, error) {
+	cg.Lock()
+	defer cg.Unlock()
+	return cg.read(cg.groups.next(cg.readNext, true))
+}
 
-	item, err := cg.readNextItemFromSource()
-	if err != nil {
-		return nil, err
-	}
-	cg.updateCursor(item.ID)
-	return item.Value, err
+// CloseGroup confirms an open item of the message group
+func (cg *ConsumerGroup) CloseGroup(group string) error {
+	return cg.groups.close(group)
+}
+
+// CloseGroupItem confirms an item of the message group by its ID,
+// it's safe to call it again for the same item
+func (cg *ConsumerGroup) CloseGroupItem(group string, id uint64) error {
+	return cg.groups.closeItem(group, id)
+}
+
+// AbortGroup makes an open item of the message group available again
+func (cg *ConsumerGroup) AbortGroup(group string) error {
+	return cg.groups.abort(group)
 }
 
 // Peek returns next value without updating the cursor
@@ -76,6 +104,10 @@ func (cg *ConsumerGroup) Peek() ([]byte, error) {
 	cg.Lock()
 	defer cg.Unlock()
 
+	if item, ok := cg.groups.peek(); ok {
+		return item.Value, nil
+	}
+
 	// serve from failedReads first
 	if !cg.failedReads.IsEmpty() {
 		return cg.failedReads.Peek()
@@ -98,10 +130,9 @@ func (cg *ConsumerGroup) Length() uint64 {
 	cg.RLock()
 	defer cg.RUnlock()
 	if cg.cursor < cg.source.Head() {
-		return cg.source.Length() + cg.failedReads.Length()
+		return cg.source.Length() + cg.failedReads.Length() + cg.groups.length()
 	}
-	return cg.source.Tail() - cg.cursor + cg.failedReads.Length()
-
+	return cg.source.Tail() - cg.cursor + cg.failedReads.Length() + cg.groups.length()
 }
 
 // IsEmpty returns false if thereis no more items for this consumer group
@@ -109,6 +140,67 @@ func (cg *ConsumerGroup) IsEmpty() bool {
 	return cg.Length() < 1
 }
 
+// Cursor returns ID of the last source item read by consumer group
+func (cg *ConsumerGroup) Cursor() uint64 {
+	cg.RLock()
+	defer cg.RUnlock()
+	return cg.cursor
+}
+
+// SetCursor moves the cursor, so the next read returns the first
+// item after it. Cursor is limited by source queue head and tail.
+// Failed reads and items of busy message groups are kept.
+func (cg *ConsumerGroup) SetCursor(cursor uint64) error {
+	cg.Lock()
+	defer cg.Unlock()
+	return cg.seek(cursor)
+}
+
+// MoveCursor moves the cursor by delta item IDs back or forward
+func (cg *ConsumerGroup) MoveCursor(delta int64) error {
+	cg.Lock()
+	defer cg.Unlock()
+	if delta < 0 && uint64(-delta) > cg.cursor {
+		return cg.seek(0)
+	}
+	return cg.seek(uint64(int64(cg.cursor) + delta))
+}
+
+// SeekTime moves the cursor, so the next read returns
+// the first item enqueued at or after t
+func (cg *ConsumerGroup) SeekTime(t time.Time) error {
+	id, err := cg.source.SearchTime(t)
+	if err != nil {
+		return err
+	}
+	return cg.SetCursor(id - 1)
+}
+
+// LagBytes returns total size of unread source queue items
+func (cg *ConsumerGroup) LagBytes() uint64 {
+	return cg.source.BytesAfter(cg.Cursor())
+}
+
+// OldestUnread returns enqueue time of the oldest unread source
+// queue item, zero time if there are no such items
+func (cg *ConsumerGroup) OldestUnread() time.Time {
+	item, err := cg.source.ReadItemAfter(cg.Cursor())
+	if err != nil {
+		return time.Time{}
+	}
+	return item.Timestamp
+}
+
+// FailedReads returns number of failed reliable reads waiting to be served
+func (cg *ConsumerGroup) FailedReads() uint64 {
+	return cg.failedReads.Length()
+}
+
+// Activity returns consumer group read activity
+func (cg *ConsumerGroup) Activity() *Activity {
+	return cg.activity
+}
+
 // Source returns source queue Consumer interface
 func (cg *ConsumerGroup) Source() queue.Consumer {
 	return cg.source
@@ -119,22 +211,46 @@ func (cg *ConsumerGroup) Stats() *queue.Stats {
 	return cg.stats
 }
 
-func (cg *ConsumerGroup) readNextItemFromSource() (*queue.Item, error) {
-	// if cursor is behind of source queue head
-	if cg.cursor < cg.source.Head() {
-		item, err := cg.source.ReadItemByOffset(0)
-		return item, err
+// read records successful read activity
+func (cg *ConsumerGroup) read(item *queue.Item, err error) (*queue.Item, error) {
+	if err == nil {
+		cg.activity.read(time.Now())
 	}
-	// otherwise read next item
-	item, err := cg.source.ReadItemByID(cg.cursor + 1)
 	return item, err
 }
 
+// readNext reads next item from failedReads or from the source queue
+// and advances the cursor, hold is called before the item is consumed
+func (cg *ConsumerGroup) readNext(hold func(*queue.Item) error) (*queue.Item, error) {
+	// serve from failedReads first
+	if !cg.failedReads.IsEmpty() {
+		return cg.failedReads.GetNextItemFunc(hold)
+	}
+
+	item, err := cg.readNextItemFromSource()
+	if err != nil {
+		return nil, err
+	}
+	if err = hold(item); err != nil {
+		return nil, err
+	}
+	return item, cg.updateCursor(item.ID)
+}
+
+// readNextItemFromSource reads next item after the cursor,
+// if cursor is behind of source queue head, it reads the head item
+func (cg *ConsumerGroup) readNextItemFromSource() (*queue.Item, error) {
+	return cg.source.ReadItemAfter(cg.cursor)
+}
+
 // Flush resets consumer group
 func (cg *ConsumerGroup) Flush() error {
 	cg.Lock()
 	defer cg.Unlock()
 	err := cg.failedReads.DeleteAll()
+	if err == nil {
+		err = cg.groups.deleteAll()
+	}
 	if err == nil {
 		return cg.updateCursor(cg.source.Head())
 	}
@@ -146,9 +262,15 @@ func (cg *ConsumerGroup) Delete() error {
 	cg.Lock()
 	defer cg.Unlock()
 	err := cg.failedReads.DeleteAll()
+	if err == nil {
+		err = cg.groups.deleteAll()
+	}
 	if err == nil {
 		cg.cursor = 0
-		return cg.storage.Delete(cg.cursorKey, nil)
+		batch := new(leveldb.Batch)
+		batch.Delete(cg.cursorKey)
+		batch.Delete(cg.activityKey())
+		return cg.storage.Write(batch, nil)
 	}
 	return err
 }
@@ -162,10 +284,17 @@ func (cg *ConsumerGroup) initialize() error {
 	if err != nil {
 		return err
 	}
+	if err = cg.loadActivity(); err != nil {
+		return err
+	}
 
 	cg.failedReads, err = queue.OpenShared(cg.Name,
 		cgFailedReadsPrefix+cg.Name+":", cg.storage)
+	if err != nil {
+		return err
+	}
 
+	cg.groups, err = newMessageGroups(cg.storage, cg.Name)
 	return err
 }
 
@@ -181,6 +310,48 @@ func (cg *ConsumerGroup) loadCursor() error {
 	return nil
 }
 
+// loadActivity restores persisted read activity, so idle time
+// of the consumer group is not reset by restarts
+func (cg *ConsumerGroup) loadActivity() error {
+	value, err := cg.storage.Get(cg.activityKey(), nil)
+	if err == leveldb.ErrNotFound {
+		return cg.storage.Put(cg.activityKey(), cg.activity.encode(), nil)
+	}
+	if err != nil {
+		return err
+	}
+	cg.activity.decode(value)
+	return nil
+}
+
+// saveActivity persists the time of the last read if it changed
+func (cg *ConsumerGroup) saveActivity() error {
+	a := cg.activity
+	lastRead := atomic.LoadInt64(&a.lastRead)
+	if lastRead == atomic.LoadInt64(&a.saved) {
+		return nil
+	}
+	if err := cg.storage.Put(cg.activityKey(), a.encode(), nil); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&a.saved, lastRead)
+	return nil
+}
+
+func (cg *ConsumerGroup) activityKey() []byte {
+	return []byte(cgActivityPrefix + cg.Name)
+}
+
+func (cg *ConsumerGroup) seek(cursor uint64) error {
+	if cursor < cg.source.Head() {
+		cursor = cg.source.Head()
+	}
+	if cursor > cg.source.Tail() {
+		cursor = cg.source.Tail()
+	}
+	return cg.updateCursor(cursor)
+}
+
 func (cg *ConsumerGroup) updateCursor(cursor uint64) error {
 	cg.cursor = cursor
 	value := make([]byte, 8)
@@ -4,6 +4,7 @@ import (
 	"os"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/syndtr/goleveldb/leveldb"
@@ -244,3 +245,76 @@ func Test_ConsumerGroup_loadAndSaveCursor(t *testing.T) {
 	assert.NoError(t, err)
 	assert.EqualValues(t, 2, cg.cursor)
 }
+
+func Test_ConsumerGroup_SkipsDeletedItems(t *testing.T) {
+	cg, err := setupConsumerGroup(t, cgName, 5)
+	defer cleanupConsumerGroup(cg)
+	assert.NoError(t, err)
+
+	assert.NoError(t, cg.source.DeleteItemByID(3))
+	assert.NoError(t, cg.source.DeleteItemByID(4))
+
+	value, err := cg.GetNext()
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(value))
+	assert.EqualValues(t, 2, cg.Cursor())
+
+	value, err = cg.GetNext()
+	assert.NoError(t, err)
+	assert.Equal(t, "4", string(value))
+	assert.EqualValues(t, 5, cg.Cursor())
+}
+
+func Test_ConsumerGroup_SetCursor(t *testing.T) {
+	cg, err := setupConsumerGroup(t, cgName, 10)
+	defer cleanupConsumerGroup(cg)
+	assert.NoError(t, err)
+
+	assert.NoError(t, cg.SetCursor(5))
+	value, err := cg.GetNext()
+	assert.NoError(t, err)
+	assert.Equal(t, "5", string(value))
+
+	// cursor is limited by source head and tail
+	assert.NoError(t, cg.SetCursor(0))
+	assert.EqualValues(t, 1, cg.Cursor())
+	assert.NoError(t, cg.SetCursor(100))
+	assert.EqualValues(t, 11, cg.Cursor())
+	assert.True(t, cg.IsEmpty())
+
+	assert.NoError(t, cg.MoveCursor(-3))
+	assert.EqualValues(t, 8, cg.Cursor())
+	value, err = cg.GetNext()
+	assert.NoError(t, err)
+	assert.Equal(t, "8", string(value))
+
+	assert.NoError(t, cg.MoveCursor(1))
+	assert.EqualValues(t, 10, cg.Cursor())
+	assert.NoError(t, cg.MoveCursor(-100))
+	assert.EqualValues(t, 1, cg.Cursor())
+}
+
+func Test_ConsumerGroup_SeekTime(t *testing.T) {
+	q, err := setupCGQueue(t, 0)
+	defer cleanupCGQueue(q)
+	assert.NoError(t, err)
+
+	start := time.Now()
+	for i := 1; i <= 5; i++ {
+		item := &queue.Item{
+			Value:     []byte(strconv.Itoa(i)),
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+		}
+		assert.NoError(t, q.EnqueueItem(item))
+	}
+
+	cg, err := q.ConsumerGroup(cgName)
+	assert.NoError(t, err)
+	assert.NoError(t, cg.SeekTime(start.Add(3*time.Minute)))
+	value, err := cg.GetNext()
+	assert.NoError(t, err)
+	assert.Equal(t, "3", string(value))
+
+	assert.NoError(t, cg.SeekTime(start.Add(time.Hour)))
+	assert.True(t, cg.IsEmpty())
+}
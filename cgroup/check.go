@@ -0,0 +1,118 @@
+package cgroup
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+
+	"github.com/bogdanovich/siberite/queue"
+)
+
+// CheckReport describes problems found in queue data
+type CheckReport struct {
+	*queue.CheckReport
+	// InvalidCursors are cursor keys with an invalid consumer group name,
+	// an invalid value or a position beyond the queue tail
+	InvalidCursors []string
+	// OrphanedKeys are failed reads and message group keys
+	// of consumer groups that don't have a cursor
+	OrphanedKeys []string
+}
+
+// OK returns true if no problems were found
+func (r *CheckReport) OK() bool {
+	return r.CheckReport.OK() && len(r.InvalidCursors) == 0 && len(r.OrphanedKeys) == 0
+}
+
+// Check inspects data of a closed queue without opening its consumer
+// groups, so it works for queues that fail to open. If repair is true,
+// found problems are fixed: see queue.Check, invalid cursors and
+// orphaned keys are deleted, cursors beyond the tail are moved to the tail.
+func Check(name string, dataDir string, repair bool) (*CheckReport, error) {
+	path := dataDir + "/" + name
+	q, err := queue.Open(name, path, &queue.Options{Timestamps: true})
+	if err != nil {
+		q.Close()
+		return nil, err
+	}
+	defer q.Close()
+
+	storage, err := leveldb.OpenFile(path+"/_.metadata", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer storage.Close()
+
+	report := &CheckReport{}
+	if report.CheckReport, err = q.Check(repair); err != nil {
+		return report, err
+	}
+
+	batch := new(leveldb.Batch)
+	cursors, err := checkCursors(storage, q.Tail(), report, batch)
+	if err != nil {
+		return report, err
+	}
+	for _, prefix := range []string{cgFailedReadsPrefix, cgGroupPrefix} {
+		if err = checkOwners(storage, prefix, cursors, report, batch); err != nil {
+			return report, err
+		}
+	}
+	if !repair || batch.Len() == 0 {
+		return report, nil
+	}
+	return report, storage.Write(batch, nil)
+}
+
+// checkCursors returns names of valid cursors
+func checkCursors(storage *leveldb.DB, tail uint64, report *CheckReport,
+	batch *leveldb.Batch) (map[string]bool, error) {
+
+	cursors := map[string]bool{}
+	iter := storage.NewIterator(util.BytesPrefix([]byte(cgCursorPrefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		key := append([]byte{}, iter.Key()...)
+		name := string(key[len(cgCursorPrefix):])
+		value := iter.Value()
+		switch {
+		case name == "" || alphaNumericRegexp.MatchString(name) || len(value) != 8:
+			report.InvalidCursors = append(report.InvalidCursors, name)
+			batch.Delete(key)
+		case binary.BigEndian.Uint64(value) > tail:
+			report.InvalidCursors = append(report.InvalidCursors, name)
+			cursor := make([]byte, 8)
+			binary.BigEndian.PutUint64(cursor, tail)
+			batch.Put(key, cursor)
+			cursors[name] = true
+		default:
+			cursors[name] = true
+		}
+	}
+	return cursors, iter.Error()
+}
+
+// checkOwners finds keys with the prefix that belong to
+// consumer groups without a cursor
+func checkOwners(storage *leveldb.DB, prefix string, cursors map[string]bool,
+	report *CheckReport, batch *leveldb.Batch) error {
+
+	iter := storage.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		key := iter.Key()[len(prefix):]
+		end := bytes.IndexByte(key, ':')
+		// message groups of the queue itself have an empty owner
+		if end == 0 && prefix == cgGroupPrefix {
+			continue
+		}
+		if end > 0 && cursors[string(key[:end])] {
+			continue
+		}
+		report.OrphanedKeys = append(report.OrphanedKeys, string(iter.Key()))
+		batch.Delete(append([]byte{}, iter.Key()...))
+	}
+	return iter.Error()
+}
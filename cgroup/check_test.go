@@ -0,0 +1,52 @@
+package cgroup
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Check(t *testing.T) {
+	name := "check"
+	q, err := CGQueueOpen(name, dir)
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir + "/" + name)
+
+	for _, value := range []string{"1", "2", "3"} {
+		q.Enqueue([]byte(value))
+	}
+	cg, _ := q.ConsumerGroup("cg1")
+	value, _ := cg.GetNext()
+	assert.NoError(t, cg.PutBack(value))
+	storage := q.CGManager.storage
+	assert.NoError(t, storage.Put([]byte(cgCursorPrefix+"bad-name"), make([]byte, 8), nil))
+	assert.NoError(t, storage.Put([]byte(cgCursorPrefix+"far"), []byte{0, 0, 0, 0, 0, 0, 0, 9}, nil))
+	assert.NoError(t, storage.Put([]byte(cgFailedReadsPrefix+"ghost:key"), []byte("1"), nil))
+	assert.NoError(t, storage.Put([]byte(cgGroupPrefix+"ghost:group:key"), []byte("1"), nil))
+	q.Close()
+
+	_, err = CGQueueOpen(name, dir)
+	assert.Equal(t, ErrInvalidName, err)
+
+	report, err := Check(name, dir, false)
+	assert.NoError(t, err)
+	assert.False(t, report.OK())
+	assert.True(t, report.CheckReport.OK())
+	assert.Equal(t, []string{"bad-name", "far"}, report.InvalidCursors)
+	assert.Equal(t, []string{"_r:ghost:key", "_g:ghost:group:key"}, report.OrphanedKeys)
+
+	_, err = Check(name, dir, true)
+	assert.NoError(t, err)
+	report, err = Check(name, dir, false)
+	assert.NoError(t, err)
+	assert.True(t, report.OK())
+
+	q, err = CGQueueOpen(name, dir)
+	assert.NoError(t, err)
+	defer q.Close()
+	cg, _ = q.ConsumerGroup("cg1")
+	assert.EqualValues(t, 1, cg.FailedReads())
+	cg, _ = q.ConsumerGroup("far")
+	assert.EqualValues(t, 3, cg.Cursor())
+}
@@ -0,0 +1,99 @@
+package cgroup
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	cgDedupKeyPrefix  = "_d:k:"
+	cgDedupTimePrefix = "_d:t:"
+)
+
+// dedupIndex keeps idempotency keys of recently stored items.
+// Every key is stored twice: by key (pointing to its store time
+// and stored item ID) and by store time, so expired keys
+// can be removed in order.
+type dedupIndex struct {
+	sync.Mutex
+	storage *leveldb.DB
+}
+
+func newDedupIndex(storage *leveldb.DB) *dedupIndex {
+	return &dedupIndex{storage: storage}
+}
+
+// seen returns true and stored item ID if the key was stored
+// after the cutoff time
+func (d *dedupIndex) seen(key string, cutoff time.Time) (uint64, bool, error) {
+	value, err := d.storage.Get(d.keyKey(key), nil)
+	if err == leveldb.ErrNotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if int64(binary.BigEndian.Uint64(value[:8])) <= cutoff.UnixNano() {
+		return 0, false, nil
+	}
+	if len(value) < 16 {
+		return 0, true, nil
+	}
+	return binary.BigEndian.Uint64(value[8:]), true, nil
+}
+
+// add records the key with provided store time and stored item ID
+func (d *dedupIndex) add(key string, now time.Time, id uint64) error {
+	batch := new(leveldb.Batch)
+	// remove time index entry of the previous occurrence
+	if value, err := d.storage.Get(d.keyKey(key), nil); err == nil {
+		batch.Delete(d.timeKey(key, value[:8]))
+	}
+	value := make([]byte, 16)
+	binary.BigEndian.PutUint64(value[:8], uint64(now.UnixNano()))
+	binary.BigEndian.PutUint64(value[8:], id)
+	batch.Put(d.keyKey(key), value)
+	batch.Put(d.timeKey(key, value[:8]), nil)
+	return d.storage.Write(batch, nil)
+}
+
+// expire deletes keys stored before the cutoff time
+func (d *dedupIndex) expire(cutoff time.Time) (int, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	iter := d.storage.NewIterator(util.BytesPrefix([]byte(cgDedupTimePrefix)), nil)
+	defer iter.Release()
+
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		timeKey := iter.Key()[len(cgDedupTimePrefix):]
+		if int64(binary.BigEndian.Uint64(timeKey[:8])) > cutoff.UnixNano() {
+			break
+		}
+		batch.Delete(append([]byte{}, iter.Key()...))
+		batch.Delete(d.keyKey(string(timeKey[8:])))
+	}
+	if err := iter.Error(); err != nil {
+		return 0, err
+	}
+	if batch.Len() == 0 {
+		return 0, nil
+	}
+	return batch.Len() / 2, d.storage.Write(batch, nil)
+}
+
+func (d *dedupIndex) keyKey(key string) []byte {
+	return []byte(cgDedupKeyPrefix + key)
+}
+
+func (d *dedupIndex) timeKey(key string, storeTime []byte) []byte {
+	timeKey := make([]byte, 0, len(cgDedupTimePrefix)+8+len(key))
+	timeKey = append(timeKey, cgDedupTimePrefix...)
+	timeKey = append(timeKey, storeTime...)
+	return append(timeKey, key...)
+}
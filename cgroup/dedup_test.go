@@ -0,0 +1,94 @@
+package cgroup
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bogdanovich/siberite/queue"
+)
+
+func Test_CGQueue_EnqueueOnce(t *testing.T) {
+	q, err := setupCGQueue(t, 0)
+	defer cleanupCGQueue(q)
+	assert.NoError(t, err)
+
+	stored, err := q.EnqueueOnce("key1", &queue.Item{Value: []byte("1")})
+	assert.NoError(t, err)
+	assert.True(t, stored)
+
+	item := &queue.Item{Value: []byte("1")}
+	stored, err = q.EnqueueOnce("key1", item)
+	assert.NoError(t, err)
+	assert.False(t, stored)
+	assert.EqualValues(t, 2, item.ID)
+
+	stored, err = q.EnqueueOnce("key2", &queue.Item{Value: []byte("2")})
+	assert.NoError(t, err)
+	assert.True(t, stored)
+	assert.EqualValues(t, 2, q.Length())
+
+	// key is accepted again once it's out of the dedup window
+	assert.NoError(t, q.SetOption("dedup_window", "1ms"))
+	time.Sleep(2 * time.Millisecond)
+	stored, err = q.EnqueueOnce("key1", &queue.Item{Value: []byte("1")})
+	assert.NoError(t, err)
+	assert.True(t, stored)
+	assert.EqualValues(t, 3, q.Length())
+
+	// deduplication is disabled with zero window
+	assert.NoError(t, q.SetOption("dedup_window", "0s"))
+	stored, err = q.EnqueueOnce("key1", &queue.Item{Value: []byte("1")})
+	assert.NoError(t, err)
+	assert.True(t, stored)
+	assert.EqualValues(t, 4, q.Length())
+}
+
+func Test_CGQueue_Maintain_ExpiresDedupKeys(t *testing.T) {
+	q, err := setupCGQueue(t, 0)
+	defer cleanupCGQueue(q)
+	assert.NoError(t, err)
+
+	for _, key := range []string{"a", "b", "c"} {
+		_, err = q.EnqueueOnce(key, &queue.Item{Value: []byte(key)})
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, q.Maintain())
+	count, err := q.dedup.expire(time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	time.Sleep(2 * time.Millisecond)
+	assert.NoError(t, q.SetOption("dedup_window", "1ms"))
+	count, err = q.dedup.expire(time.Now().Add(-time.Millisecond))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	_, seen, err := q.dedup.seen("a", time.Time{})
+	assert.NoError(t, err)
+	assert.False(t, seen)
+}
+
+func Test_CGQueue_EnqueueOnce_Flush(t *testing.T) {
+	q, err := setupCGQueue(t, 0)
+	defer cleanupCGQueue(q)
+	assert.NoError(t, err)
+	assert.NoError(t, q.SetOption("dedup_window", "1h"))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, q.Flush())
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		_, err = q.EnqueueOnce(fmt.Sprintf("key%d", i), &queue.Item{Value: []byte("1")})
+		assert.NoError(t, err)
+	}
+	<-done
+	assert.Equal(t, "1h0m0s", q.Options().DedupWindow.String())
+}
@@ -0,0 +1,123 @@
+package cgroup
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+
+	"github.com/bogdanovich/siberite/queue"
+)
+
+const cgFanoutPrefix = "_f:"
+
+// ErrFanoutToSelf is returned on an attempt to add a queue
+// as a fanout child of itself
+var ErrFanoutToSelf = errors.New("cgroup: queue can't be a fanout child of itself")
+
+// ErrFanoutNotFound is returned when a queue is not a fanout child
+var ErrFanoutNotFound = errors.New("cgroup: fanout child not found")
+
+// fanout keeps child queues that receive a copy of every item
+// stored to the queue, along with the number of delivered copies
+type fanout struct {
+	sync.RWMutex
+	storage   *leveldb.DB
+	children  []string
+	delivered map[string]*uint64
+}
+
+func newFanout(storage *leveldb.DB) (*fanout, error) {
+	f := &fanout{storage: storage, delivered: make(map[string]*uint64)}
+	return f, f.load()
+}
+
+// FanoutChildren returns names of fanout child queues
+func (q *CGQueue) FanoutChildren() []string {
+	q.fanout.RLock()
+	defer q.fanout.RUnlock()
+	return append([]string{}, q.fanout.children...)
+}
+
+// AddFanout adds a child queue, items stored to the queue
+// are copied to the child queue
+func (q *CGQueue) AddFanout(child string) error {
+	if err := queue.ValidateName(child); err != nil {
+		return err
+	}
+	if child == q.Name {
+		return ErrFanoutToSelf
+	}
+	f := q.fanout
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.delivered[child]; ok {
+		return nil
+	}
+	if err := f.storage.Put([]byte(cgFanoutPrefix+child), nil, nil); err != nil {
+		return err
+	}
+	f.add(child)
+	return nil
+}
+
+// RemoveFanout removes a child queue, returns ErrFanoutNotFound
+// if the queue is not a fanout child
+func (q *CGQueue) RemoveFanout(child string) error {
+	f := q.fanout
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.delivered[child]; !ok {
+		return ErrFanoutNotFound
+	}
+	if err := f.storage.Delete([]byte(cgFanoutPrefix+child), nil); err != nil {
+		return err
+	}
+	delete(f.delivered, child)
+	for i, name := range f.children {
+		if name == child {
+			f.children = append(f.children[:i], f.children[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// CountDelivered records copies of items delivered to the child queue
+func (q *CGQueue) CountDelivered(child string, n uint64) {
+	q.fanout.RLock()
+	defer q.fanout.RUnlock()
+	if counter, ok := q.fanout.delivered[child]; ok {
+		atomic.AddUint64(counter, n)
+	}
+}
+
+// Delivered returns the number of item copies delivered
+// to every fanout child since the queue was opened
+func (q *CGQueue) Delivered() map[string]uint64 {
+	q.fanout.RLock()
+	defer q.fanout.RUnlock()
+	delivered := make(map[string]uint64, len(q.fanout.delivered))
+	for child, counter := range q.fanout.delivered {
+		delivered[child] = atomic.LoadUint64(counter)
+	}
+	return delivered
+}
+
+func (f *fanout) add(child string) {
+	f.delivered[child] = new(uint64)
+	f.children = append(f.children, child)
+	sort.Strings(f.children)
+}
+
+func (f *fanout) load() error {
+	iter := f.storage.NewIterator(util.BytesPrefix([]byte(cgFanoutPrefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		f.add(string(iter.Key()[len(cgFanoutPrefix):]))
+	}
+	return iter.Error()
+}
@@ -0,0 +1,37 @@
+package cgroup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bogdanovich/siberite/queue"
+)
+
+func Test_CGQueue_Fanout(t *testing.T) {
+	q, err := CGQueueOpen("events", dir)
+	assert.NoError(t, err)
+
+	assert.NoError(t, q.AddFanout("events_billing"))
+	assert.NoError(t, q.AddFanout("events_audit"))
+	assert.NoError(t, q.AddFanout("events_audit"))
+	assert.Equal(t, ErrFanoutToSelf, q.AddFanout("events"))
+	assert.Equal(t, queue.ErrInvalidName, q.AddFanout("events/1"))
+	assert.Equal(t, []string{"events_audit", "events_billing"}, q.FanoutChildren())
+
+	q.CountDelivered("events_audit", 2)
+	q.CountDelivered("unknown", 1)
+	assert.Equal(t, map[string]uint64{"events_audit": 2, "events_billing": 0}, q.Delivered())
+
+	// children are persistent and survive flush
+	q.Close()
+	q, err = CGQueueOpen("events", dir)
+	assert.NoError(t, err)
+	assert.NoError(t, q.Flush())
+	assert.Equal(t, []string{"events_audit", "events_billing"}, q.FanoutChildren())
+
+	assert.NoError(t, q.RemoveFanout("events_audit"))
+	assert.Equal(t, ErrFanoutNotFound, q.RemoveFanout("events_audit"))
+	assert.Equal(t, []string{"events_billing"}, q.FanoutChildren())
+	q.Drop()
+}
@@ -0,0 +1,363 @@
+package cgroup
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+
+	"github.com/bogdanovich/siberite/queue"
+)
+
+const (
+	cgGroupPrefix = "_g:"
+
+	maxGroupNameLength = 100
+)
+
+var validGroupNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_\-]+$`)
+
+// ErrInvalidGroup is returned when message group name is not valid
+var ErrInvalidGroup = errors.New("cgroup: invalid message group name")
+
+// GroupConsumer represents a consumer that supports message groups:
+// items of the same group are served in order and at most
+// one item of a group can be open at a time
+type GroupConsumer interface {
+	queue.Consumer
+	OpenNext() (*queue.Item, error)
+	CloseGroup(group string) error
+	CloseGroupItem(group string, id uint64) error
+	AbortGroup(group string) error
+}
+
+// ValidateGroupName checks message group name
+func ValidateGroupName(name string) error {
+	if len(name) > maxGroupNameLength || !validGroupNameRegexp.MatchString(name) {
+		return ErrInvalidGroup
+	}
+	return nil
+}
+
+// itemSource consumes next item of a queue or consumer group,
+// hold is called before the item is removed from the source
+// and an error of hold keeps the item in the source
+type itemSource func(hold func(*queue.Item) error) (*queue.Item, error)
+
+// messageGroups keeps items of message groups that can't be served
+// right away because another item of the same group is open.
+// Such items are moved into a persistent per-group queue and are
+// served before any new item of that group.
+// An open item of a group queue stays at its head until it is closed.
+// An item of an idle group is opened right from the source, it's copied
+// to the head of its group queue once the group gets busy or the item
+// is aborted, so its group order is kept.
+type messageGroups struct {
+	sync.Mutex
+	storage *leveldb.DB
+	prefix  string
+	open    map[string]bool
+	direct  map[string]*directItem
+	held    map[string]*queue.Queue
+	order   []string
+}
+
+// directItem is an open item served right from the source,
+// held is the ID of its copy in the group queue, if it was copied
+type directItem struct {
+	item *queue.Item
+	held uint64
+}
+
+func newMessageGroups(storage *leveldb.DB, owner string) (*messageGroups, error) {
+	g := &messageGroups{
+		storage: storage,
+		prefix:  cgGroupPrefix + owner + ":",
+		open:    make(map[string]bool),
+		direct:  make(map[string]*directItem),
+		held:    make(map[string]*queue.Queue),
+	}
+	return g, g.initialize()
+}
+
+// next returns next item honoring message groups.
+// When open is true, the group of the item stays open until it's closed
+// or aborted. An item of a busy group is durably copied to its group queue
+// before it's removed from the source, so a crash may duplicate, but never
+// lose it. Items of busy groups are moved aside until an item is found.
+func (g *messageGroups) next(source itemSource, open bool) (*queue.Item, error) {
+	g.Lock()
+	defer g.Unlock()
+
+	// serve held groups first
+	for _, group := range g.order {
+		if g.open[group] {
+			continue
+		}
+		return g.serveHeld(group, open)
+	}
+
+	for {
+		held := false
+		item, err := source(func(item *queue.Item) error {
+			if item.Group == "" {
+				return nil
+			}
+			if _, isHeld := g.held[item.Group]; !isHeld && !g.open[item.Group] {
+				return nil
+			}
+			held = true
+			return g.hold(item)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !held {
+			if open && item.Group != "" {
+				g.open[item.Group] = true
+				g.direct[item.Group] = &directItem{item: item}
+			}
+			return item, nil
+		}
+		if !g.open[item.Group] {
+			return g.serveHeld(item.Group, open)
+		}
+	}
+}
+
+// peek returns an item that is most likely to be served next
+func (g *messageGroups) peek() (*queue.Item, bool) {
+	g.Lock()
+	defer g.Unlock()
+	for _, group := range g.order {
+		if !g.open[group] {
+			item, err := g.held[group].PeekItem()
+			return item, err == nil
+		}
+	}
+	return nil, false
+}
+
+// close removes an open item of the group
+func (g *messageGroups) close(group string) error {
+	g.Lock()
+	defer g.Unlock()
+	delete(g.open, group)
+	if d, ok := g.direct[group]; ok {
+		delete(g.direct, group)
+		if d.held == 0 {
+			return nil
+		}
+	}
+	h, ok := g.held[group]
+	if !ok {
+		return nil
+	}
+	if _, err := h.GetNext(); err != nil {
+		return err
+	}
+	if h.IsEmpty() {
+		g.remove(group)
+	}
+	return nil
+}
+
+// closeItem removes an item of the group by its ID in the group queue,
+// or in the source for an item opened right from it, so closing the
+// same item twice never removes another item
+func (g *messageGroups) closeItem(group string, id uint64) error {
+	g.Lock()
+	defer g.Unlock()
+	if d, ok := g.direct[group]; ok {
+		if d.item.ID != id {
+			return nil
+		}
+		delete(g.direct, group)
+		if d.held == 0 {
+			delete(g.open, group)
+			return nil
+		}
+		id = d.held
+	}
+	delete(g.open, group)
+	h, ok := g.held[group]
+	if !ok {
+		return nil
+	}
+	err := h.DeleteItemByID(id)
+	if err == queue.ErrItemNotFound || err == queue.ErrIDOutOfBounds || err == queue.ErrIsEmpty {
+		err = nil
+	}
+	if err == nil && h.IsEmpty() {
+		g.remove(group)
+	}
+	return err
+}
+
+// abort makes an open item of the group available again,
+// an item opened right from the source is copied to its group queue
+func (g *messageGroups) abort(group string) error {
+	g.Lock()
+	defer g.Unlock()
+	if err := g.release(group); err != nil {
+		return err
+	}
+	delete(g.open, group)
+	return nil
+}
+
+// releaseAll copies items opened right from the source to their
+// group queues, so they are served again after the queue is reopened
+func (g *messageGroups) releaseAll() error {
+	g.Lock()
+	defer g.Unlock()
+	for group := range g.direct {
+		if err := g.release(group); err != nil {
+			return err
+		}
+		delete(g.open, group)
+	}
+	return nil
+}
+
+// release copies an item of the group opened right from
+// the source to the head of its group queue
+func (g *messageGroups) release(group string) error {
+	d, ok := g.direct[group]
+	if !ok {
+		return nil
+	}
+	if d.held == 0 {
+		if err := g.copyDirect(group, d); err != nil {
+			return err
+		}
+	}
+	delete(g.direct, group)
+	return nil
+}
+
+// copyDirect copies an item opened right from the source to its group
+// queue, it's done before any other item of the group is held, so
+// the group queue doesn't exist yet and the copy becomes its head
+func (g *messageGroups) copyDirect(group string, d *directItem) error {
+	h, err := g.openHeld(group)
+	if err != nil {
+		return err
+	}
+	item := &queue.Item{Value: d.item.Value, Group: group}
+	if err = h.EnqueueSynced(item); err != nil {
+		return err
+	}
+	d.held = item.ID
+	return nil
+}
+
+// length returns total number of held items excluding open ones
+func (g *messageGroups) length() uint64 {
+	g.Lock()
+	defer g.Unlock()
+	var length uint64
+	for group, h := range g.held {
+		length += h.Length()
+		if g.open[group] {
+			length--
+		}
+	}
+	return length
+}
+
+// deleteAll removes all held items
+func (g *messageGroups) deleteAll() error {
+	g.Lock()
+	defer g.Unlock()
+	for group, h := range g.held {
+		if err := h.DeleteAll(); err != nil {
+			return err
+		}
+		g.remove(group)
+	}
+	g.open = make(map[string]bool)
+	g.direct = make(map[string]*directItem)
+	return nil
+}
+
+func (g *messageGroups) serveHeld(group string, open bool) (*queue.Item, error) {
+	h := g.held[group]
+	if open {
+		item, err := h.PeekItem()
+		if err == nil {
+			g.open[group] = true
+		}
+		return item, err
+	}
+	item, err := h.GetNextItem()
+	if err != nil {
+		return nil, err
+	}
+	if h.IsEmpty() {
+		g.remove(group)
+	}
+	return item, nil
+}
+
+func (g *messageGroups) hold(item *queue.Item) error {
+	if d, ok := g.direct[item.Group]; ok && d.held == 0 {
+		if err := g.copyDirect(item.Group, d); err != nil {
+			return err
+		}
+	}
+	h, ok := g.held[item.Group]
+	if !ok {
+		var err error
+		h, err = g.openHeld(item.Group)
+		if err != nil {
+			return err
+		}
+	}
+	return h.EnqueueSynced(&queue.Item{Value: item.Value, Group: item.Group})
+}
+
+func (g *messageGroups) openHeld(group string) (*queue.Queue, error) {
+	h, err := queue.OpenShared(group, g.prefix+group+":", g.storage)
+	if err != nil {
+		return nil, err
+	}
+	g.held[group] = h
+	g.order = append(g.order, group)
+	return h, nil
+}
+
+func (g *messageGroups) remove(group string) {
+	delete(g.held, group)
+	for i, name := range g.order {
+		if name == group {
+			g.order = append(g.order[:i], g.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// initialize loads held groups, skipping over the keys of every found group
+func (g *messageGroups) initialize() error {
+	prefix := []byte(g.prefix)
+	iter := g.storage.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+
+	for ok := iter.First(); ok; {
+		key := iter.Key()[len(prefix):]
+		end := bytes.IndexByte(key, ':')
+		if end < 0 {
+			return ErrInvalidGroup
+		}
+		group := string(key[:end])
+		if _, err := g.openHeld(group); err != nil {
+			return err
+		}
+		// ';' follows ':', so it seeks past all keys of the group
+		ok = iter.Seek([]byte(g.prefix + group + ";"))
+	}
+	return iter.Error()
+}
@@ -0,0 +1,188 @@
+package cgroup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bogdanovich/siberite/queue"
+)
+
+func enqueueGroupItems(t *testing.T, q *CGQueue, items [][2]string) {
+	for _, item := range items {
+		err := q.EnqueueItem(&queue.Item{Value: []byte(item[0]), Group: item[1]})
+		assert.NoError(t, err)
+	}
+}
+
+func Test_ValidateGroupName(t *testing.T) {
+	assert.NoError(t, ValidateGroupName("account-1_A"))
+	assert.Equal(t, ErrInvalidGroup, ValidateGroupName(""))
+	assert.Equal(t, ErrInvalidGroup, ValidateGroupName("a:b"))
+}
+
+func testGroupConsumer(t *testing.T, q GroupConsumer) {
+	// a1 is open, so other items of group "a" are skipped
+	item, err := q.OpenNext()
+	assert.NoError(t, err)
+	assert.Equal(t, "a1", string(item.Value))
+	assert.Equal(t, "a", item.Group)
+
+	item, err = q.OpenNext()
+	assert.NoError(t, err)
+	assert.Equal(t, "b1", string(item.Value))
+
+	value, err := q.GetNext()
+	assert.NoError(t, err)
+	assert.Equal(t, "x", string(value))
+
+	_, err = q.OpenNext()
+	assert.Error(t, err)
+	assert.EqualValues(t, 2, q.Length())
+
+	// aborted item is served again
+	q.AbortGroup("a")
+	item, err = q.OpenNext()
+	assert.NoError(t, err)
+	assert.Equal(t, "a1", string(item.Value))
+
+	assert.NoError(t, q.CloseGroup("a"))
+	assert.NoError(t, q.CloseGroup("b"))
+	assert.EqualValues(t, 2, q.Length())
+
+	item, err = q.OpenNext()
+	assert.NoError(t, err)
+	assert.Equal(t, "a2", string(item.Value))
+
+	value, err = q.GetNext()
+	assert.NoError(t, err)
+	assert.Equal(t, "b2", string(value))
+	assert.NoError(t, q.CloseGroup("a"))
+	assert.True(t, q.IsEmpty())
+}
+
+var groupItems = [][2]string{{"a1", "a"}, {"b1", "b"}, {"a2", "a"}, {"x", ""}, {"b2", "b"}}
+
+func Test_CGQueue_MessageGroups(t *testing.T) {
+	q, err := setupCGQueue(t, 0)
+	defer cleanupCGQueue(q)
+	assert.NoError(t, err)
+
+	enqueueGroupItems(t, q, groupItems)
+	testGroupConsumer(t, q)
+}
+
+func Test_ConsumerGroup_MessageGroups(t *testing.T) {
+	q, err := setupCGQueue(t, 0)
+	defer cleanupCGQueue(q)
+	assert.NoError(t, err)
+
+	enqueueGroupItems(t, q, groupItems)
+	cg, err := q.ConsumerGroup("cg")
+	assert.NoError(t, err)
+	testGroupConsumer(t, cg)
+	assert.EqualValues(t, 5, q.Length())
+}
+
+func Test_MessageGroups_Persistence(t *testing.T) {
+	q, err := setupCGQueue(t, 0)
+	defer cleanupCGQueue(q)
+	assert.NoError(t, err)
+
+	enqueueGroupItems(t, q, groupItems)
+	item, err := q.OpenNext()
+	assert.NoError(t, err)
+	assert.Equal(t, "a1", string(item.Value))
+	_, err = q.OpenNext()
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, q.Length())
+
+	// open items are returned back after restart
+	q.Close()
+	q, err = CGQueueOpen(cgQueueName, dir)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 5, q.Length())
+
+	for _, expected := range []string{"a1", "b1", "a2", "x", "b2"} {
+		value, err := q.GetNext()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(value))
+	}
+	assert.True(t, q.IsEmpty())
+}
+
+func Test_MessageGroups_CloseGroupItem(t *testing.T) {
+	q, err := setupCGQueue(t, 0)
+	defer cleanupCGQueue(q)
+	assert.NoError(t, err)
+
+	enqueueGroupItems(t, q, groupItems)
+	item, err := q.OpenNext()
+	assert.NoError(t, err)
+	assert.Equal(t, "a1", string(item.Value))
+
+	// closing the same item twice removes it only once
+	assert.NoError(t, q.CloseGroupItem("a", item.ID))
+	assert.NoError(t, q.CloseGroupItem("a", item.ID))
+	assert.EqualValues(t, 4, q.Length())
+
+	for _, expected := range []string{"b1", "a2", "x", "b2"} {
+		value, err := q.GetNext()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(value))
+	}
+	assert.NoError(t, q.CloseGroupItem("unknown", 1))
+}
+
+func Test_MessageGroups_BusyBurst(t *testing.T) {
+	q, err := setupCGQueue(t, 0)
+	defer cleanupCGQueue(q)
+	assert.NoError(t, err)
+
+	items := [][2]string{{"a0", "a"}}
+	for i := 1; i <= 150; i++ {
+		items = append(items, [2]string{"a", "a"})
+	}
+	enqueueGroupItems(t, q, append(items, [2]string{"x", ""}))
+	_, err = q.OpenNext()
+	assert.NoError(t, err)
+
+	// items of a busy group never hide an item that follows them
+	item, err := q.OpenNext()
+	assert.NoError(t, err)
+	assert.Equal(t, "x", string(item.Value))
+	assert.EqualValues(t, 150, q.Length())
+}
+
+func Test_MessageGroups_Direct(t *testing.T) {
+	q, err := setupCGQueue(t, 0)
+	defer cleanupCGQueue(q)
+	assert.NoError(t, err)
+
+	enqueueGroupItems(t, q, [][2]string{{"a1", "a"}, {"b1", "b"}})
+	a1, err := q.OpenNext()
+	assert.NoError(t, err)
+	assert.Equal(t, "a1", string(a1.Value))
+
+	// an item of an idle group is served right from the source
+	assert.Empty(t, q.groups.held)
+	assert.EqualValues(t, 1, q.Length())
+	assert.NoError(t, q.CloseGroupItem("a", a1.ID+1))
+	assert.True(t, q.groups.open["a"])
+	assert.NoError(t, q.CloseGroupItem("a", a1.ID))
+	assert.False(t, q.groups.open["a"])
+
+	// an aborted item is served again before other items of its group
+	b1, err := q.OpenNext()
+	assert.NoError(t, err)
+	assert.NoError(t, q.AbortGroup("b"))
+	enqueueGroupItems(t, q, [][2]string{{"b2", "b"}})
+	item, err := q.OpenNext()
+	assert.NoError(t, err)
+	assert.Equal(t, "b1", string(item.Value))
+	assert.NotEqual(t, b1.ID, item.ID)
+	assert.NoError(t, q.CloseGroupItem("b", item.ID))
+	item, err = q.OpenNext()
+	assert.NoError(t, err)
+	assert.Equal(t, "b2", string(item.Value))
+}
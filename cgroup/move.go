@@ -0,0 +1,58 @@
+package cgroup
+
+import (
+	"errors"
+
+	"github.com/bogdanovich/siberite/queue"
+)
+
+// ErrProtectedMove is returned on an attempt to move items
+// of a queue with protect_cursors option
+var ErrProtectedMove = errors.New("cgroup: can't move items of a queue with protect_cursors option")
+
+// moveBatchSize limits the number of items moved with a single write
+const moveBatchSize = 1000
+
+// Move transfers up to n items from the queue head to the tail of dst,
+// n < 1 moves all items. Items are deleted from the queue only after
+// they are durably written to dst. Returns the number of moved items.
+func (q *CGQueue) Move(dst *CGQueue, n int) (int, error) {
+	q.Lock()
+	defer q.Unlock()
+	opts := q.Options()
+	if opts.Mode == ModeLog {
+		return 0, ErrLogMode
+	}
+	if opts.ProtectCursors {
+		return 0, ErrProtectedMove
+	}
+	return move(q.Queue, dst, n)
+}
+
+// MoveFailedReads transfers up to n failed reads of the consumer group
+// to the tail of dst, n < 1 moves all failed reads
+func (cg *ConsumerGroup) MoveFailedReads(dst *CGQueue, n int) (int, error) {
+	cg.Lock()
+	defer cg.Unlock()
+	return move(cg.failedReads, dst, n)
+}
+
+// move transfers items in batches, every batch is moved atomically
+func move(src *queue.Queue, dst *CGQueue, n int) (int, error) {
+	if n < 1 {
+		n = int(src.Length())
+	}
+	moved := 0
+	for moved < n {
+		size := n - moved
+		if size > moveBatchSize {
+			size = moveBatchSize
+		}
+		m, err := src.Move(dst.Queue, size)
+		moved += m
+		if err != nil || m < size {
+			return moved, err
+		}
+	}
+	return moved, nil
+}
@@ -0,0 +1,56 @@
+package cgroup
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CGQueue_Move(t *testing.T) {
+	q, err := CGQueueOpen("errors", dir)
+	assert.NoError(t, err)
+	defer q.Drop()
+	dst, err := CGQueueOpen("retry", dir)
+	assert.NoError(t, err)
+	defer dst.Drop()
+
+	for i := 1; i <= moveBatchSize+5; i++ {
+		q.Enqueue([]byte(fmt.Sprintf("%d", i)))
+	}
+	n, err := q.Move(dst, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	n, err = q.Move(dst, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, moveBatchSize+2, n)
+	assert.True(t, q.IsEmpty())
+	assert.EqualValues(t, moveBatchSize+5, dst.Length())
+	value, _ := dst.GetNext()
+	assert.Equal(t, "1", string(value))
+
+	cg, _ := dst.ConsumerGroup("cg1")
+	values := [][]byte{}
+	for i := 0; i < 3; i++ {
+		value, _ = cg.GetNext()
+		values = append(values, value)
+	}
+	for _, value := range values {
+		assert.NoError(t, cg.PutBack(value))
+	}
+	n, err = cg.MoveFailedReads(q, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.EqualValues(t, 1, cg.FailedReads())
+	for _, expected := range []string{"2", "3"} {
+		value, _ = q.GetNext()
+		assert.Equal(t, expected, string(value))
+	}
+
+	assert.NoError(t, dst.SetOption("protect_cursors", "true"))
+	_, err = dst.Move(q, 1)
+	assert.Equal(t, ErrProtectedMove, err)
+	assert.NoError(t, dst.SetOption("mode", ModeLog))
+	_, err = dst.Move(q, 1)
+	assert.Equal(t, ErrLogMode, err)
+}
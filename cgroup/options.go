@@ -0,0 +1,230 @@
+package cgroup
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const cgOptionPrefix = "_o:"
+
+// Queue modes
+const (
+	// ModeQueue is a regular queue mode: a read removes an item
+	ModeQueue = "queue"
+	// ModeLog is a log mode: items are read with durable cursors only
+	// and are removed according to retention settings
+	ModeLog = "log"
+)
+
+// ErrUnknownOption is returned when queue option name is not recognized
+var ErrUnknownOption = errors.New("cgroup: unknown queue option")
+
+// ErrInvalidOptionValue is returned when queue option value can't be parsed
+var ErrInvalidOptionValue = errors.New("cgroup: invalid queue option value")
+
+// Options represents persistent per-queue settings
+type Options struct {
+	// DedupWindow is a period during which a repeated
+	// idempotency key is not enqueued again (0 disables deduplication)
+	DedupWindow time.Duration
+	// Mode is ModeQueue or ModeLog
+	Mode string
+	// RetentionAge, RetentionBytes and RetentionItems limit
+	// age, total size and number of queue items (0 means no limit)
+	RetentionAge   time.Duration
+	RetentionBytes uint64
+	RetentionItems uint64
+	// ProtectCursors defers removal of items consumed by
+	// plain reads until every consumer group has read them
+	ProtectCursors bool
+	// AutoCreateCursors allows to create consumer groups on first read,
+	// otherwise they have to be created explicitly
+	AutoCreateCursors bool
+	// CursorTTL is a period after which a consumer group without
+	// reads is deleted (0 disables expiration)
+	CursorTTL time.Duration
+	// Timestamps enables storing enqueue time and stream offset
+	// of items, see NeedsTimestamps
+	Timestamps bool
+}
+
+// NeedsTimestamps returns true if items have to be stored with enqueue
+// time and stream offset: for log mode, age and size retention or if
+// enabled explicitly for time seek and lag stats of durable cursors
+func (o Options) NeedsTimestamps() bool {
+	return o.Timestamps || o.Mode == ModeLog || o.RetentionAge > 0 || o.RetentionBytes > 0
+}
+
+// HasRetention returns true if any retention limit is set
+func (o Options) HasRetention() bool {
+	return o.RetentionAge > 0 || o.RetentionBytes > 0 || o.RetentionItems > 0
+}
+
+// DefaultOptions returns options used for queues without explicit settings
+func DefaultOptions() Options {
+	return Options{
+		DedupWindow:       5 * time.Minute,
+		Mode:              ModeQueue,
+		AutoCreateCursors: true,
+	}
+}
+
+type option struct {
+	get func(o *Options) string
+	set func(o *Options, value string) error
+}
+
+// OptionNames lists supported queue option names in display order
+var OptionNames = []string{
+	"dedup_window", "mode", "retention_age", "retention_bytes", "retention_items",
+	"protect_cursors", "auto_create_cursors", "cursor_ttl", "timestamps",
+}
+
+var optionsTable = map[string]option{
+	"dedup_window":        durationOption(func(o *Options) *time.Duration { return &o.DedupWindow }),
+	"mode":                enumOption(func(o *Options) *string { return &o.Mode }, ModeQueue, ModeLog),
+	"retention_age":       durationOption(func(o *Options) *time.Duration { return &o.RetentionAge }),
+	"retention_bytes":     uintOption(func(o *Options) *uint64 { return &o.RetentionBytes }),
+	"retention_items":     uintOption(func(o *Options) *uint64 { return &o.RetentionItems }),
+	"protect_cursors":     boolOption(func(o *Options) *bool { return &o.ProtectCursors }),
+	"auto_create_cursors": boolOption(func(o *Options) *bool { return &o.AutoCreateCursors }),
+	"cursor_ttl":          durationOption(func(o *Options) *time.Duration { return &o.CursorTTL }),
+	"timestamps":          boolOption(func(o *Options) *bool { return &o.Timestamps }),
+}
+
+func durationOption(field func(o *Options) *time.Duration) option {
+	return option{
+		get: func(o *Options) string { return field(o).String() },
+		set: func(o *Options, value string) error {
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				return ErrInvalidOptionValue
+			}
+			*field(o) = d
+			return nil
+		},
+	}
+}
+
+func uintOption(field func(o *Options) *uint64) option {
+	return option{
+		get: func(o *Options) string { return strconv.FormatUint(*field(o), 10) },
+		set: func(o *Options, value string) error {
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return ErrInvalidOptionValue
+			}
+			*field(o) = n
+			return nil
+		},
+	}
+}
+
+func boolOption(field func(o *Options) *bool) option {
+	return option{
+		get: func(o *Options) string { return strconv.FormatBool(*field(o)) },
+		set: func(o *Options, value string) error {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return ErrInvalidOptionValue
+			}
+			*field(o) = b
+			return nil
+		},
+	}
+}
+
+func enumOption(field func(o *Options) *string, values ...string) option {
+	return option{
+		get: func(o *Options) string { return *field(o) },
+		set: func(o *Options, value string) error {
+			for _, v := range values {
+				if v == value {
+					*field(o) = value
+					return nil
+				}
+			}
+			return ErrInvalidOptionValue
+		},
+	}
+}
+
+// Get returns string representation of the named option
+func (o *Options) Get(name string) (string, error) {
+	opt, ok := optionsTable[name]
+	if !ok {
+		return "", ErrUnknownOption
+	}
+	return opt.get(o), nil
+}
+
+// Set parses and assigns the named option
+func (o *Options) Set(name, value string) error {
+	opt, ok := optionsTable[name]
+	if !ok {
+		return ErrUnknownOption
+	}
+	return opt.set(o, value)
+}
+
+// optionStore keeps queue options in the queue metadata database
+type optionStore struct {
+	sync.RWMutex
+	opts    Options
+	storage *leveldb.DB
+}
+
+func newOptionStore(storage *leveldb.DB) (*optionStore, error) {
+	s := &optionStore{opts: DefaultOptions(), storage: storage}
+	return s, s.load()
+}
+
+func (s *optionStore) get() Options {
+	s.RLock()
+	defer s.RUnlock()
+	return s.opts
+}
+
+func (s *optionStore) set(name, value string) error {
+	s.Lock()
+	defer s.Unlock()
+	opts := s.opts
+	if err := opts.Set(name, value); err != nil {
+		return err
+	}
+	if err := s.storage.Put([]byte(cgOptionPrefix+name), []byte(value), nil); err != nil {
+		return err
+	}
+	s.opts = opts
+	return nil
+}
+
+// raw returns explicitly assigned option values
+func (s *optionStore) raw() (map[string]string, error) {
+	values := make(map[string]string)
+	iter := s.storage.NewIterator(util.BytesPrefix([]byte(cgOptionPrefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		values[string(iter.Key()[len(cgOptionPrefix):])] = string(iter.Value())
+	}
+	return values, iter.Error()
+}
+
+func (s *optionStore) load() error {
+	values, err := s.raw()
+	if err != nil {
+		return err
+	}
+	for name, value := range values {
+		// ignore options that are no longer supported
+		if err = s.opts.Set(name, value); err == ErrInvalidOptionValue {
+			return err
+		}
+	}
+	return nil
+}
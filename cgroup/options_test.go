@@ -0,0 +1,60 @@
+package cgroup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Options_GetSet(t *testing.T) {
+	opts := DefaultOptions()
+
+	value, err := opts.Get("dedup_window")
+	assert.NoError(t, err)
+	assert.Equal(t, "5m0s", value)
+
+	assert.NoError(t, opts.Set("dedup_window", "30s"))
+	assert.Equal(t, 30*time.Second, opts.DedupWindow)
+
+	assert.Equal(t, ErrInvalidOptionValue, opts.Set("dedup_window", "abc"))
+	assert.Equal(t, ErrInvalidOptionValue, opts.Set("dedup_window", "-1s"))
+	assert.Equal(t, ErrUnknownOption, opts.Set("unknown", "1"))
+
+	_, err = opts.Get("unknown")
+	assert.Equal(t, ErrUnknownOption, err)
+
+	assert.NoError(t, opts.Set("mode", "log"))
+	assert.Equal(t, ModeLog, opts.Mode)
+	assert.Equal(t, ErrInvalidOptionValue, opts.Set("mode", "stream"))
+
+	assert.False(t, opts.HasRetention())
+	assert.NoError(t, opts.Set("retention_items", "100"))
+	assert.EqualValues(t, 100, opts.RetentionItems)
+	assert.True(t, opts.HasRetention())
+	assert.Equal(t, ErrInvalidOptionValue, opts.Set("retention_bytes", "-1"))
+
+	value, err = opts.Get("retention_items")
+	assert.NoError(t, err)
+	assert.Equal(t, "100", value)
+}
+
+func Test_CGQueue_SetOption(t *testing.T) {
+	q, err := setupCGQueue(t, 2)
+	defer cleanupCGQueue(q)
+	assert.NoError(t, err)
+
+	assert.Equal(t, DefaultOptions(), q.Options())
+	assert.NoError(t, q.SetOption("dedup_window", "1h"))
+	assert.Equal(t, time.Hour, q.Options().DedupWindow)
+
+	// options are persisted
+	q.Close()
+	q, err = CGQueueOpen(cgQueueName, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour, q.Options().DedupWindow)
+
+	// and survive queue flush
+	assert.NoError(t, q.Flush())
+	assert.Equal(t, time.Hour, q.Options().DedupWindow)
+}
@@ -0,0 +1,150 @@
+package cgroup
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+
+	"github.com/bogdanovich/siberite/queue"
+)
+
+const (
+	cgReaderCursorKey     = "_p:c"
+	cgReaderPutBackPrefix = "_p:r:"
+)
+
+// protectedReader consumes queue items when protect_cursors option is
+// enabled. Reads only move a persistent cursor, items are removed from
+// the queue later, once every consumer group has read them.
+// Returned items are kept in a separate queue and served first.
+type protectedReader struct {
+	sync.Mutex
+	source  *queue.Queue
+	storage *leveldb.DB
+	cursor  uint64
+	putBack *queue.Queue
+}
+
+func newProtectedReader(source *queue.Queue, storage *leveldb.DB) (*protectedReader, error) {
+	r := &protectedReader{source: source, storage: storage}
+	return r, r.initialize()
+}
+
+// next returns next unread item and moves the cursor,
+// hold is called before the item is consumed
+func (r *protectedReader) next(hold func(*queue.Item) error) (*queue.Item, error) {
+	r.Lock()
+	defer r.Unlock()
+	if !r.putBack.IsEmpty() {
+		return r.putBack.GetNextItemFunc(hold)
+	}
+	item, err := r.source.ReadItemAfter(r.cursor)
+	if err != nil {
+		return nil, err
+	}
+	if err = hold(item); err != nil {
+		return nil, err
+	}
+	return item, r.updateCursor(item.ID)
+}
+
+// peek returns next unread item without moving the cursor
+func (r *protectedReader) peek() (*queue.Item, error) {
+	r.Lock()
+	defer r.Unlock()
+	if !r.putBack.IsEmpty() {
+		return r.putBack.PeekItem()
+	}
+	return r.source.ReadItemAfter(r.cursor)
+}
+
+// position returns reader cursor
+func (r *protectedReader) position() uint64 {
+	r.Lock()
+	defer r.Unlock()
+	return r.cursor
+}
+
+// length returns number of unread items
+func (r *protectedReader) length() uint64 {
+	r.Lock()
+	defer r.Unlock()
+	return r.putBack.Length() + r.source.Length() - r.retained()
+}
+
+// retained returns number of items that were read,
+// but are still kept in the queue
+func (r *protectedReader) retained() uint64 {
+	head, length := r.source.Head(), r.source.Length()
+	if r.cursor <= head {
+		return 0
+	}
+	if r.cursor-head > length {
+		return length
+	}
+	return r.cursor - head
+}
+
+// reset moves the cursor to the queue head
+func (r *protectedReader) reset() error {
+	r.Lock()
+	defer r.Unlock()
+	return r.updateCursor(r.source.Head())
+}
+
+// release removes items that were read from the queue and
+// returns put back items to the queue head
+func (r *protectedReader) release() error {
+	r.Lock()
+	defer r.Unlock()
+	cursor := r.cursor
+	_, err := r.source.Trim(func(item *queue.Item, length, size uint64) bool {
+		return item.ID <= cursor
+	}, int(r.retained()))
+	if err != nil {
+		return err
+	}
+	values := [][]byte{}
+	for !r.putBack.IsEmpty() {
+		value, err := r.putBack.GetNext()
+		if err != nil {
+			return err
+		}
+		values = append(values, value)
+	}
+	for i := len(values) - 1; i >= 0; i-- {
+		if err = r.source.PutBack(values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *protectedReader) initialize() error {
+	var err error
+	r.putBack, err = queue.OpenShared("reader", cgReaderPutBackPrefix, r.storage)
+	if err != nil {
+		return err
+	}
+	value, err := r.storage.Get([]byte(cgReaderCursorKey), nil)
+	if err == leveldb.ErrNotFound {
+		r.cursor = r.source.Head()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	r.cursor = binary.BigEndian.Uint64(value)
+	return nil
+}
+
+func (r *protectedReader) updateCursor(cursor uint64) error {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, cursor)
+	err := r.storage.Put([]byte(cgReaderCursorKey), value, nil)
+	if err == nil {
+		r.cursor = cursor
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package cgroup
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bogdanovich/siberite/queue"
+)
+
+func Test_CGQueue_ProtectCursors(t *testing.T) {
+	q, err := setupCGQueue(t, 5)
+	defer cleanupCGQueue(q)
+	assert.NoError(t, err)
+	assert.NoError(t, q.SetOption("protect_cursors", "true"))
+
+	cg, err := q.ConsumerGroup("analytics")
+	assert.NoError(t, err)
+
+	for i := 1; i <= 3; i++ {
+		value, err := q.GetNext()
+		assert.NoError(t, err)
+		assert.Equal(t, strconv.Itoa(i), string(value))
+	}
+	assert.EqualValues(t, 2, q.Length())
+	assert.EqualValues(t, 3, q.Retained())
+
+	assert.NoError(t, q.PutBack([]byte("3")))
+	assert.EqualValues(t, 3, q.Length())
+	value, err := q.Peek()
+	assert.NoError(t, err)
+	assert.Equal(t, "3", string(value))
+
+	items, err := q.Browse(0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"failed:3", "source:4", "source:5"}, pageValues(items))
+
+	// items are kept until consumer group reads them
+	assert.NoError(t, q.Maintain())
+	assert.EqualValues(t, 3, q.Retained())
+
+	value, err = cg.GetNext()
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(value))
+	assert.NoError(t, cg.updateCursor(3))
+
+	assert.NoError(t, q.Maintain())
+	assert.EqualValues(t, 1, q.Retained())
+	assert.EqualValues(t, 3, q.Queue.Head())
+
+	// reader state is persisted
+	q.Close()
+	q, err = CGQueueOpen(cgQueueName, dir)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, q.Length())
+	value, err = q.GetNext()
+	assert.NoError(t, err)
+	assert.Equal(t, "3", string(value))
+
+	// disabling removes consumed items and returns put back items
+	assert.NoError(t, q.PutBack([]byte("3")))
+	assert.NoError(t, q.SetOption("protect_cursors", "false"))
+	assert.EqualValues(t, 0, q.Retained())
+	assert.EqualValues(t, 3, q.Length())
+	for _, expected := range []string{"3", "4", "5"} {
+		value, err = q.GetNext()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(value))
+	}
+}
+
+func Test_CGQueue_ProtectCursors_ReadItemByID(t *testing.T) {
+	q, err := setupCGQueue(t, 3)
+	defer cleanupCGQueue(q)
+	assert.NoError(t, err)
+	assert.NoError(t, q.SetOption("protect_cursors", "true"))
+	_, err = q.ConsumerGroup("analytics")
+	assert.NoError(t, err)
+
+	_, err = q.GetNext()
+	assert.NoError(t, err)
+
+	// a consumed item is kept for the cursor, but can't be read by ID
+	_, err = q.ReadItemByID(q.Head() + 1)
+	assert.Equal(t, queue.ErrIDOutOfBounds, err)
+	item, err := q.ReadItemByID(q.Head() + 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", string(item.Value))
+}
@@ -0,0 +1,43 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/bogdanovich/siberite/dump"
+	"github.com/bogdanovich/siberite/repository"
+)
+
+// Backup handles BACKUP command
+// Command: BACKUP <file name> [<queue> ...]
+// writes a consistent archive of listed queues, or of all queues,
+// to the server backup directory
+// Response:
+// BACKUP <archive path>
+// END
+func (c *Controller) Backup(input []string) error {
+	if len(input) < 2 {
+		return ErrInvalidCommand
+	}
+
+	path, err := c.repo.Backup(input[1], input[2:])
+	if err != nil {
+		log.Printf("Command %s: %s ", input[0], err.Error())
+		return backupError(err)
+	}
+
+	fmt.Fprintf(c.rw.Writer, "BACKUP %s\r\n", path)
+	fmt.Fprint(c.rw.Writer, endMessage)
+	return c.rw.Writer.Flush()
+}
+
+// backupError converts an error returned by backup, export or import
+func backupError(err error) error {
+	switch {
+	case err == repository.ErrBackupDisabled, err == repository.ErrInvalidBackupName,
+		err == dump.ErrUnknownFormat, err == dump.ErrInvalidDump, os.IsNotExist(err):
+		return NewError(clientError, err)
+	}
+	return lookupError(err)
+}
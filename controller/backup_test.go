@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bogdanovich/siberite/repository"
+)
+
+func Test_Controller_Backup(t *testing.T) {
+	backupDir := "./test_backups"
+	assert.NoError(t, os.MkdirAll(backupDir, 0777))
+	defer os.RemoveAll(backupDir)
+
+	repo, err := repository.NewRepositoryWithOptions(dir, &repository.Options{BackupDir: backupDir})
+	assert.NoError(t, err)
+	defer cleanupControllerTest(repo)
+
+	mockTCPConn := newMockTCPConn()
+	controller := NewSession(mockTCPConn, repo)
+	repo.GetQueue("test")
+
+	err = controller.Backup([]string{"backup", "test.gz", "test"})
+	assert.NoError(t, err)
+	assert.Equal(t, "BACKUP "+filepath.Join(backupDir, "test.gz")+"\r\nEND\r\n",
+		mockTCPConn.WriteBuffer.String())
+	_, err = os.Stat(filepath.Join(backupDir, "test.gz"))
+	assert.NoError(t, err)
+
+	err = controller.Backup([]string{"backup", "test.gz", "unknown"})
+	assert.EqualError(t, err, "CLIENT_ERROR repository: queue does not exist")
+
+	err = controller.Backup([]string{"backup", "../test.gz"})
+	assert.EqualError(t, err, "CLIENT_ERROR repository: invalid backup name")
+
+	err = controller.Backup([]string{"backup"})
+	assert.Equal(t, ErrInvalidCommand, err)
+}
+
+func Test_Controller_ExportImport(t *testing.T) {
+	backupDir := "./test_backups"
+	assert.NoError(t, os.MkdirAll(backupDir, 0777))
+	defer os.RemoveAll(backupDir)
+
+	repo, err := repository.NewRepositoryWithOptions(dir, &repository.Options{BackupDir: backupDir})
+	assert.NoError(t, err)
+	defer cleanupControllerTest(repo)
+
+	mockTCPConn := newMockTCPConn()
+	controller := NewSession(mockTCPConn, repo)
+	q, _ := repo.GetQueue("test")
+	q.Enqueue([]byte("1"))
+	q.Enqueue([]byte("2"))
+
+	err = controller.Export([]string{"export", "test", "test.jsonl"})
+	assert.NoError(t, err)
+	assert.Equal(t, "EXPORTED 2 "+filepath.Join(backupDir, "test.jsonl")+"\r\nEND\r\n",
+		mockTCPConn.WriteBuffer.String())
+	mockTCPConn.WriteBuffer.Reset()
+
+	err = controller.Import([]string{"import", "copy", "test.jsonl"})
+	assert.NoError(t, err)
+	assert.Equal(t, "IMPORTED 2\r\nEND\r\n", mockTCPConn.WriteBuffer.String())
+	copied, _ := repo.GetQueue("copy")
+	assert.EqualValues(t, 2, copied.Length())
+
+	err = controller.Export([]string{"export", "test.unknown", "test.jsonl"})
+	assert.EqualError(t, err, "CLIENT_ERROR cgroup: consumer group not found")
+
+	err = controller.Export([]string{"export", "test", "test.csv"})
+	assert.EqualError(t, err, "CLIENT_ERROR dump: unknown format, use .jsonl or .dump file extension")
+
+	err = controller.Import([]string{"import", "copy", "missing.dump"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "CLIENT_ERROR")
+
+	err = controller.Import([]string{"import", "copy"})
+	assert.Equal(t, ErrInvalidCommand, err)
+}
@@ -0,0 +1,67 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/bogdanovich/siberite/queue"
+	"github.com/bogdanovich/siberite/repository"
+)
+
+// Bind handles BIND and UNBIND commands
+// Command: BIND <queue> <pattern>
+// stores items published with SET <routing key>/route to the queue
+// if the routing key matches the pattern. Pattern words are separated
+// by ":", "*" matches one word and "#" matches zero or more words.
+// Command: UNBIND <queue> <pattern>
+// removes the binding
+// Response:
+// END
+func (c *Controller) Bind(input []string) error {
+	if len(input) != 3 {
+		return ErrInvalidCommand
+	}
+	var err error
+	if input[0] == "unbind" {
+		err = c.repo.Unbind(input[1], input[2])
+	} else {
+		err = c.repo.Bind(input[1], input[2])
+	}
+	if err != nil {
+		log.Printf("Command %s: %s ", input[0], err.Error())
+		return bindError(err)
+	}
+	log.Printf("queue %s: %s %s", input[1], input[0], input[2])
+	fmt.Fprint(c.rw.Writer, endMessage)
+	return c.rw.Writer.Flush()
+}
+
+// Bindings handles BINDINGS command
+// Command: BINDINGS [<queue>]
+// lists bindings of the queue or all bindings
+// Response:
+// BINDING <queue> <pattern>
+// ...
+// END
+func (c *Controller) Bindings(input []string) error {
+	if len(input) > 2 {
+		return ErrInvalidCommand
+	}
+	key := ""
+	if len(input) == 2 {
+		key = input[1]
+	}
+	for _, b := range c.repo.Bindings(key) {
+		fmt.Fprintf(c.rw.Writer, "BINDING %s %s\r\n", b.Queue, b.Pattern)
+	}
+	fmt.Fprint(c.rw.Writer, endMessage)
+	return c.rw.Writer.Flush()
+}
+
+func bindError(err error) error {
+	switch err {
+	case repository.ErrInvalidPattern, repository.ErrBindingNotFound, queue.ErrInvalidName:
+		return NewError(clientError, err)
+	}
+	return lookupError(err)
+}
@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Controller_Bind(t *testing.T) {
+	repo, controller, mockTCPConn := setupControllerTest(t, 0)
+	defer cleanupControllerTest(repo)
+
+	err = controller.Bind([]string{"bind", "orders_created", "orders:*:created"})
+	assert.NoError(t, err)
+	assert.Equal(t, "END\r\n", mockTCPConn.WriteBuffer.String())
+	controller.Bind([]string{"bind", "test", "orders:#"})
+
+	mockTCPConn.WriteBuffer.Reset()
+	err = controller.Bindings([]string{"bindings"})
+	assert.NoError(t, err)
+	assert.Equal(t, "BINDING orders_created orders:*:created\r\n"+
+		"BINDING test orders:#\r\nEND\r\n", mockTCPConn.WriteBuffer.String())
+
+	mockTCPConn.WriteBuffer.Reset()
+	err = controller.Bindings([]string{"bindings", "test"})
+	assert.NoError(t, err)
+	assert.Equal(t, "BINDING test orders:#\r\nEND\r\n", mockTCPConn.WriteBuffer.String())
+
+	mockTCPConn.WriteBuffer.Reset()
+	fmt.Fprintf(&mockTCPConn.ReadBuffer, "1\r\n")
+	err = controller.Set([]string{"set", "orders:eu:created/route/return_id", "0", "0", "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "STORED 1 1\r\n", mockTCPConn.WriteBuffer.String())
+
+	mockTCPConn.WriteBuffer.Reset()
+	fmt.Fprintf(&mockTCPConn.ReadBuffer, "2\r\n")
+	err = controller.Set([]string{"set", "users:created/route/return_id", "0", "0", "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "STORED\r\n", mockTCPConn.WriteBuffer.String())
+	assert.EqualValues(t, 1, repo.Unmatched())
+
+	for _, name := range []string{"orders_created", "test"} {
+		q, _ := repo.GetQueue(name)
+		assert.EqualValues(t, 1, q.Length(), name)
+	}
+
+	mockTCPConn.WriteBuffer.Reset()
+	err = controller.Bind([]string{"unbind", "test", "orders:#"})
+	assert.NoError(t, err)
+	assert.Equal(t, "END\r\n", mockTCPConn.WriteBuffer.String())
+
+	err = controller.Bind([]string{"unbind", "test", "orders:#"})
+	assert.EqualError(t, err, "CLIENT_ERROR repository: binding not found")
+
+	err = controller.Bind([]string{"bind", "test", "orders:*x"})
+	assert.EqualError(t, err, "CLIENT_ERROR repository: invalid binding pattern")
+
+	err = controller.Set([]string{"set", "orders+users/route", "0", "0", "1"})
+	assert.Equal(t, ErrInvalidCommand, err)
+
+	err = controller.Bind([]string{"bind", "test"})
+	assert.Equal(t, ErrInvalidCommand, err)
+}
@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/bogdanovich/siberite/cgroup"
+)
+
+// Config handles CONFIG command
+// Command: CONFIG <queue>
+// Response:
+// OPTION <name> <value>
+// ...
+// END
+//
+// Command: CONFIG <queue> <name> <value>
+// Response:
+// END
+func (c *Controller) Config(input []string) error {
+	if len(input) != 2 && len(input) != 4 {
+		return ErrInvalidCommand
+	}
+
+	q, err := c.repo.GetQueue(input[1])
+	if err != nil {
+		return lookupError(err)
+	}
+
+	if len(input) == 4 {
+		err = q.SetOption(input[2], input[3])
+		if err == cgroup.ErrUnknownOption || err == cgroup.ErrInvalidOptionValue {
+			return NewError(clientError, err)
+		}
+		if err != nil {
+			return NewError(commonError, err)
+		}
+	} else {
+		options := q.Options()
+		for _, name := range cgroup.OptionNames {
+			value, _ := options.Get(name)
+			fmt.Fprintf(c.rw.Writer, "OPTION %s %s\r\n", name, value)
+		}
+	}
+
+	fmt.Fprint(c.rw.Writer, endMessage)
+	return c.rw.Writer.Flush()
+}
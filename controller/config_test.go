@@ -0,0 +1,45 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Controller_Config(t *testing.T) {
+	repo, controller, mockTCPConn := setupControllerTest(t, 0)
+	defer cleanupControllerTest(repo)
+
+	err = controller.Config([]string{"config", "test"})
+	assert.NoError(t, err)
+	assert.Equal(t, "OPTION dedup_window 5m0s\r\n"+
+		"OPTION mode queue\r\n"+
+		"OPTION retention_age 0s\r\n"+
+		"OPTION retention_bytes 0\r\n"+
+		"OPTION retention_items 0\r\n"+
+		"OPTION protect_cursors false\r\n"+
+		"OPTION auto_create_cursors true\r\n"+
+		"OPTION cursor_ttl 0s\r\n"+
+		"OPTION timestamps false\r\n"+
+		"END\r\n", mockTCPConn.WriteBuffer.String())
+
+	mockTCPConn.WriteBuffer.Reset()
+
+	err = controller.Config([]string{"config", "test", "dedup_window", "10s"})
+	assert.NoError(t, err)
+	assert.Equal(t, "END\r\n", mockTCPConn.WriteBuffer.String())
+
+	q, err := repo.GetQueue("test")
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Second, q.Options().DedupWindow)
+
+	err = controller.Config([]string{"config", "test", "dedup_window", "abc"})
+	assert.EqualError(t, err, "CLIENT_ERROR cgroup: invalid queue option value")
+
+	err = controller.Config([]string{"config", "test", "unknown", "1"})
+	assert.EqualError(t, err, "CLIENT_ERROR cgroup: unknown queue option")
+
+	err = controller.Config([]string{"config", "test", "dedup_window"})
+	assert.EqualError(t, err, "CLIENT_ERROR Invalid command")
+}
@@ -4,11 +4,14 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"log"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/bogdanovich/siberite/queue"
+	"github.com/bogdanovich/siberite/cgroup"
 	"github.com/bogdanovich/siberite/repository"
 )
 
@@ -18,6 +21,9 @@ const (
 	storedMessage = "STORED\r\n"
 	endMessage    = "END\r\n"
 
+	deletedMessage  = "DELETED\r\n"
+	notFoundMessage = "NOT_FOUND\r\n"
+
 	// consumer group separator
 	cgSeparator = "."
 )
@@ -33,6 +39,26 @@ var (
 	// to read next item before closing the current one
 	ErrCloseCurrentItemFirst = &Error{clientError, "Close current item first"}
 
+	// ErrNoOpenItem is returned when client attempted to stage
+	// a transactional set without an open reliable read
+	ErrNoOpenItem = &Error{clientError, "Open an item first"}
+
+	// ErrLeaseExpired is returned on an attempt to close an open item
+	// that was returned back to the queue because its lease lapsed
+	ErrLeaseExpired = &Error{clientError, "Lease expired"}
+
+	// ErrSubscribed is returned when a session in push mode sends
+	// a command other than ack, nak, unsubscribe or quit
+	ErrSubscribed = &Error{clientError, "Unsubscribe first"}
+
+	// ErrUnknownDelivery is returned when an acknowledged
+	// delivery ID doesn't match any pushed item
+	ErrUnknownDelivery = &Error{clientError, "Unknown delivery ID"}
+
+	// ErrSubscriptionClosed is pushed to a session when its
+	// subscribed queue was deleted or closed meanwhile
+	ErrSubscriptionClosed = &Error{commonError, "Subscription closed"}
+
 	// ErrBadDataChunk is returned when data provided by client has different size
 	ErrBadDataChunk = &Error{clientError, "bad data chunk"}
 
@@ -47,7 +73,7 @@ var (
 type Conn interface {
 	io.Reader
 	io.Writer
-	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
 }
 
 // Controller represents a connection controller
@@ -57,7 +83,25 @@ type Controller struct {
 	repo           *repository.QueueRepository
 	dataBuffer     []byte
 	currentValue   []byte
+	currentGroup   string
+	currentID      uint64
 	currentCommand *Command
+	staged         []repository.TransactionItem
+	// reader is the consumer group of the last read of the session,
+	// a session is counted as a reader of one consumer group at a time
+	reader *cgroup.ConsumerGroup
+	// currentDeadline is the lease deadline of the open item,
+	// zero if the item is not leased
+	currentDeadline time.Time
+	// leaseExpired is the key of the open item that was returned back
+	// to the queue because its lease lapsed, until the next read command
+	leaseExpired *Command
+	// rounds counts round-robin multi-queue reads of the session
+	rounds int
+	// sub is the subscription of a session in push mode
+	sub *subscription
+	// wlock serializes writes of pushed items and command responses
+	wlock sync.Mutex
 }
 
 // Command represents a client command
@@ -68,6 +112,19 @@ type Command struct {
 	ConsumerGroup string
 	FanoutQueues  []string
 	DataSize      int
+	DedupKey      string
+	Group         string
+	ReturnID      bool
+	Txn           bool
+	Route         bool
+	ItemID        uint64
+	Browse        bool
+	Offset        uint64
+	Limit         int
+	Lease         uint64
+	// Keys are queues and consumer groups of a multi-queue GET
+	Keys       []*Command
+	RoundRobin bool
 }
 
 // NewSession creates and initializes new controller
@@ -82,11 +139,16 @@ func NewSession(conn Conn, repo *repository.QueueRepository) *Controller {
 	}
 }
 
-// FinishSession aborts unfinished transaction
+// FinishSession aborts unfinished transaction, returns unacknowledged
+// pushed items and unregisters session from the consumer group it was reading
 func (c *Controller) FinishSession() {
+	if c.sub != nil {
+		c.unsubscribe()
+	}
 	if c.currentValue != nil {
 		c.abort()
 	}
+	c.setReader(nil)
 	atomic.AddUint64(&c.repo.Stats.CurrentConnections, ^uint64(0))
 }
 
@@ -103,6 +165,8 @@ func (c *Controller) UnknownCommand() error {
 
 //SendError sends an error message to the client
 func (c *Controller) SendError(err error) {
+	c.wlock.Lock()
+	defer c.wlock.Unlock()
 
 	if e, ok := err.(*Error); ok {
 		fmt.Fprintf(c.rw.Writer, "%s\r\n", e.Error())
@@ -114,21 +178,72 @@ func (c *Controller) SendError(err error) {
 }
 
 // Save current unconfirmed item
-func (c *Controller) setCurrentState(cmd *Command, currentValue []byte) {
+func (c *Controller) setCurrentState(cmd *Command, currentValue []byte, currentGroup string) {
 	c.currentCommand = cmd
 	c.currentValue = currentValue
+	c.currentGroup = currentGroup
+	c.currentID = 0
+	c.currentDeadline = time.Time{}
+	c.staged = nil
 }
 
-func (c *Controller) getConsumer(cmd *Command) (queue.Consumer, error) {
-	if cmd.ConsumerGroup == "" {
-		return c.repo.GetQueue(cmd.QueueName)
+// expireLease returns the open item back to the queue
+// when its lease lapses
+func (c *Controller) expireLease() {
+	if c.currentValue == nil || c.currentDeadline.IsZero() ||
+		time.Now().Before(c.currentDeadline) {
+		return
+	}
+	log.Printf("%s: lease expired, returning the open item", c.currentCommand.QueueName)
+	key := c.currentCommand
+	if err := c.abort(); err != nil {
+		log.Println(c.currentCommand, err)
+		return
 	}
+	c.leaseExpired = key
+}
 
+func (c *Controller) getConsumer(cmd *Command) (cgroup.GroupConsumer, error) {
+	_, consumer, err := c.getQueueConsumer(cmd)
+	return consumer, err
+}
+
+// getQueueConsumer returns the queue of the command along
+// with the queue or its consumer group the command reads
+func (c *Controller) getQueueConsumer(cmd *Command) (*cgroup.CGQueue, cgroup.GroupConsumer, error) {
 	q, err := c.repo.GetQueue(cmd.QueueName)
-	if err == nil {
-		return q.ConsumerGroup(cmd.ConsumerGroup)
+	if err != nil {
+		return nil, nil, err
 	}
-	return q, err
+	if cmd.ConsumerGroup == "" {
+		return q, q, nil
+	}
+	cg, err := q.GetConsumerGroup(cmd.ConsumerGroup)
+	return q, cg, err
+}
+
+// setReader counts the session as a reader of the consumer group until
+// it reads another queue or consumer group, or finishes
+func (c *Controller) setReader(consumer cgroup.GroupConsumer) {
+	cg, _ := consumer.(*cgroup.ConsumerGroup)
+	if cg == c.reader {
+		return
+	}
+	if c.reader != nil {
+		c.reader.Activity().UpdateSessions(-1)
+	}
+	if cg != nil {
+		cg.Activity().UpdateSessions(1)
+	}
+	c.reader = cg
+}
+
+// lookupError converts an error returned by queue or consumer group lookup
+func lookupError(err error) error {
+	if err == cgroup.ErrNotFound || err == repository.ErrQueueNotFound {
+		return NewError(clientError, err)
+	}
+	return NewError(commonError, err)
 }
 
 func parseCommand(input []string) *Command {
@@ -141,3 +256,24 @@ func parseCommand(input []string) *Command {
 	}
 	return cmd
 }
+
+// extractOption removes <name>=<number> option from a list of slash
+// separated options and returns its value, an invalid option is
+// left in the list
+func extractOption(options, name string) (string, uint64, bool) {
+	var (
+		value uint64
+		found bool
+	)
+	rest := []string{}
+	for _, option := range strings.Split(options, "/") {
+		if strings.HasPrefix(option, name+"=") {
+			if v, err := strconv.ParseUint(option[len(name)+1:], 10, 64); err == nil {
+				value, found = v, true
+				continue
+			}
+		}
+		rest = append(rest, option)
+	}
+	return strings.Join(rest, "/"), value, found
+}
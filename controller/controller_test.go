@@ -36,7 +36,7 @@ func (conn *mockTCPConn) Write(b []byte) (int, error) {
 	return conn.WriteBuffer.Write(b)
 }
 
-func (conn *mockTCPConn) SetDeadline(t time.Time) error {
+func (conn *mockTCPConn) SetReadDeadline(t time.Time) error {
 	return nil
 }
 
@@ -126,7 +126,7 @@ func Test_Controller_parseCommand(t *testing.T) {
 	}
 
 	for input, command := range testCases {
-		cmd := parseGetCommand([]string{"get", input})
+		cmd, _ := parseGetCommand([]string{"get", input})
 		assert.Equal(t, "get", cmd.Name, input)
 		assert.Equal(t, "work", cmd.QueueName, input)
 		assert.Equal(t, command.SubCommand, cmd.SubCommand, input)
@@ -0,0 +1,29 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/bogdanovich/siberite/queue"
+)
+
+// Create handles CREATE command
+// Command: CREATE <queue>
+// Response:
+// END
+func (c *Controller) Create(input []string) error {
+	if len(input) != 2 {
+		return ErrInvalidCommand
+	}
+
+	if err := queue.ValidateName(input[1]); err != nil {
+		return NewError(clientError, err)
+	}
+	if _, err := c.repo.CreateQueue(input[1]); err != nil {
+		log.Printf("Command %s: %s ", input[0], err.Error())
+		return NewError(commonError, err)
+	}
+
+	fmt.Fprint(c.rw.Writer, endMessage)
+	return c.rw.Writer.Flush()
+}
@@ -0,0 +1,48 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bogdanovich/siberite/repository"
+)
+
+func Test_Controller_CreateStrict(t *testing.T) {
+	repo, err := repository.NewRepositoryWithOptions(dir, &repository.Options{Strict: true})
+	assert.NoError(t, err)
+	defer cleanupControllerTest(repo)
+
+	mockTCPConn := newMockTCPConn()
+	controller := NewSession(mockTCPConn, repo)
+
+	err = controller.Get([]string{"get", "work"})
+	assert.EqualError(t, err, "CLIENT_ERROR repository: queue does not exist")
+
+	mockTCPConn.ReadBuffer.WriteString("1\r\n")
+	err = controller.Set([]string{"set", "work", "0", "0", "1"})
+	assert.EqualError(t, err, "CLIENT_ERROR repository: queue does not exist")
+
+	err = controller.Create([]string{"create", "work"})
+	assert.NoError(t, err)
+	assert.Equal(t, "END\r\n", mockTCPConn.WriteBuffer.String())
+	mockTCPConn.WriteBuffer.Reset()
+
+	// fanout set is rejected as a whole
+	mockTCPConn.ReadBuffer.WriteString("1\r\n")
+	err = controller.Set([]string{"set", "work+other", "0", "0", "1"})
+	assert.EqualError(t, err, "CLIENT_ERROR repository: queue does not exist")
+
+	q, err := repo.GetQueue("work")
+	assert.NoError(t, err)
+	assert.True(t, q.IsEmpty())
+
+	err = controller.Create([]string{"create", "work"})
+	assert.NoError(t, err)
+
+	err = controller.Create([]string{"create"})
+	assert.Equal(t, ErrInvalidCommand, err)
+
+	err = controller.Create([]string{"create", "work/open"})
+	assert.EqualError(t, err, "CLIENT_ERROR queue: name is not alphanumeric")
+}
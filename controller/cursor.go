@@ -0,0 +1,177 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/bogdanovich/siberite/cgroup"
+)
+
+// Cursor handles CURSOR command
+// Command: CURSOR <queue>
+// lists durable cursors of the queue
+//
+// Command: CURSOR <queue>.<cursor> [<action> [<argument>]]
+// Actions:
+// create [head|tail|id <id>|time <unix timestamp>] - create a new cursor
+// head - move to the queue head
+// tail - move to the queue tail, skipping all items
+// id <id> - next read returns item with provided ID
+// move <count> - move back (negative count) or forward
+// time <unix timestamp> - next read returns first item enqueued after that time
+// clone <name> - create a new cursor at the same position
+// Response:
+// CURSOR <name> <id of the last read item>
+// ...
+// END
+func (c *Controller) Cursor(input []string) error {
+	if len(input) < 2 || len(input) > 5 {
+		return ErrInvalidCommand
+	}
+	cmd := parseCommand(input)
+
+	q, err := c.repo.GetQueue(cmd.QueueName)
+	if err != nil {
+		return lookupError(err)
+	}
+
+	if cmd.ConsumerGroup == "" {
+		if len(input) > 2 {
+			return ErrInvalidCommand
+		}
+		return c.listCursors(q)
+	}
+
+	var cg *cgroup.ConsumerGroup
+	if len(input) > 2 && input[2] == "create" {
+		cg, err = c.createCursor(q, cmd.ConsumerGroup, input[3:])
+	} else {
+		cg, err = q.GetConsumerGroup(cmd.ConsumerGroup)
+		if err != nil {
+			return lookupError(err)
+		}
+		if len(input) > 2 {
+			cg, err = c.moveCursor(q, cg, input[2:])
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.rw.Writer, "CURSOR %s %d\r\n", cg.Name, cg.Cursor())
+	fmt.Fprint(c.rw.Writer, endMessage)
+	return c.rw.Writer.Flush()
+}
+
+func (c *Controller) listCursors(q *cgroup.CGQueue) error {
+	cursors := []*cgroup.ConsumerGroup{}
+	for pair := range q.ConsumerGroupIterator() {
+		cursors = append(cursors, pair.Val.(*cgroup.ConsumerGroup))
+	}
+	sort.Slice(cursors, func(i, j int) bool { return cursors[i].Name < cursors[j].Name })
+
+	for _, cg := range cursors {
+		fmt.Fprintf(c.rw.Writer, "CURSOR %s %d\r\n", cg.Name, cg.Cursor())
+	}
+	fmt.Fprint(c.rw.Writer, endMessage)
+	return c.rw.Writer.Flush()
+}
+
+func (c *Controller) createCursor(q *cgroup.CGQueue, name string,
+	args []string) (*cgroup.ConsumerGroup, error) {
+
+	if len(args) == 0 {
+		args = []string{"head"}
+	}
+	cursor, err := cursorPosition(q, args)
+	if err != nil {
+		return nil, err
+	}
+
+	cg, err := q.CreateConsumerGroup(name, cursor)
+	if err == cgroup.ErrExists || err == cgroup.ErrInvalidName {
+		return nil, NewError(clientError, err)
+	}
+	if err != nil {
+		return nil, NewError(commonError, err)
+	}
+	return cg, nil
+}
+
+func (c *Controller) moveCursor(q *cgroup.CGQueue, cg *cgroup.ConsumerGroup,
+	args []string) (*cgroup.ConsumerGroup, error) {
+
+	var err error
+	switch args[0] {
+	case "head", "tail", "id", "time":
+		var cursor uint64
+		if cursor, err = cursorPosition(q, args); err != nil {
+			return nil, err
+		}
+		err = cg.SetCursor(cursor)
+	case "move":
+		if len(args) != 2 {
+			return nil, ErrInvalidCommand
+		}
+		delta, perr := strconv.ParseInt(args[1], 10, 64)
+		if perr != nil {
+			return nil, ErrInvalidCommand
+		}
+		err = cg.MoveCursor(delta)
+	case "clone":
+		if len(args) != 2 {
+			return nil, ErrInvalidCommand
+		}
+		cg, err = q.CloneConsumerGroup(cg.Name, args[1])
+		if err == cgroup.ErrExists || err == cgroup.ErrInvalidName {
+			return nil, NewError(clientError, err)
+		}
+	default:
+		return nil, ErrInvalidCommand
+	}
+
+	if err != nil {
+		return nil, NewError(commonError, err)
+	}
+	return cg, nil
+}
+
+// cursorPosition returns cursor value for head, tail, id and time actions
+func cursorPosition(q *cgroup.CGQueue, args []string) (uint64, error) {
+	action, arg := args[0], ""
+	if len(args) > 2 {
+		return 0, ErrInvalidCommand
+	}
+	if len(args) == 2 {
+		arg = args[1]
+	}
+	if (action == "head" || action == "tail") != (arg == "") {
+		return 0, ErrInvalidCommand
+	}
+
+	switch action {
+	case "head":
+		return q.Head(), nil
+	case "tail":
+		return q.Tail(), nil
+	case "id":
+		id, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil || id == 0 {
+			return 0, ErrInvalidCommand
+		}
+		return id - 1, nil
+	case "time":
+		ts, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return 0, ErrInvalidCommand
+		}
+		id, err := q.SearchTime(time.Unix(ts, 0))
+		if err != nil {
+			return 0, NewError(commonError, err)
+		}
+		return id - 1, nil
+	}
+	return 0, ErrInvalidCommand
+}
@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bogdanovich/siberite/repository"
+)
+
+// setupTimestampedQueue enqueues items of the test queue with timestamps,
+// so cursors can seek by time
+func setupTimestampedQueue(t *testing.T, repo *repository.QueueRepository, qSize int) {
+	q, err := repo.GetQueue("test")
+	assert.NoError(t, err)
+	assert.NoError(t, q.SetOption("timestamps", "true"))
+	for i := 0; i < qSize; i++ {
+		q.Enqueue([]byte(strconv.Itoa(i)))
+	}
+}
+
+func Test_Controller_Cursor(t *testing.T) {
+	repo, controller, mockTCPConn := setupControllerTest(t, 0)
+	defer cleanupControllerTest(repo)
+	setupTimestampedQueue(t, repo, 10)
+
+	commands := []struct {
+		input    []string
+		response string
+	}{
+		{[]string{"cursor", "test.cg"}, "CURSOR cg 0\r\nEND\r\n"},
+		{[]string{"cursor", "test.cg", "tail"}, "CURSOR cg 10\r\nEND\r\n"},
+		{[]string{"cursor", "test.cg", "move", "-3"}, "CURSOR cg 7\r\nEND\r\n"},
+		{[]string{"cursor", "test.cg", "move", "2"}, "CURSOR cg 9\r\nEND\r\n"},
+		{[]string{"cursor", "test.cg", "id", "5"}, "CURSOR cg 4\r\nEND\r\n"},
+		{[]string{"cursor", "test.cg", "clone", "cg2"}, "CURSOR cg2 4\r\nEND\r\n"},
+		{[]string{"cursor", "test.cg", "head"}, "CURSOR cg 0\r\nEND\r\n"},
+		{[]string{"cursor", "test.cg", "time", fmt.Sprint(time.Now().Add(time.Hour).Unix())}, "CURSOR cg 10\r\nEND\r\n"},
+		{[]string{"cursor", "test.cg", "time", "0"}, "CURSOR cg 0\r\nEND\r\n"},
+	}
+	for _, command := range commands {
+		err = controller.Cursor(command.input)
+		assert.NoError(t, err, command.input)
+		assert.Equal(t, command.response, mockTCPConn.WriteBuffer.String(), command.input)
+		mockTCPConn.WriteBuffer.Reset()
+	}
+
+	q, err := repo.GetQueue("test")
+	assert.NoError(t, err)
+	cg, err := q.ConsumerGroup("cg2")
+	assert.NoError(t, err)
+	value, err := cg.GetNext()
+	assert.NoError(t, err)
+	assert.Equal(t, "4", string(value))
+
+	err = controller.Cursor([]string{"cursor", "test.cg", "clone", "cg2"})
+	assert.EqualError(t, err, "CLIENT_ERROR cgroup: consumer group already exists")
+
+	invalid := [][]string{
+		{"cursor", "test", "head"},
+		{"cursor", "test.cg", "head", "1"},
+		{"cursor", "test.cg", "id"},
+		{"cursor", "test.cg", "id", "0"},
+		{"cursor", "test.cg", "move", "abc"},
+		{"cursor", "test.cg", "rewind", "1"},
+	}
+	for _, input := range invalid {
+		err = controller.Cursor(input)
+		assert.EqualError(t, err, "CLIENT_ERROR Invalid command", input)
+	}
+}
+
+func Test_Controller_CursorCreateAndList(t *testing.T) {
+	repo, controller, mockTCPConn := setupControllerTest(t, 0)
+	defer cleanupControllerTest(repo)
+	setupTimestampedQueue(t, repo, 10)
+
+	q, err := repo.GetQueue("test")
+	assert.NoError(t, err)
+	assert.NoError(t, q.SetOption("auto_create_cursors", "false"))
+
+	// cursors are not created implicitly
+	err = controller.Get([]string{"get", "test.cg"})
+	assert.EqualError(t, err, "CLIENT_ERROR cgroup: consumer group not found")
+	err = controller.Cursor([]string{"cursor", "test.cg", "tail"})
+	assert.EqualError(t, err, "CLIENT_ERROR cgroup: consumer group not found")
+
+	commands := []struct {
+		input    []string
+		response string
+	}{
+		{[]string{"cursor", "test.cg", "create", "tail"}, "CURSOR cg 10\r\nEND\r\n"},
+		{[]string{"cursor", "test.a", "create"}, "CURSOR a 0\r\nEND\r\n"},
+		{[]string{"cursor", "test.b", "create", "id", "4"}, "CURSOR b 3\r\nEND\r\n"},
+		{[]string{"cursor", "test.c", "create", "time", "0"}, "CURSOR c 0\r\nEND\r\n"},
+		{[]string{"cursor", "test"}, "CURSOR a 0\r\nCURSOR b 3\r\nCURSOR c 0\r\nCURSOR cg 10\r\nEND\r\n"},
+	}
+	for _, command := range commands {
+		err = controller.Cursor(command.input)
+		assert.NoError(t, err, command.input)
+		assert.Equal(t, command.response, mockTCPConn.WriteBuffer.String(), command.input)
+		mockTCPConn.WriteBuffer.Reset()
+	}
+
+	err = controller.Cursor([]string{"cursor", "test.cg", "create"})
+	assert.EqualError(t, err, "CLIENT_ERROR cgroup: consumer group already exists")
+	err = controller.Cursor([]string{"cursor", "test.d-1", "create"})
+	assert.EqualError(t, err, "CLIENT_ERROR cgroup: name is not alphanumeric")
+	err = controller.Cursor([]string{"cursor", "test.d", "create", "id"})
+	assert.EqualError(t, err, "CLIENT_ERROR Invalid command")
+
+	err = controller.Get([]string{"get", "test.b"})
+	assert.NoError(t, err)
+	assert.Equal(t, "VALUE test 0 1\r\n3\r\nEND\r\n", mockTCPConn.WriteBuffer.String())
+}
@@ -3,20 +3,29 @@ package controller
 import (
 	"fmt"
 	"log"
+	"strings"
+
+	"github.com/bogdanovich/siberite/queue"
 )
 
 // Delete handles DELETE command
 // Command: DELETE <queue>
 // Response:
 // END
+//
+// Command: DELETE <queue>/id=<id>
+// Response: DELETED or NOT_FOUND
 func (c *Controller) Delete(input []string) error {
 	cmd := parseCommand(input)
+	if strings.Contains(cmd.QueueName, "/") || strings.Contains(cmd.ConsumerGroup, "/") {
+		return c.deleteByID(input)
+	}
 
 	var err error
 	if cmd.ConsumerGroup != "" {
 		q, err := c.repo.GetQueue(cmd.QueueName)
 		if err != nil {
-			return NewError(commonError, err)
+			return lookupError(err)
 		}
 
 		err = q.DeleteConsumerGroup(cmd.ConsumerGroup)
@@ -31,3 +40,28 @@ func (c *Controller) Delete(input []string) error {
 	fmt.Fprint(c.rw.Writer, endMessage)
 	return c.rw.Writer.Flush()
 }
+
+func (c *Controller) deleteByID(input []string) error {
+	tokens := strings.SplitN(input[1], "/", 2)
+	rest, id, _ := extractOption(tokens[1], "id")
+	if id == 0 || rest != "" || strings.Contains(tokens[0], cgSeparator) {
+		return ErrInvalidCommand
+	}
+
+	q, err := c.repo.GetQueue(tokens[0])
+	if err != nil {
+		return lookupError(err)
+	}
+
+	err = q.DeleteItemByID(id)
+	switch err {
+	case nil:
+		fmt.Fprint(c.rw.Writer, deletedMessage)
+	case queue.ErrItemNotFound, queue.ErrIDOutOfBounds, queue.ErrIsEmpty:
+		fmt.Fprint(c.rw.Writer, notFoundMessage)
+	default:
+		log.Printf("Command %s: %s ", input[0], err.Error())
+		return NewError(commonError, err)
+	}
+	return c.rw.Writer.Flush()
+}
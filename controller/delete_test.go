@@ -49,3 +49,35 @@ func Test_Controller_Delete(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "END\r\n", response)
 }
+
+func Test_Controller_DeleteByID(t *testing.T) {
+	repo, controller, mockTCPConn := setupControllerTest(t, 3)
+	defer cleanupControllerTest(repo)
+
+	command := []string{"delete", "test/id=2"}
+	err = controller.Delete(command)
+	assert.NoError(t, err)
+	assert.Equal(t, "DELETED\r\n", mockTCPConn.WriteBuffer.String())
+	mockTCPConn.WriteBuffer.Reset()
+
+	err = controller.Delete(command)
+	assert.NoError(t, err)
+	assert.Equal(t, "NOT_FOUND\r\n", mockTCPConn.WriteBuffer.String())
+	mockTCPConn.WriteBuffer.Reset()
+
+	q, err := repo.GetQueue("test")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, q.Length())
+
+	for _, expected := range []string{"0", "2"} {
+		value, err := q.GetNext()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(value))
+	}
+
+	err = controller.Delete([]string{"delete", "test/id=abc"})
+	assert.EqualError(t, err, "CLIENT_ERROR Invalid command")
+
+	err = controller.Delete([]string{"delete", "test.cg/id=1"})
+	assert.EqualError(t, err, "CLIENT_ERROR Invalid command")
+}
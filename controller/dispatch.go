@@ -5,18 +5,31 @@ import (
 	"time"
 )
 
-// Dispatch routes client commands to their respective handlers
+// Dispatch routes client commands to their respective handlers.
+// Leases are expired here, the read deadline wakes an idle session
+// every 3 seconds, so an item is returned up to 3 seconds after its
+// lease deadline, or once a running command of the session finishes.
 func (c *Controller) Dispatch() error {
-	c.conn.SetDeadline(time.Now().Add(3e9))
+	c.expireLease()
+	c.conn.SetReadDeadline(time.Now().Add(3e9))
 	message, err := c.ReadFirstMessage()
 	if err != nil {
 		return err
 	}
 
-	c.conn.SetDeadline(time.Time{})
+	c.conn.SetReadDeadline(time.Time{})
+	c.expireLease()
 	command := strings.Split(strings.Trim(message, " \r\n"), " ")
 	command[0] = strings.ToLower(command[0])
 
+	// a subscription closed by the pusher is left with the next command
+	if c.sub != nil && c.sub.isClosed() {
+		c.unsubscribe()
+	}
+	if c.sub != nil {
+		return c.dispatchSubscribed(command)
+	}
+
 	switch command[0] {
 	case "get", "gets":
 		err = c.Get(command)
@@ -32,6 +45,34 @@ func (c *Controller) Dispatch() error {
 		err = c.Flush(command)
 	case "flush_all":
 		err = c.FlushAll()
+	case "create":
+		err = c.Create(command)
+	case "config":
+		err = c.Config(command)
+	case "cursor":
+		err = c.Cursor(command)
+	case "backup":
+		err = c.Backup(command)
+	case "export":
+		err = c.Export(command)
+	case "import":
+		err = c.Import(command)
+	case "move":
+		err = c.Move(command)
+	case "fanout":
+		err = c.Fanout(command)
+	case "bind", "unbind":
+		err = c.Bind(command)
+	case "bindings":
+		err = c.Bindings(command)
+	case "readgroup":
+		err = c.ReadGroup(command)
+	case "subscribe":
+		err = c.Subscribe(command)
+	case "touch":
+		err = c.Touch(command)
+	case "quarantine":
+		err = c.Quarantine(command)
 	case "quit":
 		return ErrClientQuit
 	default:
@@ -44,3 +85,25 @@ func (c *Controller) Dispatch() error {
 	}
 	return nil
 }
+
+// dispatchSubscribed routes commands of a session in push mode,
+// the session only acknowledges pushed items until it unsubscribes
+func (c *Controller) dispatchSubscribed(command []string) error {
+	var err error
+	switch command[0] {
+	case "ack", "nak":
+		err = c.Ack(command)
+	case "unsubscribe":
+		err = c.Unsubscribe(command)
+	case "quit":
+		return ErrClientQuit
+	default:
+		err = ErrSubscribed
+	}
+
+	if err != nil {
+		c.SendError(err)
+		return err
+	}
+	return nil
+}
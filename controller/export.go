@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+)
+
+// Export handles EXPORT command
+// Command: EXPORT <queue>[.<cursor>] <file name>
+// writes pending items of the queue or of the consumer group to a file
+// in the server backup directory, file format is defined by extension:
+// .jsonl (JSON lines) or .dump (length-prefixed binary)
+// Response:
+// EXPORTED <number of items> <path>
+// END
+func (c *Controller) Export(input []string) error {
+	if len(input) != 3 {
+		return ErrInvalidCommand
+	}
+	cmd := parseCommand(input)
+
+	path, n, err := c.repo.Export(cmd.QueueName, cmd.ConsumerGroup, input[2])
+	if err != nil {
+		log.Printf("Command %s: %s ", input[0], err.Error())
+		return backupError(err)
+	}
+
+	fmt.Fprintf(c.rw.Writer, "EXPORTED %d %s\r\n", n, path)
+	fmt.Fprint(c.rw.Writer, endMessage)
+	return c.rw.Writer.Flush()
+}
+
+// Import handles IMPORT command
+// Command: IMPORT <queue> <file name>
+// appends items from a file in the server backup directory to the queue
+// Response:
+// IMPORTED <number of items>
+// END
+func (c *Controller) Import(input []string) error {
+	if len(input) != 3 {
+		return ErrInvalidCommand
+	}
+
+	n, err := c.repo.Import(input[1], input[2])
+	if err != nil {
+		log.Printf("Command %s: %s ", input[0], err.Error())
+		return backupError(err)
+	}
+
+	fmt.Fprintf(c.rw.Writer, "IMPORTED %d\r\n", n)
+	fmt.Fprint(c.rw.Writer, endMessage)
+	return c.rw.Writer.Flush()
+}
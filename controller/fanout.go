@@ -0,0 +1,63 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/bogdanovich/siberite/cgroup"
+	"github.com/bogdanovich/siberite/queue"
+)
+
+// Fanout handles FANOUT command
+// Command: FANOUT <queue>
+// lists fanout children of the queue, items stored to the queue
+// are copied to every child queue
+// Response:
+// CHILD <name> <delivered items>
+// ...
+// END
+// Command: FANOUT <queue> add|remove <child queue>
+// adds or removes a fanout child
+// Response:
+// END
+func (c *Controller) Fanout(input []string) error {
+	if len(input) != 2 && len(input) != 4 {
+		return ErrInvalidCommand
+	}
+	q, err := c.repo.GetQueue(input[1])
+	if err != nil {
+		log.Printf("Command %s: %s ", input[0], err.Error())
+		return lookupError(err)
+	}
+
+	if len(input) == 4 {
+		switch input[2] {
+		case "add":
+			err = q.AddFanout(input[3])
+		case "remove":
+			err = q.RemoveFanout(input[3])
+		default:
+			return ErrInvalidCommand
+		}
+		if err != nil {
+			log.Printf("Command %s: %s ", input[0], err.Error())
+			return fanoutError(err)
+		}
+		log.Printf("queue %s: fanout child %s: %s", q.Name, input[3], input[2])
+	} else {
+		delivered := q.Delivered()
+		for _, child := range q.FanoutChildren() {
+			fmt.Fprintf(c.rw.Writer, "CHILD %s %d\r\n", child, delivered[child])
+		}
+	}
+	fmt.Fprint(c.rw.Writer, endMessage)
+	return c.rw.Writer.Flush()
+}
+
+func fanoutError(err error) error {
+	switch err {
+	case cgroup.ErrFanoutToSelf, cgroup.ErrFanoutNotFound, queue.ErrInvalidName:
+		return NewError(clientError, err)
+	}
+	return lookupError(err)
+}
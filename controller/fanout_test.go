@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Controller_Fanout(t *testing.T) {
+	repo, controller, mockTCPConn := setupControllerTest(t, 0)
+	defer cleanupControllerTest(repo)
+
+	err = controller.Fanout([]string{"fanout", "events", "add", "events_billing"})
+	assert.NoError(t, err)
+	assert.Equal(t, "END\r\n", mockTCPConn.WriteBuffer.String())
+	controller.Fanout([]string{"fanout", "events", "add", "events_audit"})
+
+	mockTCPConn.WriteBuffer.Reset()
+	fmt.Fprintf(&mockTCPConn.ReadBuffer, "1\r\n")
+	err = controller.Set([]string{"set", "events", "0", "0", "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "STORED\r\n", mockTCPConn.WriteBuffer.String())
+
+	for _, name := range []string{"events", "events_billing", "events_audit"} {
+		q, _ := repo.GetQueue(name)
+		value, err := q.GetNext()
+		assert.NoError(t, err)
+		assert.Equal(t, "1", string(value))
+	}
+
+	mockTCPConn.WriteBuffer.Reset()
+	err = controller.Fanout([]string{"fanout", "events"})
+	assert.NoError(t, err)
+	assert.Equal(t, "CHILD events_audit 1\r\nCHILD events_billing 1\r\nEND\r\n",
+		mockTCPConn.WriteBuffer.String())
+
+	mockTCPConn.WriteBuffer.Reset()
+	err = controller.Stats()
+	assert.NoError(t, err)
+	assert.Contains(t, mockTCPConn.WriteBuffer.String(),
+		"STAT queue_events_fanout_events_audit_delivered 1\r\n"+
+			"STAT queue_events_fanout_events_billing_delivered 1\r\n")
+
+	mockTCPConn.WriteBuffer.Reset()
+	err = controller.Fanout([]string{"fanout", "events", "remove", "events_audit"})
+	assert.NoError(t, err)
+	fmt.Fprintf(&mockTCPConn.ReadBuffer, "2\r\n")
+	controller.Set([]string{"set", "events", "0", "0", "1"})
+	audit, _ := repo.GetQueue("events_audit")
+	assert.True(t, audit.IsEmpty())
+
+	err = controller.Fanout([]string{"fanout", "events", "remove", "events_audit"})
+	assert.EqualError(t, err, "CLIENT_ERROR cgroup: fanout child not found")
+
+	err = controller.Fanout([]string{"fanout", "events", "add", "events"})
+	assert.EqualError(t, err, "CLIENT_ERROR cgroup: queue can't be a fanout child of itself")
+
+	err = controller.Fanout([]string{"fanout", "events", "add", "events/1"})
+	assert.EqualError(t, err, "CLIENT_ERROR queue: name is not alphanumeric")
+
+	err = controller.Fanout([]string{"fanout", "events", "drop", "events_audit"})
+	assert.Equal(t, ErrInvalidCommand, err)
+}
+
+func Test_Controller_FanoutChildFailure(t *testing.T) {
+	repo, controller, mockTCPConn := setupControllerTest(t, 0)
+	defer cleanupControllerTest(repo)
+
+	controller.Fanout([]string{"fanout", "events", "add", "events_billing"})
+	controller.Fanout([]string{"fanout", "events", "add", "events_audit"})
+	billing, _ := repo.GetQueue("events_billing")
+	billing.Close()
+
+	// a child that fails to store the item doesn't fail the parent
+	mockTCPConn.WriteBuffer.Reset()
+	fmt.Fprintf(&mockTCPConn.ReadBuffer, "1\r\n")
+	err = controller.Set([]string{"set", "events", "0", "0", "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "STORED\r\n", mockTCPConn.WriteBuffer.String())
+
+	for _, name := range []string{"events", "events_audit"} {
+		q, _ := repo.GetQueue(name)
+		value, err := q.GetNext()
+		assert.NoError(t, err)
+		assert.Equal(t, "1", string(value))
+	}
+	events, _ := repo.GetQueue("events")
+	assert.Equal(t, map[string]uint64{"events_audit": 1, "events_billing": 0}, events.Delivered())
+}
+
+func Test_Controller_FanoutDedup(t *testing.T) {
+	repo, controller, mockTCPConn := setupControllerTest(t, 0)
+	defer cleanupControllerTest(repo)
+
+	controller.Fanout([]string{"fanout", "events", "add", "events_audit"})
+	audit, _ := repo.GetQueue("events_audit")
+	assert.NoError(t, audit.SetOption("dedup_window", "0"))
+
+	// a retried item is not copied to children again
+	for i := 0; i < 2; i++ {
+		fmt.Fprintf(&mockTCPConn.ReadBuffer, "1\r\n")
+		err = controller.Set([]string{"set", "events/dedup=e1", "0", "0", "1"})
+		assert.NoError(t, err)
+	}
+	assert.EqualValues(t, 1, audit.Length())
+	events, _ := repo.GetQueue("events")
+	assert.EqualValues(t, 1, events.Length())
+	assert.Equal(t, map[string]uint64{"events_audit": 1}, events.Delivered())
+}
@@ -15,7 +15,7 @@ func (c *Controller) Flush(input []string) error {
 	q, err := c.getConsumer(cmd)
 	if err != nil {
 		log.Printf(err.Error())
-		return NewError(commonError, err)
+		return lookupError(err)
 	}
 
 	if err = q.Flush(); err != nil {
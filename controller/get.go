@@ -6,9 +6,17 @@ import (
 	"regexp"
 	"strings"
 	"sync/atomic"
+	"time"
+
+	"github.com/bogdanovich/siberite/cgroup"
+	"github.com/bogdanovich/siberite/queue"
+	"github.com/bogdanovich/siberite/repository"
 )
 
-var timeoutRegexp = regexp.MustCompile(`(t\=\d+)\/?`)
+var timeoutRegexp = regexp.MustCompile(`\/t\=\d+`)
+
+// keySeparator separates keys of a multi-queue GET
+const keySeparator = "|"
 
 // Get handles GET command
 // Command: GET <queue>
@@ -16,23 +24,90 @@ var timeoutRegexp = regexp.MustCompile(`(t\=\d+)\/?`)
 // VALUE <queue> 0 <bytes>
 // <data block>
 // END
+//
+// Command: GET <queue>/commit closes the current item and stores items
+// staged with SET <queue>/txn at once, GET <queue>/close does the same
+//
+// Command: GET <queue>/open/lease=<seconds> opens an item with a lease, the
+// item is returned back to the queue if it's not closed or touched before
+// the lease deadline. Closing it with the next read command of the session
+// responds "Lease expired", GET <queue>/close/open opens the next item anyway.
+// The deadline is reported by STATS of the session.
+//
+// Command: GET <queue>/id=<id> reads an item by ID without removing it,
+// consumed items kept for durable cursors are not returned. An item
+// moved aside for a busy message group gets a new ID
+//
+// Command: GET <queue>|<queue>.<cursor>[/open][/rr] or GET <key> <key> ...
+// returns an item of the first non-empty queue or consumer group, /rr
+// rotates the first key for every read of the session (round-robin).
+// Response uses the name of the queue the item was read from, the session
+// remembers the queue to close or abort an open item.
+//
+// Command: GET @<read group>[.<cursor>][/open] returns an item of a member
+// queue chosen by weighted round-robin, see READGROUP command
+//
+// Command: GET <queue>/peek/offset=<offset>/n=<count> lists queue items
+// without removing them (pending items for a consumer group)
+// Response:
+// ITEM <id> <bytes> <location>
+// <data block>
+// ...
+// END
 func (c *Controller) Get(input []string) error {
-	var err error
-	cmd := parseGetCommand(input)
+	cmd, err := parseGetCommand(input)
+	if err != nil {
+		return err
+	}
+
+	// only reliable reads are leased
+	if cmd.Lease != 0 && cmd.SubCommand != "open" && cmd.SubCommand != "close/open" &&
+		cmd.SubCommand != "commit/open" {
+		return ErrInvalidCommand
+	}
+	if cmd.ItemID != 0 {
+		if cmd.SubCommand != "" || cmd.ConsumerGroup != "" {
+			return ErrInvalidCommand
+		}
+		cmd.SubCommand = "id"
+	}
+	if cmd.Browse {
+		if cmd.SubCommand != "peek" {
+			return ErrInvalidCommand
+		}
+		cmd.SubCommand = "browse"
+	}
+	if (cmd.Keys != nil || strings.HasPrefix(cmd.QueueName, repository.ReadGroupPrefix)) &&
+		(cmd.SubCommand == "peek" || cmd.SubCommand == "id" || cmd.SubCommand == "browse") {
+		return ErrInvalidCommand
+	}
+
+	// a lapsed lease is reported to the next read command only
+	expired := c.leaseExpired
+	c.leaseExpired = nil
 
 	switch cmd.SubCommand {
 	case "", "open":
 		err = c.get(cmd)
-	case "close":
-		err = c.getClose(cmd)
-	case "close/open":
-		if err = c.getClose(cmd); err == nil {
+	case "close", "commit":
+		err = c.getClose(cmd, expired)
+	case "close/open", "commit/open":
+		err = c.getClose(cmd, expired)
+		// staged items of a lapsed read are dropped, so only a commit fails
+		if err == ErrLeaseExpired && cmd.SubCommand == "close/open" {
+			err = nil
+		}
+		if err == nil {
 			err = c.get(cmd)
 		}
 	case "abort":
 		err = c.abort()
 	case "peek":
 		err = c.peek(cmd)
+	case "id":
+		err = c.getByID(cmd)
+	case "browse":
+		err = c.browse(cmd)
 	default:
 		err = ErrInvalidCommand
 	}
@@ -50,87 +125,379 @@ func (c *Controller) get(cmd *Command) error {
 		return ErrCloseCurrentItemFirst
 	}
 
+	var err error
+	if strings.HasPrefix(cmd.QueueName, repository.ReadGroupPrefix) {
+		err = c.getReadGroup(cmd)
+	} else {
+		for _, key := range c.readOrder(cmd) {
+			var served bool
+			if served, err = c.getFrom(key); err != nil || served {
+				break
+			}
+		}
+	}
+	atomic.AddUint64(&c.repo.Stats.CmdGet, 1)
+	return err
+}
+
+// getReadGroup reads an item of a read group member chosen by
+// weighted round-robin, members are resolved once per read
+// and empty members are skipped
+func (c *Controller) getReadGroup(cmd *Command) error {
+	g, err := c.repo.ReadGroup(strings.TrimPrefix(cmd.QueueName, repository.ReadGroupPrefix))
+	if err != nil {
+		log.Println(cmd, err)
+		return NewError(clientError, err)
+	}
+	members := g.Members(cmd.ConsumerGroup)
+	tried := map[string]bool{}
+	for name := g.Next(members, tried); name != ""; name = g.Next(members, tried) {
+		tried[name] = true
+		key := &Command{Name: cmd.Name, QueueName: name, ConsumerGroup: cmd.ConsumerGroup,
+			SubCommand: cmd.SubCommand, Lease: cmd.Lease}
+		served, err := c.getFrom(key)
+		if err != nil {
+			return err
+		}
+		if served {
+			g.Served(name)
+			break
+		}
+	}
+	return nil
+}
+
+// readOrder returns keys of the command in the order they are read
+func (c *Controller) readOrder(cmd *Command) []*Command {
+	if cmd.Keys == nil {
+		return []*Command{cmd}
+	}
+	if !cmd.RoundRobin {
+		return cmd.Keys
+	}
+	first := c.rounds % len(cmd.Keys)
+	c.rounds++
+	return append(append([]*Command{}, cmd.Keys[first:]...), cmd.Keys[:first]...)
+}
+
+// getFrom reads an item of the queue or consumer group,
+// returns true if an item was served
+func (c *Controller) getFrom(cmd *Command) (bool, error) {
 	q, err := c.getConsumer(cmd)
 	if err != nil {
 		log.Println(cmd, err)
-		return NewError(commonError, err)
+		return false, lookupError(err)
 	}
-	value, _ := q.GetNext()
-	if len(value) > 0 {
-		fmt.Fprintf(c.rw.Writer, "VALUE %s 0 %d\r\n", cmd.QueueName, len(value))
-		fmt.Fprintf(c.rw.Writer, "%s\r\n", value)
+	c.setReader(q)
+
+	var item *queue.Item
+	isOpen := strings.Contains(cmd.SubCommand, "open")
+	if isOpen {
+		item, err = q.OpenNext()
+		// empty item is not tracked, so its group can't stay open
+		if err == nil && len(item.Value) == 0 && item.Group != "" {
+			q.CloseGroup(item.Group)
+		}
+	} else {
+		item, err = q.GetNextItem()
 	}
-	if strings.Contains(cmd.SubCommand, "open") && len(value) > 0 {
-		c.setCurrentState(cmd, value)
-		q.Stats().UpdateOpenReads(1)
+	if err == cgroup.ErrLogMode {
+		return false, NewError(clientError, err)
 	}
-	atomic.AddUint64(&c.repo.Stats.CmdGet, 1)
-	return nil
+
+	if err != nil || len(item.Value) == 0 {
+		return false, nil
+	}
+	if !isOpen {
+		c.writeValue(cmd, item.Value)
+		return true, nil
+	}
+	c.setCurrentState(cmd, item.Value, item.Group)
+	c.currentID = item.ID
+	q.Stats().UpdateOpenReads(1)
+	if cmd.Lease != 0 {
+		c.currentDeadline = time.Now().Add(time.Duration(cmd.Lease) * time.Second)
+	}
+	c.writeValue(cmd, item.Value)
+	return true, nil
 }
 
-func (c *Controller) getClose(cmd *Command) error {
+// getClose closes the open item, expired is the key
+// of an item whose lease lapsed since the last read
+func (c *Controller) getClose(cmd *Command, expired *Command) error {
+	if c.currentValue == nil && expired != nil && c.reads(cmd, expired) {
+		return ErrLeaseExpired
+	}
+	// the open item may come from another key of a multi-queue read
+	if c.currentValue != nil {
+		cmd = c.currentCommand
+	}
 	q, err := c.getConsumer(cmd)
 	if err != nil {
 		log.Println(cmd, err)
-		return NewError(commonError, err)
+		return lookupError(err)
 	}
 	if c.currentValue != nil {
+		if len(c.staged) > 0 {
+			if err = c.commit(); err != nil {
+				return err
+			}
+		} else if c.currentGroup != "" {
+			if err = c.closeGroup(); err != nil {
+				return err
+			}
+		}
 		q.Stats().UpdateOpenReads(-1)
-		c.setCurrentState(nil, nil)
+		c.setCurrentState(nil, nil, "")
 	}
 
 	return nil
 }
 
+// reads returns true if the command reads the queue or consumer
+// group of the key, directly or as a multi-queue or read group key
+func (c *Controller) reads(cmd *Command, key *Command) bool {
+	if strings.HasPrefix(cmd.QueueName, repository.ReadGroupPrefix) {
+		g, err := c.repo.ReadGroup(strings.TrimPrefix(cmd.QueueName, repository.ReadGroupPrefix))
+		if err != nil || cmd.ConsumerGroup != key.ConsumerGroup {
+			return false
+		}
+		for _, m := range g.Members(cmd.ConsumerGroup) {
+			if m.Queue == key.QueueName {
+				return true
+			}
+		}
+		return false
+	}
+	keys := cmd.Keys
+	if keys == nil {
+		keys = []*Command{cmd}
+	}
+	for _, k := range keys {
+		if k.QueueName == key.QueueName && k.ConsumerGroup == key.ConsumerGroup {
+			return true
+		}
+	}
+	return false
+}
+
+// closeGroup confirms current item of a message group
+func (c *Controller) closeGroup() error {
+	q, err := c.getConsumer(c.currentCommand)
+	if err != nil {
+		log.Println(c.currentCommand, err)
+		return lookupError(err)
+	}
+	if err = q.CloseGroup(c.currentGroup); err != nil {
+		return NewError(commonError, err)
+	}
+	return nil
+}
+
+// commit stores items staged during the current read
+// and confirms the read item
+func (c *Controller) commit() error {
+	txn := &repository.Transaction{
+		Queue:  c.currentCommand.QueueName,
+		Cursor: c.currentCommand.ConsumerGroup,
+		Group:  c.currentGroup,
+		ItemID: c.currentID,
+		Items:  c.staged,
+	}
+	if err := c.repo.Commit(txn); err != nil {
+		log.Println(c.currentCommand, err)
+		return lookupError(err)
+	}
+	return nil
+}
+
 func (c *Controller) abort() error {
 	if c.currentValue != nil {
 		q, err := c.getConsumer(c.currentCommand)
 		if err != nil {
 			log.Println(c.currentCommand, err)
-			return NewError(commonError, err)
+			return lookupError(err)
 		}
-		err = q.PutBack(c.currentValue)
-		if err != nil {
+		if err = rollback(q, c.currentValue, c.currentGroup); err != nil {
 			return NewError(commonError, err)
 		}
 		if c.currentValue != nil {
 			q.Stats().UpdateOpenReads(-1)
-			c.setCurrentState(nil, nil)
+			c.setCurrentState(nil, nil, "")
 		}
 	}
 	return nil
 }
 
+// rollback returns an open item back to the queue
+func rollback(q cgroup.GroupConsumer, value []byte, group string) error {
+	if group != "" {
+		return q.AbortGroup(group)
+	}
+	return q.PutBack(value)
+}
+
 func (c *Controller) peek(cmd *Command) error {
 	q, err := c.getConsumer(cmd)
 	if err != nil {
 		log.Println(cmd, err)
-		return NewError(commonError, err)
+		return lookupError(err)
 	}
 	value, _ := q.Peek()
 	if len(value) > 0 {
-		fmt.Fprintf(c.rw.Writer, "VALUE %s 0 %d\r\n", cmd.QueueName, len(value))
-		fmt.Fprintf(c.rw.Writer, "%s\r\n", value)
+		c.writeValue(cmd, value)
 	}
 	atomic.AddUint64(&c.repo.Stats.CmdGet, 1)
 	return nil
 }
 
-func parseGetCommand(input []string) *Command {
-	cmd := &Command{Name: input[0], QueueName: input[1], SubCommand: ""}
-	if strings.Contains(input[1], "t=") {
-		input[1] = timeoutRegexp.ReplaceAllString(input[1], "")
+func (c *Controller) getByID(cmd *Command) error {
+	q, err := c.repo.GetQueue(cmd.QueueName)
+	if err != nil {
+		log.Println(cmd, err)
+		return lookupError(err)
+	}
+	if item, err := q.ReadItemByID(cmd.ItemID); err == nil {
+		c.writeValue(cmd, item.Value)
 	}
+	atomic.AddUint64(&c.repo.Stats.CmdGet, 1)
+	return nil
+}
+
+func (c *Controller) browse(cmd *Command) error {
+	q, err := c.repo.GetQueue(cmd.QueueName)
+	if err != nil {
+		log.Println(cmd, err)
+		return lookupError(err)
+	}
+
+	var items []*cgroup.PageItem
+	if cmd.ConsumerGroup == "" {
+		items, err = q.Browse(cmd.Offset, cmd.Limit)
+	} else {
+		var cg *cgroup.ConsumerGroup
+		if cg, err = q.GetConsumerGroup(cmd.ConsumerGroup); err == nil {
+			items, err = cg.Browse(cmd.Offset, cmd.Limit)
+		}
+	}
+	if err != nil {
+		log.Println(cmd, err)
+		return lookupError(err)
+	}
+
+	for _, item := range items {
+		fmt.Fprintf(c.rw.Writer, "ITEM %d %d %s\r\n", item.ID, len(item.Value), item.Location)
+		fmt.Fprintf(c.rw.Writer, "%s\r\n", item.Value)
+	}
+	return nil
+}
+
+func (c *Controller) writeValue(cmd *Command, value []byte) {
+	fmt.Fprintf(c.rw.Writer, "VALUE %s 0 %d\r\n", cmd.QueueName, len(value))
+	fmt.Fprintf(c.rw.Writer, "%s\r\n", value)
+}
+
+func parseGetCommand(input []string) (*Command, error) {
+	if len(input) < 2 {
+		return nil, ErrInvalidCommand
+	}
+	key := input[1]
+	if len(input) > 2 {
+		// memcache multi-key get, keys have to share options
+		names := []string{}
+		options := ""
+		for i, token := range input[1:] {
+			tokens := strings.SplitN(token, "/", 2)
+			if len(tokens) == 2 {
+				tokens[1] = strings.Trim(timeoutRegexp.ReplaceAllString("/"+tokens[1], ""), "/")
+			} else {
+				tokens = append(tokens, "")
+			}
+			if i > 0 && tokens[1] != options {
+				return nil, ErrInvalidCommand
+			}
+			names = append(names, tokens[0])
+			options = tokens[1]
+		}
+		key = strings.Join(names, keySeparator)
+		if options != "" {
+			key += "/" + options
+		}
+	}
+
+	if strings.Contains(key, "t=") {
+		key = timeoutRegexp.ReplaceAllString(key, "")
+	}
+	cmd := &Command{Name: input[0], QueueName: key, SubCommand: ""}
 	tokens := make([]string, 3)
-	if strings.Contains(input[1], "/") {
-		tokens = strings.SplitN(input[1], "/", 2)
+	if strings.Contains(key, "/") {
+		tokens = strings.SplitN(key, "/", 2)
 		cmd.QueueName = tokens[0]
 		cmd.SubCommand = strings.Trim(tokens[1], "/")
 	}
+	if strings.Contains(cmd.SubCommand, "=") {
+		parseGetOptions(cmd)
+	}
+	if strings.Contains(cmd.SubCommand, "rr") {
+		cmd.SubCommand, cmd.RoundRobin = extractFlag(cmd.SubCommand, "rr")
+	}
+	if strings.Contains(cmd.QueueName, keySeparator) {
+		for _, name := range strings.Split(cmd.QueueName, keySeparator) {
+			cmd.Keys = append(cmd.Keys, parseGetKey(cmd, name))
+		}
+		cmd.QueueName = cmd.Keys[0].QueueName
+		cmd.ConsumerGroup = cmd.Keys[0].ConsumerGroup
+		return cmd, nil
+	}
 	if strings.Contains(cmd.QueueName, cgSeparator) {
 		tokens = strings.SplitN(cmd.QueueName, cgSeparator, 3)
 		cmd.QueueName = tokens[0]
 		cmd.ConsumerGroup = tokens[1]
 	}
-	return cmd
+	return cmd, nil
+}
+
+// parseGetKey returns a command reading one key of a multi-queue GET
+func parseGetKey(cmd *Command, name string) *Command {
+	key := &Command{Name: cmd.Name, QueueName: name, SubCommand: cmd.SubCommand, Lease: cmd.Lease}
+	if strings.Contains(name, cgSeparator) {
+		tokens := strings.SplitN(name, cgSeparator, 3)
+		key.QueueName = tokens[0]
+		key.ConsumerGroup = tokens[1]
+	}
+	return key
+}
+
+// extractFlag removes a flag from a list of slash separated options
+func extractFlag(options, name string) (string, bool) {
+	found := false
+	rest := []string{}
+	for _, option := range strings.Split(options, "/") {
+		if option == name {
+			found = true
+			continue
+		}
+		rest = append(rest, option)
+	}
+	return strings.Join(rest, "/"), found
+}
+
+func parseGetOptions(cmd *Command) {
+	var (
+		limit uint64
+		found bool
+	)
+	cmd.SubCommand, cmd.ItemID, _ = extractOption(cmd.SubCommand, "id")
+	cmd.SubCommand, cmd.Lease, _ = extractOption(cmd.SubCommand, "lease")
+	cmd.SubCommand, cmd.Offset, cmd.Browse = extractOption(cmd.SubCommand, "offset")
+	cmd.SubCommand, limit, found = extractOption(cmd.SubCommand, "n")
+	if found {
+		cmd.Browse = true
+		cmd.Limit = int(limit)
+		if limit > cgroup.MaxPageSize {
+			cmd.Limit = cgroup.MaxPageSize
+		}
+	} else if cmd.Browse {
+		cmd.Limit = 1
+	}
 }
@@ -1,9 +1,12 @@
 package controller
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/bogdanovich/siberite/cgroup"
 )
 
 func Test_Controller_parseGetCommand(t *testing.T) {
@@ -33,7 +36,8 @@ func Test_Controller_parseGetCommand(t *testing.T) {
 	}
 
 	for input, command := range testCases {
-		cmd := parseGetCommand([]string{"get", input})
+		cmd, err := parseGetCommand([]string{"get", input})
+		assert.NoError(t, err, input)
 		assert.Equal(t, "get", cmd.Name, input)
 		assert.Equal(t, "work", cmd.QueueName, input)
 		assert.Equal(t, command.SubCommand, cmd.SubCommand, input)
@@ -411,3 +415,220 @@ func Test_Controller_ConsumerGroup_GetAbort(t *testing.T) {
 
 	}
 }
+
+func Test_Controller_GetOpenMessageGroups(t *testing.T) {
+	repo, controller, mockTCPConn := setupControllerTest(t, 0)
+	defer cleanupControllerTest(repo)
+
+	secondConn := newMockTCPConn()
+	second := NewSession(secondConn, repo)
+	defer second.FinishSession()
+
+	for _, item := range [][2]string{{"a1", "a"}, {"a2", "a"}, {"b1", "b"}} {
+		command := []string{"set", "test/group=" + item[1], "0", "0", "2"}
+		fmt.Fprintf(&mockTCPConn.ReadBuffer, "%s\r\n", item[0])
+		assert.NoError(t, controller.Set(command))
+	}
+	mockTCPConn.WriteBuffer.Reset()
+
+	err = controller.Get([]string{"get", "test/open"})
+	assert.NoError(t, err)
+	assert.Equal(t, "VALUE test 0 2\r\na1\r\nEND\r\n", mockTCPConn.WriteBuffer.String())
+	mockTCPConn.WriteBuffer.Reset()
+
+	// group "a" has an open item, so a2 is skipped
+	err = second.Get([]string{"get", "test/open"})
+	assert.NoError(t, err)
+	assert.Equal(t, "VALUE test 0 2\r\nb1\r\nEND\r\n", secondConn.WriteBuffer.String())
+	secondConn.WriteBuffer.Reset()
+
+	err = controller.Get([]string{"get", "test/close/open"})
+	assert.NoError(t, err)
+	assert.Equal(t, "VALUE test 0 2\r\na2\r\nEND\r\n", mockTCPConn.WriteBuffer.String())
+	mockTCPConn.WriteBuffer.Reset()
+
+	err = second.Get([]string{"get", "test/abort"})
+	assert.NoError(t, err)
+	secondConn.WriteBuffer.Reset()
+
+	err = second.Get([]string{"get", "test/open"})
+	assert.NoError(t, err)
+	assert.Equal(t, "VALUE test 0 2\r\nb1\r\nEND\r\n", secondConn.WriteBuffer.String())
+
+	assert.NoError(t, controller.Get([]string{"get", "test/close"}))
+	assert.NoError(t, second.Get([]string{"get", "test/close"}))
+
+	q, err := repo.GetQueue("test")
+	assert.NoError(t, err)
+	assert.True(t, q.IsEmpty())
+	assert.EqualValues(t, 0, q.Stats().OpenReads)
+}
+
+func Test_Controller_GetByID(t *testing.T) {
+	repo, controller, mockTCPConn := setupControllerTest(t, 3)
+	defer cleanupControllerTest(repo)
+
+	command := []string{"get", "test/id=2"}
+	err = controller.Get(command)
+	assert.NoError(t, err)
+	assert.Equal(t, "VALUE test 0 1\r\n1\r\nEND\r\n", mockTCPConn.WriteBuffer.String())
+	mockTCPConn.WriteBuffer.Reset()
+
+	// IDs belong to the source queue, not to consumer groups
+	command = []string{"get", "test.cg/id=2"}
+	err = controller.Get(command)
+	assert.EqualError(t, err, "CLIENT_ERROR Invalid command")
+
+	// item is not removed
+	q, err := repo.GetQueue("test")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, q.Length())
+
+	command = []string{"get", "test/id=10"}
+	err = controller.Get(command)
+	assert.NoError(t, err)
+	assert.Equal(t, "END\r\n", mockTCPConn.WriteBuffer.String())
+
+	command = []string{"get", "test/open/id=1"}
+	err = controller.Get(command)
+	assert.EqualError(t, err, "CLIENT_ERROR Invalid command")
+}
+
+func Test_Controller_GetBrowse(t *testing.T) {
+	repo, controller, mockTCPConn := setupControllerTest(t, 5)
+	defer cleanupControllerTest(repo)
+
+	command := []string{"get", "test/peek/offset=1/n=2"}
+	err = controller.Get(command)
+	assert.NoError(t, err)
+	assert.Equal(t, "ITEM 2 1 source\r\n1\r\nITEM 3 1 source\r\n2\r\nEND\r\n",
+		mockTCPConn.WriteBuffer.String())
+	mockTCPConn.WriteBuffer.Reset()
+
+	// consumer group pending items
+	err = controller.Get([]string{"get", "test.cg"})
+	assert.NoError(t, err)
+	mockTCPConn.WriteBuffer.Reset()
+
+	command = []string{"get", "test.cg/peek/n=1"}
+	err = controller.Get(command)
+	assert.NoError(t, err)
+	assert.Equal(t, "ITEM 2 1 source\r\n1\r\nEND\r\n", mockTCPConn.WriteBuffer.String())
+	mockTCPConn.WriteBuffer.Reset()
+
+	q, err := repo.GetQueue("test")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 5, q.Length())
+
+	command = []string{"get", "test/open/n=2"}
+	err = controller.Get(command)
+	assert.EqualError(t, err, "CLIENT_ERROR Invalid command")
+}
+
+func Test_Controller_GetLogMode(t *testing.T) {
+	repo, controller, mockTCPConn := setupControllerTest(t, 2)
+	defer cleanupControllerTest(repo)
+
+	q, err := repo.GetQueue("test")
+	assert.NoError(t, err)
+	assert.NoError(t, q.SetOption("mode", "log"))
+
+	for _, command := range []string{"test", "test/open"} {
+		err = controller.Get([]string{"get", command})
+		assert.EqualError(t, err, "CLIENT_ERROR "+cgroup.ErrLogMode.Error())
+	}
+
+	err = controller.Get([]string{"get", "test.cg"})
+	assert.NoError(t, err)
+	assert.Equal(t, "VALUE test 0 1\r\n0\r\nEND\r\n", mockTCPConn.WriteBuffer.String())
+	assert.EqualValues(t, 2, q.Length())
+}
+
+func Test_Controller_parseGetOptions(t *testing.T) {
+	cmd, _ := parseGetCommand([]string{"get", "work/peek/offset=100/n=20"})
+	assert.Equal(t, "peek", cmd.SubCommand)
+	assert.True(t, cmd.Browse)
+	assert.EqualValues(t, 100, cmd.Offset)
+	assert.Equal(t, 20, cmd.Limit)
+
+	cmd, _ = parseGetCommand([]string{"get", "work.cg/peek/offset=5"})
+	assert.Equal(t, "cg", cmd.ConsumerGroup)
+	assert.True(t, cmd.Browse)
+	assert.Equal(t, 1, cmd.Limit)
+
+	cmd, _ = parseGetCommand([]string{"get", "work/peek/n=100000"})
+	assert.Equal(t, 1000, cmd.Limit)
+
+	cmd, _ = parseGetCommand([]string{"get", "work/id=7"})
+	assert.EqualValues(t, 7, cmd.ItemID)
+	assert.Equal(t, "", cmd.SubCommand)
+	assert.False(t, cmd.Browse)
+
+	cmd, _ = parseGetCommand([]string{"get", "work/peek/n=abc"})
+	assert.Equal(t, "peek/n=abc", cmd.SubCommand)
+	assert.False(t, cmd.Browse)
+}
+
+func Test_Controller_parseGetCommand_MultiQueue(t *testing.T) {
+	cmd, err := parseGetCommand([]string{"get", "high|low.cg/open/rr/t=10"})
+	assert.NoError(t, err)
+	assert.Equal(t, "high", cmd.QueueName)
+	assert.Equal(t, "open", cmd.SubCommand)
+	assert.True(t, cmd.RoundRobin)
+	assert.Equal(t, 2, len(cmd.Keys))
+	assert.Equal(t, "low", cmd.Keys[1].QueueName)
+	assert.Equal(t, "cg", cmd.Keys[1].ConsumerGroup)
+	assert.Equal(t, "open", cmd.Keys[1].SubCommand)
+
+	cmd, err = parseGetCommand([]string{"get", "high/open/t=10", "low/open"})
+	assert.NoError(t, err)
+	assert.Equal(t, "open", cmd.SubCommand)
+	assert.Equal(t, "high", cmd.Keys[0].QueueName)
+	assert.Equal(t, "low", cmd.Keys[1].QueueName)
+
+	_, err = parseGetCommand([]string{"get", "high/open", "low"})
+	assert.Equal(t, ErrInvalidCommand, err)
+}
+
+func Test_Controller_GetMultiQueue(t *testing.T) {
+	repo, controller, mockTCPConn := setupControllerTest(t, 0)
+	defer cleanupControllerTest(repo)
+
+	high, _ := repo.GetQueue("high")
+	low, _ := repo.GetQueue("low")
+	low.Enqueue([]byte("1"))
+	low.Enqueue([]byte("2"))
+
+	err = controller.Get([]string{"get", "high|low/open"})
+	assert.NoError(t, err)
+	assert.Equal(t, "VALUE low 0 1\r\n1\r\nEND\r\n", mockTCPConn.WriteBuffer.String())
+	assert.EqualValues(t, 1, low.Stats().OpenReads)
+
+	mockTCPConn.WriteBuffer.Reset()
+	high.Enqueue([]byte("3"))
+	err = controller.Get([]string{"get", "high|low/close/open"})
+	assert.NoError(t, err)
+	assert.Equal(t, "VALUE high 0 1\r\n3\r\nEND\r\n", mockTCPConn.WriteBuffer.String())
+	assert.EqualValues(t, 0, low.Stats().OpenReads)
+	assert.EqualValues(t, 1, high.Stats().OpenReads)
+
+	mockTCPConn.WriteBuffer.Reset()
+	err = controller.Get([]string{"get", "high", "low/abort"})
+	assert.Equal(t, ErrInvalidCommand, err)
+	err = controller.Get([]string{"get", "high/abort", "low/abort"})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, high.Stats().OpenReads)
+
+	mockTCPConn.WriteBuffer.Reset()
+	high.Enqueue([]byte("4"))
+	for _, expected := range []string{"VALUE high 0 1\r\n3\r\n", "VALUE low 0 1\r\n2\r\n",
+		"VALUE high 0 1\r\n4\r\n", "", ""} {
+		err = controller.Get([]string{"get", "high|low/rr"})
+		assert.NoError(t, err)
+		assert.Equal(t, expected+"END\r\n", mockTCPConn.WriteBuffer.String())
+		mockTCPConn.WriteBuffer.Reset()
+	}
+
+	err = controller.Get([]string{"get", "high|low/peek"})
+	assert.Equal(t, ErrInvalidCommand, err)
+}
@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/bogdanovich/siberite/cgroup"
+	"github.com/bogdanovich/siberite/queue"
+)
+
+// Move handles MOVE command
+// Command: MOVE <source queue>[.<cursor>] <destination queue> [<n>]
+// transfers up to n items (all items if n is not provided) from the head
+// of the source queue, or from failed reads of its consumer group,
+// to the tail of the destination queue. Items are deleted from
+// the source only after they are durably written to the destination.
+// Response:
+// MOVED <number of items>
+// END
+func (c *Controller) Move(input []string) error {
+	if len(input) < 3 || len(input) > 4 {
+		return ErrInvalidCommand
+	}
+	n := 0
+	if len(input) == 4 {
+		var err error
+		if n, err = strconv.Atoi(input[3]); err != nil || n < 1 {
+			return ErrInvalidCommand
+		}
+	}
+	cmd := parseCommand(input)
+
+	src, err := c.repo.GetQueue(cmd.QueueName)
+	if err != nil {
+		log.Printf("Command %s: %s ", input[0], err.Error())
+		return lookupError(err)
+	}
+	dst, err := c.repo.GetQueue(input[2])
+	if err != nil {
+		log.Printf("Command %s: %s ", input[0], err.Error())
+		return lookupError(err)
+	}
+
+	var moved int
+	if cmd.ConsumerGroup == "" {
+		moved, err = src.Move(dst, n)
+	} else {
+		var cg *cgroup.ConsumerGroup
+		if cg, err = src.FindConsumerGroup(cmd.ConsumerGroup); err == nil {
+			moved, err = cg.MoveFailedReads(dst, n)
+		}
+	}
+	if err != nil {
+		log.Printf("Command %s: %s ", input[0], err.Error())
+		return moveError(err)
+	}
+
+	fmt.Fprintf(c.rw.Writer, "MOVED %d\r\n", moved)
+	fmt.Fprint(c.rw.Writer, endMessage)
+	return c.rw.Writer.Flush()
+}
+
+func moveError(err error) error {
+	switch err {
+	case cgroup.ErrLogMode, cgroup.ErrProtectedMove, queue.ErrSameQueue:
+		return NewError(clientError, err)
+	}
+	return lookupError(err)
+}
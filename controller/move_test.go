@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bogdanovich/siberite/repository"
+)
+
+func Test_Controller_Move(t *testing.T) {
+	repo, err := repository.NewRepository(dir)
+	assert.NoError(t, err)
+	defer cleanupControllerTest(repo)
+
+	mockTCPConn := newMockTCPConn()
+	controller := NewSession(mockTCPConn, repo)
+	q, _ := repo.GetQueue("errors")
+	for _, value := range []string{"1", "2", "3"} {
+		q.Enqueue([]byte(value))
+	}
+
+	err = controller.Move([]string{"move", "errors", "test", "2"})
+	assert.NoError(t, err)
+	assert.Equal(t, "MOVED 2\r\nEND\r\n", mockTCPConn.WriteBuffer.String())
+
+	mockTCPConn.WriteBuffer.Reset()
+	err = controller.Move([]string{"move", "errors", "test"})
+	assert.NoError(t, err)
+	assert.Equal(t, "MOVED 1\r\nEND\r\n", mockTCPConn.WriteBuffer.String())
+	assert.True(t, q.IsEmpty())
+
+	dst, _ := repo.GetQueue("test")
+	cg, _ := dst.ConsumerGroup("cg1")
+	value, _ := cg.GetNext()
+	cg.PutBack(value)
+
+	mockTCPConn.WriteBuffer.Reset()
+	err = controller.Move([]string{"move", "test.cg1", "errors"})
+	assert.NoError(t, err)
+	assert.Equal(t, "MOVED 1\r\nEND\r\n", mockTCPConn.WriteBuffer.String())
+	value, _ = q.GetNext()
+	assert.Equal(t, "1", string(value))
+
+	err = controller.Move([]string{"move", "test.unknown", "errors"})
+	assert.EqualError(t, err, "CLIENT_ERROR cgroup: consumer group not found")
+
+	err = controller.Move([]string{"move", "test", "test"})
+	assert.EqualError(t, err, "CLIENT_ERROR queue: source and destination are the same queue")
+
+	err = controller.Move([]string{"move", "test", "errors", "0"})
+	assert.Equal(t, ErrInvalidCommand, err)
+
+	err = controller.Move([]string{"move", "test"})
+	assert.Equal(t, ErrInvalidCommand, err)
+}
@@ -0,0 +1,26 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Quarantine handles QUARANTINE command
+// Command: QUARANTINE
+// lists queues that failed to open and were moved to quarantine
+// Response:
+// QUEUE <name> <path> <reason>
+// ...
+// END
+func (c *Controller) Quarantine(input []string) error {
+	if len(input) != 1 {
+		return ErrInvalidCommand
+	}
+
+	for _, q := range c.repo.Quarantined() {
+		reason := strings.Join(strings.Fields(q.Reason), " ")
+		fmt.Fprintf(c.rw.Writer, "QUEUE %s %s %s\r\n", q.Name, q.Path, reason)
+	}
+	fmt.Fprint(c.rw.Writer, endMessage)
+	return c.rw.Writer.Flush()
+}
@@ -0,0 +1,35 @@
+package controller
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bogdanovich/siberite/repository"
+)
+
+func Test_Controller_Quarantine(t *testing.T) {
+	// a corrupted queue that can't be recovered
+	assert.NoError(t, os.MkdirAll(dir+"/broken/broken", 0777))
+	assert.NoError(t, ioutil.WriteFile(dir+"/broken/broken/CURRENT", []byte("garbage"), 0644))
+	assert.NoError(t, ioutil.WriteFile(dir+"/broken/_.metadata", []byte("data"), 0644))
+	defer os.RemoveAll(dir + "/.quarantine")
+
+	repo, err := repository.NewRepository(dir)
+	assert.NoError(t, err)
+	defer cleanupControllerTest(repo)
+
+	mockTCPConn := newMockTCPConn()
+	controller := NewSession(mockTCPConn, repo)
+
+	err = controller.Quarantine([]string{"quarantine"})
+	assert.NoError(t, err)
+	quarantined := repo.Quarantined()[0]
+	assert.Equal(t, "QUEUE broken "+quarantined.Path+" "+quarantined.Reason+"\r\nEND\r\n",
+		mockTCPConn.WriteBuffer.String())
+
+	err = controller.Quarantine([]string{"quarantine", "broken"})
+	assert.Equal(t, ErrInvalidCommand, err)
+}
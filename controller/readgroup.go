@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/bogdanovich/siberite/queue"
+	"github.com/bogdanovich/siberite/repository"
+)
+
+// ReadGroup handles READGROUP command
+// Command: READGROUP
+// lists read groups
+// Response:
+// READGROUP <name>
+// ...
+// END
+// Command: READGROUP <name>
+// lists members of the read group
+// Response:
+// MEMBER <queue or prefix*> <weight>
+// ...
+// END
+// Command: READGROUP <name> add <queue or prefix*> [<weight>]
+// Command: READGROUP <name> remove <queue or prefix*>
+// adds or removes a member, weight is 1 by default
+// Response:
+// END
+func (c *Controller) ReadGroup(input []string) error {
+	if len(input) == 1 {
+		for _, config := range c.repo.ReadGroups() {
+			fmt.Fprintf(c.rw.Writer, "READGROUP %s\r\n", config.Name)
+		}
+		fmt.Fprint(c.rw.Writer, endMessage)
+		return c.rw.Writer.Flush()
+	}
+
+	name := strings.TrimPrefix(input[1], repository.ReadGroupPrefix)
+	var err error
+	switch {
+	case len(input) == 2:
+		var g *repository.ReadGroup
+		if g, err = c.repo.ReadGroup(name); err == nil {
+			for _, m := range g.Config().Members {
+				fmt.Fprintf(c.rw.Writer, "MEMBER %s %d\r\n", m.Queue, m.Weight)
+			}
+		}
+	case input[2] == "add" && (len(input) == 4 || len(input) == 5):
+		weight := 1
+		if len(input) == 5 {
+			if weight, err = strconv.Atoi(input[4]); err != nil {
+				return ErrInvalidCommand
+			}
+		}
+		err = c.repo.AddReadGroupMember(name, input[3], weight)
+	case input[2] == "remove" && len(input) == 4:
+		err = c.repo.RemoveReadGroupMember(name, input[3])
+	default:
+		return ErrInvalidCommand
+	}
+	if err != nil {
+		log.Printf("Command %s: %s ", input[0], err.Error())
+		return readGroupError(err)
+	}
+	fmt.Fprint(c.rw.Writer, endMessage)
+	return c.rw.Writer.Flush()
+}
+
+func readGroupError(err error) error {
+	switch err {
+	case repository.ErrReadGroupNotFound, repository.ErrReadGroupMemberNotFound,
+		repository.ErrInvalidWeight, queue.ErrInvalidName:
+		return NewError(clientError, err)
+	}
+	return NewError(commonError, err)
+}
@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Controller_ReadGroup(t *testing.T) {
+	repo, controller, mockTCPConn := setupControllerTest(t, 0)
+	defer cleanupControllerTest(repo)
+
+	a, _ := repo.GetQueue("tenant_a")
+	b, _ := repo.GetQueue("tenant_b")
+	for _, value := range []string{"a1", "a2", "a3"} {
+		a.Enqueue([]byte(value))
+	}
+	b.Enqueue([]byte("b1"))
+
+	err = controller.ReadGroup([]string{"readgroup", "tenants", "add", "tenant_*"})
+	assert.NoError(t, err)
+	assert.Equal(t, "END\r\n", mockTCPConn.WriteBuffer.String())
+	controller.ReadGroup([]string{"readgroup", "@tenants", "add", "tenant_a", "2"})
+
+	mockTCPConn.WriteBuffer.Reset()
+	err = controller.ReadGroup([]string{"readgroup", "tenants"})
+	assert.NoError(t, err)
+	assert.Equal(t, "MEMBER tenant_* 1\r\nMEMBER tenant_a 2\r\nEND\r\n", mockTCPConn.WriteBuffer.String())
+
+	mockTCPConn.WriteBuffer.Reset()
+	err = controller.ReadGroup([]string{"readgroup"})
+	assert.NoError(t, err)
+	assert.Equal(t, "READGROUP tenants\r\nEND\r\n", mockTCPConn.WriteBuffer.String())
+
+	mockTCPConn.WriteBuffer.Reset()
+	err = controller.Get([]string{"get", "@tenants/open"})
+	assert.NoError(t, err)
+	assert.Equal(t, "VALUE tenant_a 0 2\r\na1\r\nEND\r\n", mockTCPConn.WriteBuffer.String())
+	assert.EqualValues(t, 1, a.Stats().OpenReads)
+
+	for _, expected := range []string{"b1", "a2", "a3"} {
+		mockTCPConn.WriteBuffer.Reset()
+		err = controller.Get([]string{"get", "@tenants/close/open"})
+		assert.NoError(t, err)
+		assert.Contains(t, mockTCPConn.WriteBuffer.String(), "\r\n"+expected+"\r\n")
+	}
+
+	mockTCPConn.WriteBuffer.Reset()
+	err = controller.Get([]string{"get", "@tenants/close/open"})
+	assert.NoError(t, err)
+	assert.Equal(t, "END\r\n", mockTCPConn.WriteBuffer.String())
+	assert.EqualValues(t, 0, a.Stats().OpenReads)
+
+	err = controller.Get([]string{"get", "@unknown"})
+	assert.EqualError(t, err, "CLIENT_ERROR repository: read group not found")
+
+	err = controller.Get([]string{"get", "@tenants/peek"})
+	assert.Equal(t, ErrInvalidCommand, err)
+
+	err = controller.ReadGroup([]string{"readgroup", "tenants", "add", "tenant_b", "1001"})
+	assert.EqualError(t, err, "CLIENT_ERROR repository: weight must be between 1 and 1000")
+
+	err = controller.ReadGroup([]string{"readgroup", "tenants", "remove", "tenant_b"})
+	assert.EqualError(t, err, "CLIENT_ERROR repository: read group member not found")
+
+	err = controller.ReadGroup([]string{"readgroup", "tenants", "add", "tenant_b", "x"})
+	assert.Equal(t, ErrInvalidCommand, err)
+
+	assert.NoError(t, repo.RemoveReadGroupMember("tenants", "tenant_*"))
+	assert.NoError(t, repo.RemoveReadGroupMember("tenants", "tenant_a"))
+}
@@ -7,12 +7,26 @@ import (
 	"strconv"
 	"strings"
 	"sync/atomic"
+
+	"github.com/bogdanovich/siberite/cgroup"
+	"github.com/bogdanovich/siberite/queue"
+	"github.com/bogdanovich/siberite/repository"
 )
 
 // Set handles SET command
-// Command: SET <queue> <not_impl> <not_impl> <bytes>
+// Command: SET <queue>[/dedup=<key>][/group=<group>][/return_id][/txn][/route] <not_impl> <not_impl> <bytes>
 // <data block>
 // Response: STORED
+// Response with /return_id option: STORED <id> [<fanout_queue_id> ...]
+// Items are also copied to fanout children of the queues, see FANOUT command
+//
+// Command: SET <routing key>/route ... stores the item to every queue
+// bound to a pattern matching the routing key, see BIND command.
+// An item that matches no binding is dropped and counted in stats.
+//
+// Command: SET <queue>/txn ... stages the item until the current reliable
+// read is closed, staged items are stored together with confirming the
+// read item, abort discards them
 func (c *Controller) Set(input []string) error {
 	cmd, err := parseSetCommand(input)
 	if err != nil {
@@ -24,22 +38,121 @@ func (c *Controller) Set(input []string) error {
 		return err
 	}
 
-	if cmd.FanoutQueues == nil {
-		err = c.storeDataBlock(cmd.QueueName, dataBlock)
+	queueNames := cmd.FanoutQueues
+	if cmd.Route {
+		if queueNames, err = c.repo.Route(cmd.QueueName); err != nil {
+			return NewError(clientError, err)
+		}
+	} else if queueNames == nil {
+		queueNames = []string{cmd.QueueName}
+	}
+
+	// look up all the queues first, so the item is not
+	// stored partially if one of them doesn't exist
+	queues := make([]*cgroup.CGQueue, len(queueNames))
+	for i, queueName := range queueNames {
+		if queues[i], err = c.repo.GetQueue(queueName); err != nil {
+			log.Println(cmd, err)
+			return lookupError(err)
+		}
+	}
+
+	children := c.fanoutChildren(queues)
+	if cmd.Txn {
+		return c.stage(cmd, queues, children, dataBlock)
+	}
+
+	ids := make([]string, len(queues))
+	duplicates := make(map[string]bool)
+	for i, q := range queues {
+		id, stored, err := c.storeDataBlock(cmd, q, dataBlock)
 		if err != nil {
 			log.Println(cmd, err)
 			return err
 		}
+		ids[i] = strconv.FormatUint(id, 10)
+		duplicates[q.Name] = !stored
+	}
+	// the item is already stored, so a child that fails
+	// to store its copy is skipped like an unopenable one.
+	// Children of a queue that reported a duplicate already got a copy.
+	for _, child := range children {
+		if duplicates[child.parent.Name] {
+			continue
+		}
+		if _, _, err = c.storeDataBlock(cmd, child.queue, dataBlock); err != nil {
+			log.Printf("queue %s: fanout child %s: %s",
+				child.parent.Name, child.queue.Name, err.Error())
+			continue
+		}
+		child.parent.CountDelivered(child.queue.Name, 1)
+	}
+
+	if cmd.ReturnID && len(ids) > 0 {
+		fmt.Fprintf(c.rw.Writer, "STORED %s\r\n", strings.Join(ids, " "))
 	} else {
-		for _, queueName := range cmd.FanoutQueues {
-			err = c.storeDataBlock(queueName, dataBlock)
+		fmt.Fprint(c.rw.Writer, storedMessage)
+	}
+	c.rw.Writer.Flush()
+	atomic.AddUint64(&c.repo.Stats.CmdSet, 1)
+	return nil
+}
+
+// fanoutTarget is a fanout child queue that receives a copy of an item
+type fanoutTarget struct {
+	parent *cgroup.CGQueue
+	queue  *cgroup.CGQueue
+}
+
+// fanoutChildren returns fanout children declared for the queues,
+// excluding the queues themselves. A child that can't be opened is
+// skipped, so it doesn't fail producers of the parent queue.
+func (c *Controller) fanoutChildren(queues []*cgroup.CGQueue) []fanoutTarget {
+	seen := make(map[string]bool)
+	for _, q := range queues {
+		seen[q.Name] = true
+	}
+	children := []fanoutTarget{}
+	for _, q := range queues {
+		for _, name := range q.FanoutChildren() {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			child, err := c.repo.GetQueue(name)
 			if err != nil {
-				log.Println(cmd, err)
-				return err
+				log.Printf("queue %s: fanout child %s: %s", q.Name, name, err.Error())
+				continue
 			}
+			children = append(children, fanoutTarget{q, child})
 		}
 	}
+	return children
+}
+
+// stage keeps an item of a transaction until the current read is closed
+func (c *Controller) stage(cmd *Command, queues []*cgroup.CGQueue,
+	children []fanoutTarget, dataBlock []byte) error {
 
+	if c.currentValue == nil {
+		return ErrNoOpenItem
+	}
+	value := append([]byte{}, dataBlock...)
+	for _, q := range queues {
+		c.staged = append(c.staged, repository.TransactionItem{
+			Queue: q.Name,
+			Group: cmd.Group,
+			Value: value,
+		})
+	}
+	for _, child := range children {
+		c.staged = append(c.staged, repository.TransactionItem{
+			Queue:  child.queue.Name,
+			Parent: child.parent.Name,
+			Group:  cmd.Group,
+			Value:  value,
+		})
+	}
 	fmt.Fprint(c.rw.Writer, storedMessage)
 	c.rw.Writer.Flush()
 	atomic.AddUint64(&c.repo.Stats.CmdSet, 1)
@@ -67,12 +180,13 @@ func (c *Controller) readDataBlock(totalBytes int) ([]byte, error) {
 	return c.dataBuffer[:totalBytes], nil
 }
 
-func (c *Controller) storeDataBlock(queueName string, dataBlock []byte) error {
-	q, err := c.repo.GetQueue(queueName)
-	if err != nil {
-		return err
+func (c *Controller) storeDataBlock(cmd *Command, q *cgroup.CGQueue, dataBlock []byte) (uint64, bool, error) {
+	item := &queue.Item{Value: dataBlock, Group: cmd.Group}
+	if cmd.DedupKey != "" {
+		stored, err := q.EnqueueOnce(cmd.DedupKey, item)
+		return item.ID, stored, err
 	}
-	return q.Enqueue([]byte(dataBlock))
+	return item.ID, true, q.EnqueueItem(item)
 }
 
 func parseSetCommand(input []string) (*Command, error) {
@@ -87,9 +201,59 @@ func parseSetCommand(input []string) (*Command, error) {
 
 	cmd := &Command{Name: input[0], QueueName: input[1], DataSize: totalBytes}
 
+	if strings.Contains(cmd.QueueName, "/") {
+		tokens := strings.Split(cmd.QueueName, "/")
+		cmd.QueueName = tokens[0]
+		if err = parseSetOptions(cmd, tokens[1:]); err != nil {
+			return nil, err
+		}
+	}
+
+	// IDs and duplicates are not known until a transaction is committed
+	if cmd.Txn && (cmd.ReturnID || cmd.DedupKey != "") {
+		return nil, ErrInvalidCommand
+	}
+
 	if strings.Contains(cmd.QueueName, "+") {
+		// a routing key is a single name
+		if cmd.Route {
+			return nil, ErrInvalidCommand
+		}
 		cmd.FanoutQueues = strings.Split(cmd.QueueName, "+")
 		cmd.QueueName = cmd.FanoutQueues[0]
 	}
 	return cmd, nil
 }
+
+func parseSetOptions(cmd *Command, options []string) error {
+	for _, option := range options {
+		if option == "return_id" {
+			cmd.ReturnID = true
+			continue
+		}
+		if option == "txn" {
+			cmd.Txn = true
+			continue
+		}
+		if option == "route" {
+			cmd.Route = true
+			continue
+		}
+		tokens := strings.SplitN(option, "=", 2)
+		if len(tokens) != 2 || tokens[1] == "" {
+			return ErrInvalidCommand
+		}
+		switch tokens[0] {
+		case "dedup":
+			cmd.DedupKey = tokens[1]
+		case "group":
+			if cgroup.ValidateGroupName(tokens[1]) != nil {
+				return ErrInvalidCommand
+			}
+			cmd.Group = tokens[1]
+		default:
+			return ErrInvalidCommand
+		}
+	}
+	return nil
+}
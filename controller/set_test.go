@@ -69,3 +69,132 @@ func Test_Controller_SetFanout(t *testing.T) {
 		assert.True(t, q.IsEmpty())
 	}
 }
+
+func Test_Controller_SetDedup(t *testing.T) {
+	repo, controller, mockTCPConn := setupControllerTest(t, 0)
+	defer cleanupControllerTest(repo)
+
+	for i := 0; i < 3; i++ {
+		command := []string{"set", "test/dedup=order-1", "0", "0", "10"}
+		fmt.Fprintf(&mockTCPConn.ReadBuffer, "0123456789\r\n")
+		err = controller.Set(command)
+		assert.NoError(t, err)
+		assert.Equal(t, "STORED\r\n", mockTCPConn.WriteBuffer.String())
+		mockTCPConn.WriteBuffer.Reset()
+	}
+
+	q, err := repo.GetQueue("test")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, q.Length())
+
+	command := []string{"set", "test/unknown=1", "0", "0", "10"}
+	err = controller.Set(command)
+	assert.EqualError(t, err, "CLIENT_ERROR Invalid command")
+
+	command = []string{"set", "test/dedup=", "0", "0", "10"}
+	err = controller.Set(command)
+	assert.EqualError(t, err, "CLIENT_ERROR Invalid command")
+}
+
+func Test_Controller_parseSetCommand(t *testing.T) {
+	cmd, err := parseSetCommand([]string{"set", "work+other/group=g1/dedup=k1", "0", "0", "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "work", cmd.QueueName)
+	assert.Equal(t, []string{"work", "other"}, cmd.FanoutQueues)
+	assert.Equal(t, "g1", cmd.Group)
+	assert.Equal(t, "k1", cmd.DedupKey)
+
+	_, err = parseSetCommand([]string{"set", "work/group=a:b", "0", "0", "1"})
+	assert.Equal(t, ErrInvalidCommand, err)
+}
+
+func Test_Controller_SetReturnID(t *testing.T) {
+	repo, controller, mockTCPConn := setupControllerTest(t, 2)
+	defer cleanupControllerTest(repo)
+
+	command := []string{"set", "test/return_id", "0", "0", "1"}
+	fmt.Fprintf(&mockTCPConn.ReadBuffer, "a\r\n")
+	err = controller.Set(command)
+	assert.NoError(t, err)
+	assert.Equal(t, "STORED 3\r\n", mockTCPConn.WriteBuffer.String())
+	mockTCPConn.WriteBuffer.Reset()
+
+	// duplicate returns ID of the original item
+	for i, expected := range []string{"STORED 4\r\n", "STORED 4\r\n"} {
+		command = []string{"set", "test/return_id/dedup=k", "0", "0", "1"}
+		fmt.Fprintf(&mockTCPConn.ReadBuffer, "%d\r\n", i)
+		err = controller.Set(command)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, mockTCPConn.WriteBuffer.String())
+		mockTCPConn.WriteBuffer.Reset()
+	}
+
+	command = []string{"set", "test+fanout_test/return_id", "0", "0", "1"}
+	fmt.Fprintf(&mockTCPConn.ReadBuffer, "b\r\n")
+	err = controller.Set(command)
+	assert.NoError(t, err)
+	assert.Equal(t, "STORED 5 1\r\n", mockTCPConn.WriteBuffer.String())
+}
+
+func Test_Controller_SetTxn(t *testing.T) {
+	repo, controller, mockTCPConn := setupControllerTest(t, 2)
+	defer cleanupControllerTest(repo)
+
+	// a transactional set requires an open item
+	fmt.Fprintf(&mockTCPConn.ReadBuffer, "a\r\n")
+	err = controller.Set([]string{"set", "stage1/txn", "0", "0", "1"})
+	assert.Equal(t, ErrNoOpenItem, err)
+
+	err = controller.Get([]string{"get", "test/open"})
+	assert.NoError(t, err)
+	for _, value := range []string{"a", "b"} {
+		fmt.Fprintf(&mockTCPConn.ReadBuffer, "%s\r\n", value)
+		err = controller.Set([]string{"set", "stage1+stage2/txn", "0", "0", "1"})
+		assert.NoError(t, err)
+	}
+	stage1, _ := repo.GetQueue("stage1")
+	stage2, _ := repo.GetQueue("stage2")
+	assert.True(t, stage1.IsEmpty())
+
+	// abort discards staged items
+	err = controller.Get([]string{"get", "test/abort"})
+	assert.NoError(t, err)
+	assert.True(t, stage1.IsEmpty())
+
+	mockTCPConn.WriteBuffer.Reset()
+	err = controller.Get([]string{"get", "test/open"})
+	assert.NoError(t, err)
+	assert.Equal(t, "VALUE test 0 1\r\n0\r\nEND\r\n", mockTCPConn.WriteBuffer.String())
+	fmt.Fprintf(&mockTCPConn.ReadBuffer, "c\r\n")
+	err = controller.Set([]string{"set", "stage1/txn", "0", "0", "1"})
+	assert.NoError(t, err)
+
+	err = controller.Get([]string{"get", "test/commit"})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, stage1.Length())
+	assert.True(t, stage2.IsEmpty())
+
+	// items of several queues are committed with the transaction log
+	err = controller.Get([]string{"get", "test/open"})
+	assert.NoError(t, err)
+	for _, value := range []string{"d", "e"} {
+		fmt.Fprintf(&mockTCPConn.ReadBuffer, "%s\r\n", value)
+		err = controller.Set([]string{"set", "stage1+stage2/txn", "0", "0", "1"})
+		assert.NoError(t, err)
+	}
+	err = controller.Get([]string{"get", "test/close"})
+	assert.NoError(t, err)
+	q, _ := repo.GetQueue("test")
+	assert.True(t, q.IsEmpty())
+	for _, expected := range []string{"c", "d", "e"} {
+		value, _ := stage1.GetNext()
+		assert.Equal(t, expected, string(value))
+	}
+	for _, expected := range []string{"d", "e"} {
+		value, _ := stage2.GetNext()
+		assert.Equal(t, expected, string(value))
+	}
+
+	_, err = parseSetCommand([]string{"set", "stage1/txn/return_id", "0", "0", "1"})
+	assert.Equal(t, ErrInvalidCommand, err)
+}
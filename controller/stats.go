@@ -2,11 +2,24 @@ package controller
 
 import "fmt"
 
-// Stats handles STATS command
+// Stats handles STATS command, stats of the connection follow service stats
 func (c *Controller) Stats() error {
 	for _, item := range c.repo.FullStats() {
 		fmt.Fprintf(c.rw.Writer, "STAT %s %s\r\n", item.Key, item.Value)
 	}
+	// the open item of the connection, the lease deadline is 0 if it's not leased
+	if c.currentValue != nil {
+		name := c.currentCommand.QueueName
+		if c.currentCommand.ConsumerGroup != "" {
+			name += cgSeparator + c.currentCommand.ConsumerGroup
+		}
+		var deadline int64
+		if !c.currentDeadline.IsZero() {
+			deadline = c.currentDeadline.Unix()
+		}
+		fmt.Fprintf(c.rw.Writer, "STAT session_open_item %s\r\n", name)
+		fmt.Fprintf(c.rw.Writer, "STAT session_lease_deadline %d\r\n", deadline)
+	}
 	fmt.Fprintf(c.rw.Writer, endMessage)
 	c.rw.Writer.Flush()
 	return nil
@@ -2,6 +2,8 @@ package controller
 
 import (
 	"fmt"
+	"regexp"
+	"strconv"
 	"testing"
 	"time"
 
@@ -17,9 +19,16 @@ func Test_Controller_Stats(t *testing.T) {
 
 	cg, err := q.ConsumerGroup("cg1")
 	assert.NoError(t, err)
+	before := time.Now().Unix()
 	cg.GetNext()
 
 	err = controller.Stats()
+	var lastRead int64
+	match := regexp.MustCompile(`cg1_last_read (\d+)`).FindStringSubmatch(mockTCPConn.WriteBuffer.String())
+	if assert.Len(t, match, 2) {
+		lastRead, _ = strconv.ParseInt(match[1], 10, 64)
+	}
+	assert.True(t, lastRead >= before && lastRead <= time.Now().Unix())
 	statsResponse := "STAT uptime 0\r\n" +
 		fmt.Sprintf("STAT time %d\r\n", time.Now().Unix()) +
 		"STAT version " + repo.Stats.Version + "\r\n" +
@@ -27,11 +36,89 @@ func Test_Controller_Stats(t *testing.T) {
 		"STAT total_connections 1\r\n" +
 		"STAT cmd_get 0\r\n" +
 		"STAT cmd_set 0\r\n" +
+		"STAT quarantined_queues 0\r\n" +
+		"STAT exchange_bindings 0\r\n" +
+		"STAT exchange_unmatched 0\r\n" +
 		fmt.Sprintf("STAT queue_test_items %d\r\n", 3) +
 		"STAT queue_test_open_transactions 0\r\n" +
 		fmt.Sprintf("STAT queue_test.cg1_items %d\r\n", 2) +
 		"STAT queue_test.cg1_open_transactions 0\r\n" +
+		"STAT queue_test.cg1_cursor 1\r\n" +
+		"STAT queue_test.cg1_lag_bytes 0\r\n" +
+		"STAT queue_test.cg1_oldest_item_age 0\r\n" +
+		"STAT queue_test.cg1_failed_reads 0\r\n" +
+		fmt.Sprintf("STAT queue_test.cg1_last_read %d\r\n", lastRead) +
+		"STAT queue_test.cg1_read_rate 0.00\r\n" +
+		"STAT queue_test.cg1_sessions 0\r\n" +
 		"END\r\n"
 	assert.Nil(t, err)
 	assert.Equal(t, statsResponse, mockTCPConn.WriteBuffer.String())
 }
+
+func Test_Controller_StatsRetained(t *testing.T) {
+	repo, controller, mockTCPConn := setupControllerTest(t, 3)
+	defer cleanupControllerTest(repo)
+
+	q, err := repo.GetQueue("test")
+	assert.NoError(t, err)
+	assert.NoError(t, q.SetOption("protect_cursors", "true"))
+
+	_, err = q.ConsumerGroup("cg1")
+	assert.NoError(t, err)
+	q.GetNext()
+
+	err = controller.Stats()
+	assert.NoError(t, err)
+	assert.Contains(t, mockTCPConn.WriteBuffer.String(),
+		"STAT queue_test_items 2\r\n"+
+			"STAT queue_test_open_transactions 0\r\n"+
+			"STAT queue_test_retained 1\r\n")
+}
+
+func Test_Controller_StatsSessions(t *testing.T) {
+	repo, controller, mockTCPConn := setupControllerTest(t, 3)
+	defer cleanupControllerTest(repo)
+
+	err = controller.Get([]string{"get", "test.cg1"})
+	assert.NoError(t, err)
+	err = controller.Get([]string{"get", "test.cg1/open"})
+	assert.NoError(t, err)
+	mockTCPConn.WriteBuffer.Reset()
+
+	err = controller.Stats()
+	assert.NoError(t, err)
+	assert.Contains(t, mockTCPConn.WriteBuffer.String(), "STAT queue_test.cg1_failed_reads 0\r\n")
+	assert.Contains(t, mockTCPConn.WriteBuffer.String(), "STAT queue_test.cg1_sessions 1\r\n")
+
+	// unfinished read is returned to the consumer group
+	controller.FinishSession()
+	mockTCPConn.WriteBuffer.Reset()
+	err = controller.Stats()
+	assert.NoError(t, err)
+	assert.Contains(t, mockTCPConn.WriteBuffer.String(), "STAT queue_test.cg1_failed_reads 1\r\n")
+	assert.Contains(t, mockTCPConn.WriteBuffer.String(), "STAT queue_test.cg1_sessions 0\r\n")
+}
+
+func Test_Controller_StatsSessionsSwitch(t *testing.T) {
+	repo, controller, mockTCPConn := setupControllerTest(t, 3)
+	defer cleanupControllerTest(repo)
+
+	sessions := func(expected ...string) {
+		mockTCPConn.WriteBuffer.Reset()
+		assert.NoError(t, controller.Stats())
+		for _, line := range expected {
+			assert.Contains(t, mockTCPConn.WriteBuffer.String(), line+"\r\n")
+		}
+	}
+
+	// a session reads one consumer group at a time
+	assert.NoError(t, controller.Get([]string{"get", "test.cg1"}))
+	assert.NoError(t, controller.Get([]string{"get", "test.cg1"}))
+	sessions("STAT queue_test.cg1_sessions 1")
+
+	assert.NoError(t, controller.Get([]string{"get", "test.cg2"}))
+	sessions("STAT queue_test.cg1_sessions 0", "STAT queue_test.cg2_sessions 1")
+
+	assert.NoError(t, controller.Get([]string{"get", "test"}))
+	sessions("STAT queue_test.cg1_sessions 0", "STAT queue_test.cg2_sessions 0")
+}
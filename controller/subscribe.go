@@ -0,0 +1,275 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bogdanovich/siberite/cgroup"
+	"github.com/bogdanovich/siberite/queue"
+)
+
+const (
+	// maxPrefetch limits unacknowledged items of a subscription
+	maxPrefetch = 1000
+	// subscribePollInterval is how often an empty queue
+	// is checked for new items
+	subscribePollInterval = 50 * time.Millisecond
+)
+
+// subscription pushes items of a queue or consumer group to a session,
+// pushed items stay open until they are acknowledged
+type subscription struct {
+	sync.Mutex
+	cmd      *Command
+	queue    *cgroup.CGQueue
+	consumer cgroup.GroupConsumer
+	prefetch int
+	lastID   uint64
+	unacked  map[uint64]*queue.Item
+	// closed is set when the pusher stops because
+	// the subscribed queue was deleted or closed
+	closed bool
+	wake   chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// Subscribe handles SUBSCRIBE command
+// Command: SUBSCRIBE <queue>[.<cursor>] [prefetch=<n>]
+// switches the session into push mode. Items are opened as reliable
+// reads and pushed as they arrive, up to n (1 by default) unacknowledged
+// items at once. Message groups keep at most one open item per group.
+// Response:
+// END
+// VALUE <queue> 0 <bytes> <delivery id>
+// <data block>
+// ...
+//
+// Command: ACK <delivery id> [<delivery id> ...] confirms pushed items
+// Command: NAK <delivery id> [<delivery id> ...] returns pushed items
+// back to the queue
+// Only errors are responded
+//
+// Command: UNSUBSCRIBE returns unacknowledged items back to the queue,
+// a disconnect does the same
+// Response:
+// END
+//
+// If the subscribed queue is deleted, pushing stops with an error and
+// the session leaves push mode with its next command
+func (c *Controller) Subscribe(input []string) error {
+	if len(input) < 2 || len(input) > 3 {
+		return ErrInvalidCommand
+	}
+	prefetch := 1
+	if len(input) == 3 {
+		var err error
+		if !strings.HasPrefix(input[2], "prefetch=") {
+			return ErrInvalidCommand
+		}
+		prefetch, err = strconv.Atoi(strings.TrimPrefix(input[2], "prefetch="))
+		if err != nil || prefetch < 1 || prefetch > maxPrefetch {
+			return ErrInvalidCommand
+		}
+	}
+	if c.currentValue != nil {
+		return ErrCloseCurrentItemFirst
+	}
+
+	cmd := parseCommand(input)
+	q, consumer, err := c.getQueueConsumer(cmd)
+	if err != nil {
+		log.Println(cmd, err)
+		return lookupError(err)
+	}
+	if cmd.ConsumerGroup == "" && q.Options().Mode == cgroup.ModeLog {
+		return NewError(clientError, cgroup.ErrLogMode)
+	}
+	c.setReader(consumer)
+	c.leaseExpired = nil
+
+	c.sub = &subscription{
+		cmd:      cmd,
+		queue:    q,
+		consumer: consumer,
+		prefetch: prefetch,
+		unacked:  make(map[uint64]*queue.Item),
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	c.wlock.Lock()
+	fmt.Fprint(c.rw.Writer, endMessage)
+	err = c.rw.Writer.Flush()
+	c.wlock.Unlock()
+	go c.push(c.sub)
+	return err
+}
+
+// Ack handles ACK and NAK commands of a session in push mode
+func (c *Controller) Ack(input []string) error {
+	if len(input) < 2 {
+		return ErrInvalidCommand
+	}
+	s := c.sub
+	for _, token := range input[1:] {
+		id, err := strconv.ParseUint(token, 10, 64)
+		if err != nil {
+			return ErrInvalidCommand
+		}
+		item := s.remove(id)
+		if item == nil {
+			return ErrUnknownDelivery
+		}
+		if input[0] == "ack" {
+			if item.Group != "" {
+				err = s.consumer.CloseGroupItem(item.Group, item.ID)
+			}
+		} else {
+			err = rollback(s.consumer, item.Value, item.Group)
+		}
+		s.consumer.Stats().UpdateOpenReads(-1)
+		if err != nil {
+			log.Println(s.cmd, err)
+			return NewError(commonError, err)
+		}
+	}
+	s.notify()
+	return nil
+}
+
+// Unsubscribe handles UNSUBSCRIBE command
+func (c *Controller) Unsubscribe(input []string) error {
+	if len(input) != 1 {
+		return ErrInvalidCommand
+	}
+	c.unsubscribe()
+	c.wlock.Lock()
+	defer c.wlock.Unlock()
+	fmt.Fprint(c.rw.Writer, endMessage)
+	return c.rw.Writer.Flush()
+}
+
+// unsubscribe stops pushing items and returns
+// unacknowledged items back to the queue
+func (c *Controller) unsubscribe() {
+	s := c.sub
+	close(s.stop)
+	<-s.done
+	for id := range s.unacked {
+		item := s.remove(id)
+		if err := rollback(s.consumer, item.Value, item.Group); err != nil {
+			log.Println(s.cmd, err)
+		}
+		s.consumer.Stats().UpdateOpenReads(-1)
+	}
+	c.sub = nil
+}
+
+// push opens items and writes them to the client
+// while the subscription has room for unacknowledged items
+func (c *Controller) push(s *subscription) {
+	defer close(s.done)
+	for {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		if s.full() {
+			s.wait()
+			continue
+		}
+		// the queue is looked up on every poll, so it's not closed
+		// as idle while subscribed, a deleted queue stops the push
+		if q, ok := c.repo.OpenQueue(s.cmd.QueueName); !ok || q != s.queue {
+			log.Println(s.cmd, "subscribed queue was deleted or closed")
+			s.close()
+			c.SendError(ErrSubscriptionClosed)
+			return
+		}
+		item, err := s.consumer.OpenNext()
+		if err == nil && len(item.Value) == 0 && item.Group != "" {
+			s.consumer.CloseGroup(item.Group)
+		}
+		if err != nil || len(item.Value) == 0 {
+			s.wait()
+			continue
+		}
+
+		id := s.add(item)
+		s.consumer.Stats().UpdateOpenReads(1)
+		atomic.AddUint64(&c.repo.Stats.CmdGet, 1)
+		c.wlock.Lock()
+		fmt.Fprintf(c.rw.Writer, "VALUE %s 0 %d %d\r\n", s.cmd.QueueName, len(item.Value), id)
+		fmt.Fprintf(c.rw.Writer, "%s\r\n", item.Value)
+		err = c.rw.Writer.Flush()
+		c.wlock.Unlock()
+		if err != nil {
+			// the item is returned back when the session finishes
+			log.Println(s.cmd, err)
+			return
+		}
+	}
+}
+
+// wait blocks until an item is acknowledged, the poll
+// interval passes or the subscription is stopped
+func (s *subscription) wait() {
+	select {
+	case <-s.stop:
+	case <-s.wake:
+	case <-time.After(subscribePollInterval):
+	}
+}
+
+// notify wakes up the pusher waiting for room
+func (s *subscription) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// close marks the subscription closed by the pusher
+func (s *subscription) close() {
+	s.Lock()
+	defer s.Unlock()
+	s.closed = true
+}
+
+func (s *subscription) isClosed() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.closed
+}
+
+func (s *subscription) full() bool {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.unacked) >= s.prefetch
+}
+
+// add registers a pushed item and returns its delivery ID
+func (s *subscription) add(item *queue.Item) uint64 {
+	s.Lock()
+	defer s.Unlock()
+	s.lastID++
+	s.unacked[s.lastID] = item
+	return s.lastID
+}
+
+// remove unregisters a pushed item, returns nil if it's unknown
+func (s *subscription) remove(id uint64) *queue.Item {
+	s.Lock()
+	defer s.Unlock()
+	item := s.unacked[id]
+	delete(s.unacked, id)
+	return item
+}
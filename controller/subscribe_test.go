@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bogdanovich/siberite/repository"
+)
+
+func Test_Controller_Subscribe(t *testing.T) {
+	repo, controller, mockTCPConn := setupControllerTest(t, 1)
+	defer cleanupControllerTest(repo)
+
+	err = controller.Subscribe([]string{"subscribe", "test", "prefetch=0"})
+	assert.Equal(t, ErrInvalidCommand, err)
+	err = controller.Subscribe([]string{"subscribe", "test", "n=1"})
+	assert.Equal(t, ErrInvalidCommand, err)
+	err = controller.Subscribe([]string{"subscribe", "test/open"})
+	assert.Error(t, err)
+
+	err = controller.Subscribe([]string{"subscribe", "test", "prefetch=10"})
+	assert.NoError(t, err)
+	// the pushed item is returned back to the queue on unsubscribe
+	err = controller.Unsubscribe([]string{"unsubscribe"})
+	assert.NoError(t, err)
+	assert.Contains(t, []string{"END\r\nEND\r\n", "END\r\nVALUE test 0 1 1\r\n0\r\nEND\r\n"},
+		mockTCPConn.WriteBuffer.String())
+	q, _ := repo.GetQueue("test")
+	assert.EqualValues(t, 1, q.Length())
+	assert.EqualValues(t, 0, q.Stats().OpenReads)
+
+	log, _ := repo.GetQueue("events")
+	log.SetOption("mode", "log")
+	err = controller.Subscribe([]string{"subscribe", "events"})
+	assert.EqualError(t, err, "CLIENT_ERROR cgroup: queue is in log mode, read it with a durable cursor")
+}
+
+func Test_Controller_SubscribeIdleTimeout(t *testing.T) {
+	opts := &repository.Options{IdleTimeout: 200 * time.Millisecond}
+	repo, err := repository.NewRepositoryWithOptions(dir, opts)
+	assert.NoError(t, err)
+	defer cleanupControllerTest(repo)
+	mockTCPConn := newMockTCPConn()
+	controller := NewSession(mockTCPConn, repo)
+
+	q, _ := repo.GetQueue("work")
+	err = controller.Subscribe([]string{"subscribe", "work"})
+	assert.NoError(t, err)
+
+	// a subscribed queue is not closed as idle
+	time.Sleep(400 * time.Millisecond)
+	repo.Maintain()
+	current, err := repo.GetQueue("work")
+	assert.NoError(t, err)
+	assert.Equal(t, q, current)
+	q.Enqueue([]byte("1"))
+	time.Sleep(200 * time.Millisecond)
+	assert.NoError(t, controller.Ack([]string{"ack", "1"}))
+
+	// a deleted queue stops the subscription
+	assert.NoError(t, repo.DeleteQueue("work"))
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, "END\r\nVALUE work 0 1 1\r\n1\r\nERROR Subscription closed\r\n",
+		mockTCPConn.WriteBuffer.String())
+
+	// and the session leaves push mode with the next command
+	mockTCPConn.WriteBuffer.Reset()
+	fmt.Fprintf(&mockTCPConn.ReadBuffer, "get work\r\n")
+	assert.NoError(t, controller.Dispatch())
+	assert.Nil(t, controller.sub)
+	assert.Equal(t, "END\r\n", mockTCPConn.WriteBuffer.String())
+}
@@ -0,0 +1,36 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const touchedMessage = "TOUCHED\r\n"
+
+// Touch handles TOUCH command
+// Command: TOUCH <queue>[.<cursor>] <seconds>
+// extends the lease of the item opened by the session,
+// the new lease deadline is <seconds> from now
+// Response: TOUCHED, NOT_FOUND if the session has no open item
+// of the queue
+func (c *Controller) Touch(input []string) error {
+	if len(input) != 3 {
+		return ErrInvalidCommand
+	}
+	seconds, err := strconv.ParseUint(input[2], 10, 32)
+	if err != nil || seconds == 0 {
+		return ErrInvalidCommand
+	}
+	cmd := parseCommand(input)
+
+	current := c.currentCommand
+	if c.currentValue == nil || current.QueueName != cmd.QueueName ||
+		current.ConsumerGroup != cmd.ConsumerGroup {
+		fmt.Fprint(c.rw.Writer, notFoundMessage)
+		return c.rw.Writer.Flush()
+	}
+	c.currentDeadline = time.Now().Add(time.Duration(seconds) * time.Second)
+	fmt.Fprint(c.rw.Writer, touchedMessage)
+	return c.rw.Writer.Flush()
+}
@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Controller_Touch(t *testing.T) {
+	repo, controller, mockTCPConn := setupControllerTest(t, 2)
+	defer cleanupControllerTest(repo)
+	q, _ := repo.GetQueue("test")
+
+	err = controller.Get([]string{"get", "test/open/lease=30"})
+	assert.NoError(t, err)
+	deadline := controller.currentDeadline.Unix()
+	assert.InDelta(t, time.Now().Add(30*time.Second).Unix(), deadline, 1)
+	assert.Equal(t, "VALUE test 0 1\r\n0\r\nEND\r\n", mockTCPConn.WriteBuffer.String())
+
+	mockTCPConn.WriteBuffer.Reset()
+	controller.Stats()
+	assert.True(t, strings.HasSuffix(mockTCPConn.WriteBuffer.String(),
+		fmt.Sprintf("STAT session_open_item test\r\nSTAT session_lease_deadline %d\r\nEND\r\n", deadline)))
+
+	mockTCPConn.WriteBuffer.Reset()
+	err = controller.Touch([]string{"touch", "test", "60"})
+	assert.NoError(t, err)
+	assert.Equal(t, "TOUCHED\r\n", mockTCPConn.WriteBuffer.String())
+	assert.True(t, controller.currentDeadline.Unix() > deadline)
+
+	mockTCPConn.WriteBuffer.Reset()
+	err = controller.Touch([]string{"touch", "test.cg", "60"})
+	assert.NoError(t, err)
+	assert.Equal(t, "NOT_FOUND\r\n", mockTCPConn.WriteBuffer.String())
+
+	err = controller.Touch([]string{"touch", "test", "0"})
+	assert.Equal(t, ErrInvalidCommand, err)
+
+	// the lapsed item is returned back to the queue
+	controller.currentDeadline = time.Now().Add(-time.Second)
+	controller.expireLease()
+	assert.Nil(t, controller.currentValue)
+	assert.EqualValues(t, 2, q.Length())
+	assert.EqualValues(t, 0, q.Stats().OpenReads)
+
+	err = controller.Get([]string{"get", "test/close"})
+	assert.Equal(t, ErrLeaseExpired, err)
+	mockTCPConn.WriteBuffer.Reset()
+	err = controller.Get([]string{"get", "test/close"})
+	assert.NoError(t, err)
+	assert.Equal(t, "END\r\n", mockTCPConn.WriteBuffer.String())
+
+	// items opened without a lease are not expired
+	controller.Get([]string{"get", "test/open"})
+	controller.expireLease()
+	assert.NotNil(t, controller.currentValue)
+
+	err = controller.Get([]string{"get", "test/lease=5"})
+	assert.Equal(t, ErrInvalidCommand, err)
+}
+
+func Test_Controller_TouchLeaseExpired(t *testing.T) {
+	repo, controller, mockTCPConn := setupControllerTest(t, 2)
+	defer cleanupControllerTest(repo)
+	other, _ := repo.GetQueue("other")
+	other.Enqueue([]byte("x"))
+
+	expire := func() {
+		controller.currentDeadline = time.Now().Add(-time.Second)
+		controller.expireLease()
+	}
+
+	// close/open of the lapsed item opens the next one
+	controller.Get([]string{"get", "test/open/lease=30"})
+	expire()
+	mockTCPConn.WriteBuffer.Reset()
+	err = controller.Get([]string{"get", "test/close/open"})
+	assert.NoError(t, err)
+	assert.Equal(t, "VALUE test 0 1\r\n0\r\nEND\r\n", mockTCPConn.WriteBuffer.String())
+	controller.Get([]string{"get", "test/abort"})
+
+	// the lapsed lease is reported for its own queue only
+	controller.Get([]string{"get", "test/open/lease=30"})
+	expire()
+	err = controller.Get([]string{"get", "other/close"})
+	assert.NoError(t, err)
+	err = controller.Get([]string{"get", "test/close"})
+	assert.NoError(t, err)
+
+	// and to the next read command only
+	controller.Get([]string{"get", "test/open/lease=30"})
+	expire()
+	err = controller.Get([]string{"get", "other"})
+	assert.NoError(t, err)
+	err = controller.Get([]string{"get", "test/close"})
+	assert.NoError(t, err)
+
+	controller.Get([]string{"get", "test/open/lease=30"})
+	expire()
+	err = controller.Get([]string{"get", "other|test/close"})
+	assert.Equal(t, ErrLeaseExpired, err)
+	controller.Get([]string{"get", "test/open/lease=30"})
+	expire()
+	err = controller.Get([]string{"get", "test/commit/open"})
+	assert.Equal(t, ErrLeaseExpired, err)
+}
@@ -0,0 +1,214 @@
+// Package dump reads and writes queue items in portable formats:
+// JSON lines or a binary length-prefixed dump
+package dump
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
+	"time"
+)
+
+var (
+	// ErrUnknownFormat is returned for file names without
+	// .jsonl or .dump extension
+	ErrUnknownFormat = errors.New("dump: unknown format, use .jsonl or .dump file extension")
+	// ErrInvalidDump is returned when a dump can't be read
+	ErrInvalidDump = errors.New("dump: invalid dump")
+)
+
+// Formats
+const (
+	FormatJSONL = "jsonl"
+	FormatDump  = "dump"
+)
+
+const (
+	// dumpMagic starts every binary dump
+	dumpMagic = "siberite-dump-1\n"
+	// maxFieldSize protects from allocating memory for a corrupted length
+	maxFieldSize = 1 << 30
+)
+
+// Record represents an exported item
+type Record struct {
+	ID        uint64
+	Timestamp time.Time
+	// Flags are always 0, siberite doesn't store memcache flags
+	Flags uint32
+	Group string
+	Value []byte
+}
+
+// jsonRecord is a JSON line, value is base64 encoded
+type jsonRecord struct {
+	ID        uint64 `json:"id"`
+	Timestamp string `json:"timestamp,omitempty"`
+	Flags     uint32 `json:"flags"`
+	Group     string `json:"group,omitempty"`
+	Value     []byte `json:"value"`
+}
+
+// FormatFromPath returns format by file extension
+func FormatFromPath(path string) (string, error) {
+	switch filepath.Ext(path) {
+	case ".jsonl":
+		return FormatJSONL, nil
+	case ".dump":
+		return FormatDump, nil
+	}
+	return "", ErrUnknownFormat
+}
+
+// Writer writes records in one of the formats
+type Writer struct {
+	format string
+	w      *bufio.Writer
+	buf    []byte
+}
+
+// NewWriter creates a writer of the format
+func NewWriter(w io.Writer, format string) (*Writer, error) {
+	d := &Writer{format: format, w: bufio.NewWriter(w), buf: make([]byte, binary.MaxVarintLen64)}
+	switch format {
+	case FormatJSONL:
+		return d, nil
+	case FormatDump:
+		_, err := d.w.WriteString(dumpMagic)
+		return d, err
+	}
+	return nil, ErrUnknownFormat
+}
+
+// Write writes a record
+func (d *Writer) Write(r *Record) error {
+	if d.format == FormatJSONL {
+		line := jsonRecord{ID: r.ID, Flags: r.Flags, Group: r.Group, Value: r.Value}
+		if !r.Timestamp.IsZero() {
+			line.Timestamp = r.Timestamp.UTC().Format(time.RFC3339Nano)
+		}
+		data, err := json.Marshal(line)
+		if err != nil {
+			return err
+		}
+		d.w.Write(data)
+		return d.w.WriteByte('\n')
+	}
+
+	var timestamp int64
+	if !r.Timestamp.IsZero() {
+		timestamp = r.Timestamp.UnixNano()
+	}
+	d.writeUvarint(r.ID)
+	d.w.Write(d.buf[:binary.PutVarint(d.buf, timestamp)])
+	d.writeUvarint(uint64(r.Flags))
+	d.writeUvarint(uint64(len(r.Group)))
+	d.w.WriteString(r.Group)
+	d.writeUvarint(uint64(len(r.Value)))
+	_, err := d.w.Write(r.Value)
+	return err
+}
+
+// Flush writes buffered records to the underlying writer
+func (d *Writer) Flush() error {
+	return d.w.Flush()
+}
+
+func (d *Writer) writeUvarint(value uint64) {
+	d.w.Write(d.buf[:binary.PutUvarint(d.buf, value)])
+}
+
+// Reader reads records in one of the formats
+type Reader struct {
+	format string
+	r      *bufio.Reader
+}
+
+// NewReader creates a reader of the format
+func NewReader(r io.Reader, format string) (*Reader, error) {
+	d := &Reader{format: format, r: bufio.NewReader(r)}
+	switch format {
+	case FormatJSONL:
+		return d, nil
+	case FormatDump:
+		magic := make([]byte, len(dumpMagic))
+		if _, err := io.ReadFull(d.r, magic); err != nil || string(magic) != dumpMagic {
+			return nil, ErrInvalidDump
+		}
+		return d, nil
+	}
+	return nil, ErrUnknownFormat
+}
+
+// Read returns next record, io.EOF is returned at the end of the dump
+func (d *Reader) Read() (*Record, error) {
+	if d.format == FormatJSONL {
+		return d.readJSON()
+	}
+	id, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, ErrInvalidDump
+	}
+	r := &Record{ID: id}
+	timestamp, err := binary.ReadVarint(d.r)
+	if err != nil {
+		return nil, ErrInvalidDump
+	}
+	if timestamp != 0 {
+		r.Timestamp = time.Unix(0, timestamp)
+	}
+	flags, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return nil, ErrInvalidDump
+	}
+	r.Flags = uint32(flags)
+	group, err := d.readBytes()
+	if err != nil {
+		return nil, err
+	}
+	r.Group = string(group)
+	r.Value, err = d.readBytes()
+	return r, err
+}
+
+func (d *Reader) readJSON() (*Record, error) {
+	var line []byte
+	for len(line) == 0 {
+		data, err := d.r.ReadBytes('\n')
+		line = bytes.TrimSpace(data)
+		if len(line) == 0 && err != nil {
+			return nil, err
+		}
+	}
+	var j jsonRecord
+	if err := json.Unmarshal(line, &j); err != nil {
+		return nil, ErrInvalidDump
+	}
+	r := &Record{ID: j.ID, Flags: j.Flags, Group: j.Group, Value: j.Value}
+	if j.Timestamp != "" {
+		var err error
+		if r.Timestamp, err = time.Parse(time.RFC3339Nano, j.Timestamp); err != nil {
+			return nil, ErrInvalidDump
+		}
+	}
+	return r, nil
+}
+
+func (d *Reader) readBytes() ([]byte, error) {
+	length, err := binary.ReadUvarint(d.r)
+	if err != nil || length > maxFieldSize {
+		return nil, ErrInvalidDump
+	}
+	data := make([]byte, length)
+	if _, err = io.ReadFull(d.r, data); err != nil {
+		return nil, ErrInvalidDump
+	}
+	return data, nil
+}
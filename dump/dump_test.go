@@ -0,0 +1,141 @@
+package dump
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bogdanovich/siberite/cgroup"
+)
+
+var dir = "./test_data"
+
+func TestMain(m *testing.M) {
+	os.RemoveAll(dir)
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		fmt.Println(err)
+	}
+	result := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(result)
+}
+
+func Test_FormatFromPath(t *testing.T) {
+	format, err := FormatFromPath("backlog.jsonl")
+	assert.NoError(t, err)
+	assert.Equal(t, FormatJSONL, format)
+
+	format, err = FormatFromPath("dir/backlog.dump")
+	assert.NoError(t, err)
+	assert.Equal(t, FormatDump, format)
+
+	_, err = FormatFromPath("backlog.json")
+	assert.Equal(t, ErrUnknownFormat, err)
+}
+
+func Test_WriterReader(t *testing.T) {
+	records := []*Record{
+		{ID: 1, Timestamp: time.Unix(0, 1500000000123456789), Value: []byte("1")},
+		{ID: 5, Group: "user1", Value: []byte{0, 1, 2}},
+		{ID: 6, Value: []byte{}},
+	}
+	for _, format := range []string{FormatJSONL, FormatDump} {
+		buf := &bytes.Buffer{}
+		w, err := NewWriter(buf, format)
+		assert.NoError(t, err)
+		for _, r := range records {
+			assert.NoError(t, w.Write(r))
+		}
+		assert.NoError(t, w.Flush())
+
+		r, err := NewReader(bytes.NewReader(buf.Bytes()), format)
+		assert.NoError(t, err)
+		for _, expected := range records {
+			record, err := r.Read()
+			assert.NoError(t, err)
+			assert.Equal(t, expected.ID, record.ID)
+			assert.True(t, expected.Timestamp.Equal(record.Timestamp))
+			assert.Equal(t, expected.Group, record.Group)
+			assert.Equal(t, expected.Value, record.Value)
+		}
+		_, err = r.Read()
+		assert.Equal(t, io.EOF, err)
+
+		r, _ = NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-2]), format)
+		r.Read()
+		r.Read()
+		_, err = r.Read()
+		assert.Equal(t, ErrInvalidDump, err)
+	}
+
+	_, err := NewWriter(&bytes.Buffer{}, "xml")
+	assert.Equal(t, ErrUnknownFormat, err)
+	_, err = NewReader(strings.NewReader("garbage"), FormatDump)
+	assert.Equal(t, ErrInvalidDump, err)
+}
+
+func Test_ReaderJSONL(t *testing.T) {
+	input := `{"id":1,"timestamp":"2017-07-14T02:40:00Z","flags":0,"value":"MQ=="}` + "\n\n" +
+		`{"id":2,"flags":0,"group":"g","value":"Mg=="}`
+	r, err := NewReader(strings.NewReader(input), FormatJSONL)
+	assert.NoError(t, err)
+
+	record, err := r.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(record.Value))
+	assert.EqualValues(t, 1500000000, record.Timestamp.Unix())
+
+	record, err = r.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "2", string(record.Value))
+	assert.Equal(t, "g", record.Group)
+
+	_, err = r.Read()
+	assert.Equal(t, io.EOF, err)
+}
+
+func Test_ExportImport(t *testing.T) {
+	q, err := cgroup.CGQueueOpen("source", dir)
+	assert.NoError(t, err)
+	defer q.Drop()
+	for i := 1; i <= 5; i++ {
+		q.Enqueue([]byte(fmt.Sprintf("%d", i)))
+	}
+	cg, _ := q.ConsumerGroup("cg1")
+	cg.GetNext()
+	cg.GetNext()
+	q.GetNext()
+
+	buf := &bytes.Buffer{}
+	w, _ := NewWriter(buf, FormatJSONL)
+	n, err := Export(w, cg)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	n, err = Export(w, q)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	target, err := cgroup.CGQueueOpen("target", dir)
+	assert.NoError(t, err)
+	defer target.Drop()
+	target.Enqueue([]byte("0"))
+
+	r, _ := NewReader(buf, FormatJSONL)
+	n, err = Import(r, target.Queue)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, n)
+	assert.EqualValues(t, 8, target.Length())
+
+	for _, expected := range []string{"0", "3", "4", "5", "2", "3", "4", "5"} {
+		value, err := target.GetNext()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(value))
+	}
+}
@@ -0,0 +1,67 @@
+package dump
+
+import (
+	"io"
+
+	"github.com/bogdanovich/siberite/cgroup"
+	"github.com/bogdanovich/siberite/queue"
+)
+
+// importBatchSize is the number of items enqueued at once by Import
+const importBatchSize = 1000
+
+// Source is a queue or a consumer group pending items are exported from
+type Source interface {
+	Export(fn func(*cgroup.PageItem) error) error
+}
+
+// Export writes pending items of the source in the order they are
+// going to be served. Returns the number of exported items.
+func Export(w *Writer, source Source) (int, error) {
+	n := 0
+	err := source.Export(func(item *cgroup.PageItem) error {
+		n++
+		return w.Write(&Record{
+			ID:        item.ID,
+			Timestamp: item.Timestamp,
+			Group:     item.Group,
+			Value:     item.Value,
+		})
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, w.Flush()
+}
+
+// Import appends all records to the queue in batches. Items get
+// new IDs and enqueue times, so item timestamps stay ordered.
+// Returns the number of imported items.
+func Import(r *Reader, q *queue.Queue) (int, error) {
+	n := 0
+	items := make([]*queue.Item, 0, importBatchSize)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+		items = append(items, &queue.Item{Value: record.Value, Group: record.Group})
+		if len(items) == importBatchSize {
+			if err = q.EnqueueBatch(items); err != nil {
+				return n, err
+			}
+			n += len(items)
+			items = items[:0]
+		}
+	}
+	if len(items) == 0 {
+		return n, nil
+	}
+	if err := q.EnqueueBatch(items); err != nil {
+		return n, err
+	}
+	return n + len(items), nil
+}
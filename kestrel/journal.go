@@ -0,0 +1,183 @@
+// Package kestrel reads Kestrel queue journals, so queues
+// can be migrated from Kestrel to siberite
+package kestrel
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrTruncated is returned when the journal ends in the middle of a record,
+// that's what a Kestrel journal looks like after a crash
+var ErrTruncated = errors.New("kestrel: journal is truncated")
+
+// journal record opcodes
+const (
+	cmdAdd                = 0
+	cmdRemove             = 1
+	cmdAddX               = 2
+	cmdRemoveTentative    = 3
+	cmdSaveXID            = 4
+	cmdUnremove           = 5
+	cmdConfirmRemove      = 6
+	cmdAddXID             = 7
+	cmdRemoveTentativeXID = 9
+)
+
+// maxBlockSize protects from allocating memory for a corrupted length
+const maxBlockSize = 1 << 30
+
+// Op is a journal operation
+type Op int
+
+// Journal operations
+const (
+	OpAdd Op = iota
+	OpRemove
+	OpRemoveTentative
+	OpSaveXID
+	OpUnremove
+	OpConfirmRemove
+	// OpContinue re-adds an open transaction item when a journal is packed
+	OpContinue
+)
+
+// Item is a queue item stored in a journal
+type Item struct {
+	AddTime time.Time
+	// Expiry is zero if the item never expires
+	Expiry time.Time
+	Data   []byte
+}
+
+// Record is a journal record
+type Record struct {
+	Op   Op
+	XID  uint32
+	Item *Item
+}
+
+// JournalReader reads records of a journal file
+type JournalReader struct {
+	r *bufio.Reader
+}
+
+// NewJournalReader creates a journal reader
+func NewJournalReader(r io.Reader) *JournalReader {
+	return &JournalReader{r: bufio.NewReader(r)}
+}
+
+// Read returns next record, io.EOF is returned at the end of the journal
+func (j *JournalReader) Read() (*Record, error) {
+	opcode, err := j.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch opcode {
+	case cmdAdd:
+		data, err := j.readBlock()
+		if err != nil {
+			return nil, err
+		}
+		return &Record{Op: OpAdd, Item: unpackOldAdd(data)}, nil
+	case cmdAddX:
+		data, err := j.readBlock()
+		if err != nil {
+			return nil, err
+		}
+		item, err := unpack(data)
+		return &Record{Op: OpAdd, Item: item}, err
+	case cmdAddXID:
+		xid, err := j.readInt()
+		if err != nil {
+			return nil, err
+		}
+		data, err := j.readBlock()
+		if err != nil {
+			return nil, err
+		}
+		item, err := unpack(data)
+		return &Record{Op: OpContinue, XID: xid, Item: item}, err
+	case cmdRemove:
+		return &Record{Op: OpRemove}, nil
+	case cmdRemoveTentative:
+		return &Record{Op: OpRemoveTentative}, nil
+	}
+
+	ops := map[byte]Op{
+		cmdRemoveTentativeXID: OpRemoveTentative,
+		cmdSaveXID:            OpSaveXID,
+		cmdUnremove:           OpUnremove,
+		cmdConfirmRemove:      OpConfirmRemove,
+	}
+	op, ok := ops[opcode]
+	if !ok {
+		return nil, fmt.Errorf("kestrel: unknown journal opcode %d", opcode)
+	}
+	xid, err := j.readInt()
+	return &Record{Op: op, XID: xid}, err
+}
+
+// readInt reads a little endian 32-bit integer
+func (j *JournalReader) readInt() (uint32, error) {
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(j.r, buf); err != nil {
+		return 0, ErrTruncated
+	}
+	return binary.LittleEndian.Uint32(buf), nil
+}
+
+// readBlock reads a block of data prefixed with its length
+func (j *JournalReader) readBlock() ([]byte, error) {
+	size, err := j.readInt()
+	if err != nil {
+		return nil, err
+	}
+	if size > maxBlockSize {
+		return nil, fmt.Errorf("kestrel: invalid journal block size %d", size)
+	}
+	data := make([]byte, size)
+	if _, err = io.ReadFull(j.r, data); err != nil {
+		return nil, ErrTruncated
+	}
+	return data, nil
+}
+
+// unpack decodes an item: add time and expiry in milliseconds
+// followed by item data
+func unpack(data []byte) (*Item, error) {
+	if len(data) < 16 {
+		return nil, fmt.Errorf("kestrel: invalid journal item size %d", len(data))
+	}
+	item := &Item{
+		AddTime: millis(binary.LittleEndian.Uint64(data[0:8])),
+		Data:    data[16:],
+	}
+	if expiry := binary.LittleEndian.Uint64(data[8:16]); expiry > 0 {
+		item.Expiry = millis(expiry)
+	}
+	return item, nil
+}
+
+// unpackOldAdd decodes an item of the old format:
+// expiry in seconds followed by item data
+func unpackOldAdd(data []byte) *Item {
+	item := &Item{AddTime: time.Now()}
+	if len(data) < 4 {
+		return item
+	}
+	item.Data = data[4:]
+	if expiry := binary.LittleEndian.Uint32(data[0:4]); expiry > 0 {
+		item.Expiry = time.Unix(int64(expiry), 0)
+	}
+	return item
+}
+
+func millis(ms uint64) time.Time {
+	return time.Unix(0, int64(ms)*int64(time.Millisecond))
+}
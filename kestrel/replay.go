@@ -0,0 +1,264 @@
+package kestrel
+
+import (
+	"container/list"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bogdanovich/siberite/queue"
+)
+
+// importBatchSize is the number of items enqueued at once by Import
+const importBatchSize = 1000
+
+// Queues returns names of queues that have journal files in the directory
+func Queues(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	found := map[string]bool{}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), "~~") {
+			continue
+		}
+		name, _, ok := splitJournalName(entry.Name())
+		if ok {
+			found[name] = true
+		}
+	}
+	names := []string{}
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// JournalFiles returns journal files of the queue in replay order.
+// Journal files are rotated to <queue>.<timestamp> files and packed into
+// a <queue>.<timestamp>.pack file, which replaces all files up to its
+// timestamp. The current journal <queue> is replayed last.
+// Unfinished <file>~~ files are skipped.
+func JournalFiles(dir, name string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type journalFile struct {
+		path      string
+		timestamp int64
+		pack      bool
+	}
+	files := []journalFile{}
+	packedUpTo := int64(-1)
+	current := ""
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), "~~") {
+			continue
+		}
+		queueName, suffix, ok := splitJournalName(entry.Name())
+		if !ok || queueName != name {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if suffix == "" {
+			current = path
+			continue
+		}
+		pack := strings.HasSuffix(suffix, ".pack")
+		timestamp, _ := strconv.ParseInt(strings.TrimSuffix(suffix, ".pack"), 10, 64)
+		if pack && timestamp > packedUpTo {
+			packedUpTo = timestamp
+		}
+		files = append(files, journalFile{path, timestamp, pack})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].timestamp == files[j].timestamp {
+			return files[i].pack
+		}
+		return files[i].timestamp < files[j].timestamp
+	})
+	paths := []string{}
+	for _, f := range files {
+		if f.timestamp < packedUpTo || (f.timestamp == packedUpTo && !f.pack) {
+			continue
+		}
+		paths = append(paths, f.path)
+	}
+	if current != "" {
+		paths = append(paths, current)
+	}
+	return paths, nil
+}
+
+// splitJournalName splits a journal file name into the queue name and
+// the suffix after it: "", "<timestamp>" or "<timestamp>.pack"
+func splitJournalName(file string) (string, string, bool) {
+	i := strings.Index(file, ".")
+	if i < 0 {
+		return file, "", true
+	}
+	if i == 0 {
+		return "", "", false
+	}
+	suffix := file[i+1:]
+	timestamp := strings.TrimSuffix(suffix, ".pack")
+	if _, err := strconv.ParseInt(timestamp, 10, 64); err != nil {
+		return "", "", false
+	}
+	return file[:i], suffix, true
+}
+
+// State is a queue reconstructed from its journal
+type State struct {
+	Name  string
+	items *list.List
+	// open are items of transactions not confirmed yet
+	open map[uint32]*Item
+	xid  uint32
+	// Truncated are journal files that end in the middle of a record,
+	// the incomplete record is skipped
+	Truncated []string
+}
+
+// Replay reads all journal files of the queue and reconstructs
+// its items. Items read behind by Kestrel are still in the journal,
+// so the whole queue is restored.
+func Replay(dir, name string) (*State, error) {
+	paths, err := JournalFiles(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	s := &State{Name: name, items: list.New(), open: map[uint32]*Item{}}
+	for _, path := range paths {
+		if err = s.replayFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *State) replayFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := NewJournalReader(file)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err == ErrTruncated {
+			s.Truncated = append(s.Truncated, path)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s.apply(record)
+	}
+}
+
+func (s *State) apply(r *Record) {
+	switch r.Op {
+	case OpAdd:
+		s.items.PushBack(r.Item)
+	case OpRemove:
+		s.remove()
+	case OpRemoveTentative:
+		item := s.remove()
+		if item == nil {
+			return
+		}
+		xid := r.XID
+		if xid == 0 {
+			// old journals don't store transaction IDs
+			s.xid++
+			xid = s.xid
+		}
+		s.open[xid] = item
+	case OpSaveXID:
+		s.xid = r.XID
+	case OpUnremove:
+		if item, ok := s.open[r.XID]; ok {
+			delete(s.open, r.XID)
+			s.items.PushFront(item)
+		}
+	case OpConfirmRemove:
+		delete(s.open, r.XID)
+	case OpContinue:
+		delete(s.open, r.XID)
+		s.items.PushBack(r.Item)
+	}
+}
+
+func (s *State) remove() *Item {
+	front := s.items.Front()
+	if front == nil {
+		return nil
+	}
+	return s.items.Remove(front).(*Item)
+}
+
+// Items returns live items in the order they are going to be served.
+// Kestrel returns open transactions to the queue on restart,
+// so they come first, in the order they were opened.
+func (s *State) Items() []*Item {
+	xids := []uint32{}
+	for xid := range s.open {
+		xids = append(xids, xid)
+	}
+	sort.Slice(xids, func(i, j int) bool { return xids[i] < xids[j] })
+
+	items := make([]*Item, 0, len(xids)+s.items.Len())
+	for _, xid := range xids {
+		items = append(items, s.open[xid])
+	}
+	for e := s.items.Front(); e != nil; e = e.Next() {
+		items = append(items, e.Value.(*Item))
+	}
+	return items
+}
+
+// Import appends live items to the queue in batches, items that expired
+// before now are skipped. Items get new IDs and enqueue times, so item
+// timestamps stay ordered. Returns the number of imported and expired items.
+func (s *State) Import(q *queue.Queue, now time.Time) (int, int, error) {
+	n, expired := 0, 0
+	batch := make([]*queue.Item, 0, importBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := q.EnqueueBatch(batch); err != nil {
+			return err
+		}
+		n += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	for _, item := range s.Items() {
+		if !item.Expiry.IsZero() && !item.Expiry.After(now) {
+			expired++
+			continue
+		}
+		batch = append(batch, &queue.Item{Value: item.Data})
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return n, expired, err
+			}
+		}
+	}
+	return n, expired, flush()
+}
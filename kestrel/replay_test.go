@@ -0,0 +1,185 @@
+package kestrel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bogdanovich/siberite/cgroup"
+)
+
+var dir = "./test_data"
+
+func TestMain(m *testing.M) {
+	os.RemoveAll(dir)
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		fmt.Println(err)
+	}
+	result := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(result)
+}
+
+// journal builds journal files the way Kestrel writes them
+type journal struct {
+	bytes.Buffer
+}
+
+func (j *journal) int(value uint32) *journal {
+	binary.Write(j, binary.LittleEndian, value)
+	return j
+}
+
+func (j *journal) add(value string, expiry time.Time) *journal {
+	var expiryMillis int64
+	if !expiry.IsZero() {
+		expiryMillis = expiry.UnixNano() / int64(time.Millisecond)
+	}
+	j.WriteByte(cmdAddX)
+	j.int(uint32(16 + len(value)))
+	binary.Write(j, binary.LittleEndian, time.Now().UnixNano()/int64(time.Millisecond))
+	binary.Write(j, binary.LittleEndian, expiryMillis)
+	j.WriteString(value)
+	return j
+}
+
+func (j *journal) op(opcode byte, xid ...uint32) *journal {
+	j.WriteByte(opcode)
+	for _, x := range xid {
+		j.int(x)
+	}
+	return j
+}
+
+func (j *journal) save(t *testing.T, name string) {
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), j.Bytes(), 0644))
+}
+
+func Test_JournalReader(t *testing.T) {
+	j := &journal{}
+	j.add("1", time.Unix(1500000000, 0))
+	j.WriteByte(cmdAdd)
+	j.int(5).int(0).WriteString("2")
+	j.op(cmdRemoveTentativeXID, 7)
+	j.op(cmdAddXID, 7).int(17).Write(make([]byte, 16))
+	j.WriteString("3")
+
+	r := NewJournalReader(bytes.NewReader(j.Bytes()))
+	record, err := r.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, OpAdd, record.Op)
+	assert.Equal(t, "1", string(record.Item.Data))
+	assert.EqualValues(t, 1500000000, record.Item.Expiry.Unix())
+
+	record, err = r.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "2", string(record.Item.Data))
+	assert.True(t, record.Item.Expiry.IsZero())
+
+	record, err = r.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, OpRemoveTentative, record.Op)
+	assert.EqualValues(t, 7, record.XID)
+
+	record, err = r.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, OpContinue, record.Op)
+	assert.EqualValues(t, 7, record.XID)
+	assert.Equal(t, "3", string(record.Item.Data))
+
+	_, err = r.Read()
+	assert.Equal(t, io.EOF, err)
+
+	r = NewJournalReader(bytes.NewReader(j.Bytes()[:j.Len()-1]))
+	r.Read()
+	r.Read()
+	r.Read()
+	_, err = r.Read()
+	assert.Equal(t, ErrTruncated, err)
+
+	_, err = NewJournalReader(bytes.NewReader([]byte{42})).Read()
+	assert.Error(t, err)
+}
+
+func Test_JournalFiles(t *testing.T) {
+	for _, name := range []string{"work", "work.100", "work.200", "work.200.pack",
+		"work.300", "work.400~~", "other.50", "other.50.pack~~"} {
+		(&journal{}).save(t, name)
+	}
+	paths, err := JournalFiles(dir, "work")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "work.200.pack"),
+		filepath.Join(dir, "work.300"),
+		filepath.Join(dir, "work"),
+	}, paths)
+
+	names, err := Queues(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"other", "work"}, names)
+
+	for _, name := range []string{"work", "work.100", "work.200", "work.200.pack",
+		"work.300", "work.400~~", "other.50", "other.50.pack~~"} {
+		os.Remove(filepath.Join(dir, name))
+	}
+}
+
+func Test_Replay(t *testing.T) {
+	expired := time.Now().Add(-time.Hour)
+	(&journal{}).
+		add("1", time.Time{}).add("2", time.Time{}).add("3", expired).
+		add("4", time.Time{}).add("5", time.Time{}).add("6", time.Time{}).
+		op(cmdRemove).                // 1 removed
+		op(cmdRemoveTentativeXID, 1). // 2 is open
+		op(cmdRemoveTentativeXID, 2). // 3 is open
+		op(cmdConfirmRemove, 2).      // 3 removed
+		save(t, "replay.100.pack")
+	j := &journal{}
+	j.op(cmdSaveXID, 10).
+		op(cmdRemoveTentative).   // 4 is open as 11
+		op(cmdRemoveTentative).   // 5 is open as 12
+		op(cmdUnremove, 12).      // 5 is back
+		op(cmdAddXID, 11).int(17) // 4 is packed again
+	j.Write(make([]byte, 16))
+	j.WriteString("4")
+	j.Write([]byte{cmdAddX, 1, 0}) // crashed while writing
+	j.save(t, "replay")
+
+	s, err := Replay(dir, "replay")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "replay")}, s.Truncated)
+	values := []string{}
+	for _, item := range s.Items() {
+		values = append(values, string(item.Data))
+	}
+	assert.Equal(t, []string{"2", "5", "6", "4"}, values)
+
+	q, err := cgroup.CGQueueOpen("imported", dir)
+	assert.NoError(t, err)
+	defer q.Drop()
+	q.Enqueue([]byte("0"))
+	n, skipped, err := s.Import(q.Queue, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, 0, skipped)
+	for _, expected := range []string{"0", "2", "5", "6", "4"} {
+		value, err := q.GetNext()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(value))
+	}
+
+	s.items.PushBack(&Item{Data: []byte("7"), Expiry: expired})
+	n, skipped, err = s.Import(q.Queue, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, 1, skipped)
+}
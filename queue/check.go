@@ -0,0 +1,116 @@
+package queue
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// CheckReport describes problems found by Check
+type CheckReport struct {
+	// Gaps are IDs between head and tail that have
+	// neither an item nor a tombstone
+	Gaps []uint64
+	// Unreadable are IDs of items with invalid metadata
+	Unreadable []uint64
+	// Orphaned are IDs of metadata records without an item
+	// and tombstones outside of head and tail
+	Orphaned []uint64
+	// Holes is the number of deleted items recorded in the queue state,
+	// Tombstones is the number of deleted items found
+	Holes      uint64
+	Tombstones uint64
+}
+
+// OK returns true if no problems were found
+func (r *CheckReport) OK() bool {
+	return len(r.Gaps) == 0 && len(r.Unreadable) == 0 &&
+		len(r.Orphaned) == 0 && r.Holes == r.Tombstones
+}
+
+// Check scans all queue records and reports gaps in the ID sequence,
+// unreadable items and orphaned records. If repair is true, gaps
+// are marked as deleted items, invalid metadata and orphaned records
+// are removed and the number of holes is corrected.
+func (q *Queue) Check(repair bool) (*CheckReport, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	report := &CheckReport{Holes: q.holes}
+	batch := new(leveldb.Batch)
+	keyLen := len(q.opts.KeyPrefix) + 8
+	next, lastItem := q.head+1, uint64(0)
+
+	addGaps := func(end uint64) {
+		for id := next; id < end; id++ {
+			report.Gaps = append(report.Gaps, id)
+			batch.Put(q.tombstoneKey(q.dbKey(id)), nil)
+		}
+	}
+
+	iter := q.db.NewIterator(util.BytesPrefix(q.opts.KeyPrefix), nil)
+	defer iter.Release()
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) < keyLen || len(key) > keyLen+1 {
+			continue
+		}
+		id := q.dbKeyToID(key)
+		inRange := id > q.head && id <= q.tail
+		switch {
+		case id == 0:
+			continue
+		case len(key) == keyLen:
+			lastItem = id
+			if inRange && id >= next {
+				addGaps(id)
+				next = id + 1
+			}
+		case key[keyLen] == tombstoneKeySuffix:
+			if !inRange {
+				report.Orphaned = append(report.Orphaned, id)
+				batch.Delete(append([]byte{}, key...))
+				continue
+			}
+			report.Tombstones++
+			if id >= next {
+				addGaps(id)
+				next = id + 1
+			}
+		case key[keyLen] == metaKeySuffix:
+			if lastItem != id {
+				report.Orphaned = append(report.Orphaned, id)
+				batch.Delete(append([]byte{}, key...))
+				continue
+			}
+			if err := (&Item{}).decodeMeta(iter.Value()); err != nil {
+				report.Unreadable = append(report.Unreadable, id)
+				batch.Delete(append([]byte{}, key...))
+			}
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return report, err
+	}
+	addGaps(q.tail + 1)
+
+	holes := report.Tombstones + uint64(len(report.Gaps))
+	if !repair || (batch.Len() == 0 && holes == q.holes) {
+		return report, nil
+	}
+	batch.Put(q.dbKey(0), q.encodeState(holes))
+	err := q.db.Write(batch, nil)
+	if err == nil {
+		q.holes = holes
+	}
+	return report, err
+}
+
+// Compact compacts underlying leveldb database
+func (q *Queue) Compact() error {
+	q.Lock()
+	defer q.Unlock()
+	if len(q.opts.KeyPrefix) == 0 {
+		return q.db.CompactRange(util.Range{})
+	}
+	return q.db.CompactRange(*util.BytesPrefix(q.opts.KeyPrefix))
+}
@@ -0,0 +1,63 @@
+package queue
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Check(t *testing.T) {
+	q, _ := Open(name, dir, &options)
+	testCheck(t, q)
+	q.Drop()
+
+	q, _ = Open(name, dir, &optionsWithKeyPrefix)
+	testCheck(t, q)
+	q.Drop()
+}
+
+func testCheck(t *testing.T, q *Queue) {
+	for i := 1; i <= 6; i++ {
+		q.Enqueue([]byte(strconv.Itoa(i)))
+	}
+	report, err := q.Check(false)
+	assert.NoError(t, err)
+	assert.True(t, report.OK())
+
+	assert.NoError(t, q.DeleteItemByID(3))
+	// invalid metadata
+	assert.NoError(t, q.db.Put(q.metaKey(q.dbKey(2)), []byte{9, 5}, nil))
+	// lost item with orphaned metadata
+	assert.NoError(t, q.db.Delete(q.dbKey(4), nil))
+	assert.NoError(t, q.db.Put(q.metaKey(q.dbKey(4)), []byte{}, nil))
+	// lost item without any records
+	assert.NoError(t, q.db.Delete(q.dbKey(5), nil))
+
+	report, err = q.Check(false)
+	assert.NoError(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, []uint64{4, 5}, report.Gaps)
+	assert.Equal(t, []uint64{2}, report.Unreadable)
+	assert.Equal(t, []uint64{4}, report.Orphaned)
+	assert.EqualValues(t, 1, report.Holes)
+	assert.EqualValues(t, 1, report.Tombstones)
+	assert.EqualValues(t, 5, q.Length())
+
+	report, err = q.Check(true)
+	assert.NoError(t, err)
+	assert.False(t, report.OK())
+	assert.EqualValues(t, 3, q.Length())
+
+	report, err = q.Check(false)
+	assert.NoError(t, err)
+	assert.True(t, report.OK())
+	assert.EqualValues(t, 3, report.Holes)
+
+	for _, expected := range []string{"1", "2", "6"} {
+		value, err := q.GetNext()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(value))
+	}
+	assert.True(t, q.IsEmpty())
+}
@@ -0,0 +1,89 @@
+package queue
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// ErrInvalidItemMeta is returned when item metadata can't be decoded
+var ErrInvalidItemMeta = errors.New("queue: invalid item metadata")
+
+// metaKeySuffix is appended to an item key to build a key
+// of the item metadata record, so metadata is stored right next to
+// the item and shares its key prefix
+const metaKeySuffix = 'm'
+
+// tombstoneKeySuffix marks an item deleted by ID, so the queue
+// can recognize holes between its head and tail
+const tombstoneKeySuffix = 'd'
+
+// item metadata field tags
+const (
+	metaGroup byte = iota + 1
+	metaTimestamp
+	metaOffset
+)
+
+// hasMeta returns true if item carries any metadata
+func (item *Item) hasMeta() bool {
+	return item.Group != "" || !item.Timestamp.IsZero() || item.Offset > 0
+}
+
+// encodeMeta serializes item metadata as a sequence
+// of <tag><uvarint length><data> fields
+func (item *Item) encodeMeta() []byte {
+	data := make([]byte, 0, 16)
+	if item.Group != "" {
+		data = appendMetaField(data, metaGroup, []byte(item.Group))
+	}
+	if !item.Timestamp.IsZero() {
+		data = appendMetaUint(data, metaTimestamp, uint64(item.Timestamp.UnixNano()))
+	}
+	if item.Offset > 0 {
+		data = appendMetaUint(data, metaOffset, item.Offset)
+	}
+	return data
+}
+
+// decodeMeta parses item metadata, unknown fields are skipped
+func (item *Item) decodeMeta(data []byte) error {
+	for len(data) > 0 {
+		tag := data[0]
+		length, n := binary.Uvarint(data[1:])
+		if n <= 0 || uint64(len(data)-1-n) < length {
+			return ErrInvalidItemMeta
+		}
+		value := data[1+n : 1+n+int(length)]
+		switch tag {
+		case metaGroup:
+			item.Group = string(value)
+		case metaTimestamp:
+			ts, n := binary.Uvarint(value)
+			if n <= 0 {
+				return ErrInvalidItemMeta
+			}
+			item.Timestamp = time.Unix(0, int64(ts))
+		case metaOffset:
+			offset, n := binary.Uvarint(value)
+			if n <= 0 {
+				return ErrInvalidItemMeta
+			}
+			item.Offset = offset
+		}
+		data = data[1+n+int(length):]
+	}
+	return nil
+}
+
+func appendMetaField(data []byte, tag byte, value []byte) []byte {
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	data = append(data, tag)
+	data = append(data, lenBuf[:binary.PutUvarint(lenBuf, uint64(len(value)))]...)
+	return append(data, value...)
+}
+
+func appendMetaUint(data []byte, tag byte, value uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return appendMetaField(data, tag, buf[:binary.PutUvarint(buf, value)])
+}
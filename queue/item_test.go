@@ -0,0 +1,111 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ItemMeta(t *testing.T) {
+	item := &Item{Group: "account-1"}
+	assert.True(t, item.hasMeta())
+
+	decoded := &Item{}
+	assert.NoError(t, decoded.decodeMeta(item.encodeMeta()))
+	assert.Equal(t, "account-1", decoded.Group)
+
+	// unknown fields are skipped
+	data := appendMetaField(nil, 100, []byte("unknown"))
+	data = append(data, item.encodeMeta()...)
+	decoded = &Item{}
+	assert.NoError(t, decoded.decodeMeta(data))
+	assert.Equal(t, "account-1", decoded.Group)
+
+	assert.Equal(t, ErrInvalidItemMeta, decoded.decodeMeta([]byte{metaGroup, 10, 'a'}))
+	assert.False(t, (&Item{}).hasMeta())
+
+	now := time.Now()
+	item = &Item{Timestamp: now, Offset: 42}
+	assert.True(t, item.hasMeta())
+	decoded = &Item{}
+	assert.NoError(t, decoded.decodeMeta(item.encodeMeta()))
+	assert.Equal(t, now.UnixNano(), decoded.Timestamp.UnixNano())
+	assert.EqualValues(t, 42, decoded.Offset)
+}
+
+func Test_EnqueueItem(t *testing.T) {
+	q, _ := Open(name, dir, &options)
+	testEnqueueItem(t, q)
+	q.Drop()
+
+	q, _ = Open(name, dir, &optionsWithKeyPrefix)
+	testEnqueueItem(t, q)
+	q.Drop()
+
+	withSharedQueues(t, func(q *Queue) {
+		testEnqueueItem(t, q)
+	})
+}
+
+func testEnqueueItem(t *testing.T, q *Queue) {
+	item := &Item{Value: []byte("1"), Group: "g1"}
+	assert.NoError(t, q.EnqueueItem(item))
+	assert.EqualValues(t, 1, item.ID)
+	assert.NoError(t, q.Enqueue([]byte("2")))
+	assert.NoError(t, q.EnqueueItem(&Item{Value: []byte("3"), Group: "g2"}))
+	assert.EqualValues(t, 3, q.Tail())
+	assert.EqualValues(t, 3, q.Length())
+
+	// metadata records do not affect head and tail
+	assert.NoError(t, q.initialize())
+	assert.EqualValues(t, 0, q.Head())
+	assert.EqualValues(t, 3, q.Tail())
+
+	item, err := q.PeekItem()
+	assert.NoError(t, err)
+	assert.Equal(t, "g1", item.Group)
+
+	item, err = q.GetNextItem()
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(item.Value))
+	assert.Equal(t, "g1", item.Group)
+
+	item, err = q.GetNextItem()
+	assert.NoError(t, err)
+	assert.Equal(t, "2", string(item.Value))
+	assert.Equal(t, "", item.Group)
+
+	item, err = q.GetNextItem()
+	assert.NoError(t, err)
+	assert.Equal(t, "g2", item.Group)
+
+	// all records are removed
+	assert.NoError(t, q.initialize())
+	assert.EqualValues(t, 0, q.Length())
+}
+
+func Test_EnqueueItemTimestamps(t *testing.T) {
+	q, err := Open(name, dir, &Options{Timestamps: true})
+	assert.NoError(t, err)
+	defer q.Drop()
+
+	before := time.Now()
+	for _, value := range []string{"1", "22", "333"} {
+		assert.NoError(t, q.Enqueue([]byte(value)))
+	}
+
+	item, err := q.ReadItemByID(2)
+	assert.NoError(t, err)
+	assert.False(t, item.Timestamp.Before(before))
+	assert.EqualValues(t, 3, item.Offset)
+
+	// offset is restored on open
+	q.Close()
+	q, err = Open(name, dir, &Options{Timestamps: true})
+	assert.NoError(t, err)
+	assert.NoError(t, q.Enqueue([]byte("4444")))
+	item, err = q.ReadItemByID(4)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 10, item.Offset)
+}
@@ -0,0 +1,76 @@
+package queue
+
+import (
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// moveLock serializes moves, so two moves in opposite
+// directions never wait for each other's queue locks
+var moveLock sync.Mutex
+
+// Move transfers up to n items from the head of the queue to the tail
+// of dst. Items are written to dst with a synced write and only then
+// deleted from the queue, so a crash may duplicate, but never lose items.
+// Item values and message groups are kept, items get new IDs and
+// enqueue times. Returns the number of moved items.
+func (q *Queue) Move(dst *Queue, n int) (int, error) {
+	if q == dst {
+		return 0, ErrSameQueue
+	}
+	moveLock.Lock()
+	defer moveLock.Unlock()
+	q.Lock()
+	defer q.Unlock()
+	dst.Lock()
+	defer dst.Unlock()
+
+	items := []*Item{}
+	for id := q.head; len(items) < n; {
+		item, err := q.readItemAfter(id)
+		if err == ErrIsEmpty || err == ErrIDOutOfBounds {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		items = append(items, item)
+		id = item.ID
+	}
+	if len(items) == 0 {
+		return 0, nil
+	}
+
+	moved := make([]*Item, len(items))
+	for i, item := range items {
+		moved[i] = &Item{Value: item.Value, Group: item.Group}
+	}
+	if err := dst.enqueue(new(leveldb.Batch), moved, &opt.WriteOptions{Sync: true}); err != nil {
+		return 0, err
+	}
+	return len(items), q.deleteItems(items)
+}
+
+// deleteItems removes items read from the head in order,
+// tombstones of deleted items between them are removed too
+func (q *Queue) deleteItems(items []*Item) error {
+	batch := new(leveldb.Batch)
+	next, holes := q.head+1, q.holes
+	for _, item := range items {
+		for ; next < item.ID; next++ {
+			batch.Delete(q.tombstoneKey(q.dbKey(next)))
+			holes--
+		}
+		batch.Delete(item.Key)
+		batch.Delete(q.metaKey(item.Key))
+		next++
+	}
+	batch.Put(q.dbKey(0), q.encodeState(holes))
+	err := q.db.Write(batch, nil)
+	if err == nil {
+		q.head, q.holes = items[len(items)-1].ID, holes
+	}
+	return err
+}
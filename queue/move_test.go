@@ -0,0 +1,62 @@
+package queue
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Move(t *testing.T) {
+	q, _ := Open(name, dir, &options)
+	dst, _ := Open("move_dst", dir, &Options{Timestamps: true})
+	defer dst.Drop()
+
+	for i := 1; i <= 6; i++ {
+		q.EnqueueItem(&Item{Value: []byte(strconv.Itoa(i)), Group: "g" + strconv.Itoa(i%2)})
+	}
+	assert.NoError(t, q.DeleteItemByID(2))
+	assert.NoError(t, q.DeleteItemByID(4))
+	dst.Enqueue([]byte("0"))
+
+	n, err := q.Move(dst, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.EqualValues(t, 2, q.Length())
+	assert.EqualValues(t, 1, q.Holes())
+	assert.EqualValues(t, 3, dst.Length())
+
+	item, err := dst.ReadItemByID(3)
+	assert.NoError(t, err)
+	assert.Equal(t, "3", string(item.Value))
+	assert.Equal(t, "g1", item.Group)
+	assert.False(t, item.Timestamp.IsZero())
+
+	n, err = q.Move(dst, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.True(t, q.IsEmpty())
+	assert.EqualValues(t, 0, q.Holes())
+
+	n, err = q.Move(dst, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	for _, expected := range []string{"0", "1", "3", "5", "6"} {
+		value, err := dst.GetNext()
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(value))
+	}
+
+	_, err = q.Move(q, 1)
+	assert.Equal(t, ErrSameQueue, err)
+
+	// moved items are not served again after restart
+	q.Enqueue([]byte("7"))
+	q.Close()
+	q, _ = Open(name, dir, &options)
+	assert.EqualValues(t, 1, q.Length())
+	value, _ := q.GetNext()
+	assert.Equal(t, "7", string(value))
+	q.Drop()
+}
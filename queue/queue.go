@@ -6,8 +6,10 @@ import (
 	"os"
 	"regexp"
 	"sync"
+	"time"
 
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/util"
 )
@@ -31,6 +33,12 @@ var (
 
 	// ErrSharedFlush means that there was an attempt to flush shared queue
 	ErrSharedFlush = errors.New("queue: can't flush shared queue")
+
+	// ErrItemNotFound is returned when an item with requested ID was deleted
+	ErrItemNotFound = errors.New("queue: item not found")
+
+	// ErrSameQueue is returned on an attempt to move items to the same queue
+	ErrSameQueue = errors.New("queue: source and destination are the same queue")
 )
 
 const levelDBOpenFilesCacheCapacity = 64
@@ -40,6 +48,7 @@ var validQueueNameRegex = regexp.MustCompile(`[^a-zA-Z0-9_\-\:]+`)
 // Consumer represents a queue consumer
 type Consumer interface {
 	GetNext() ([]byte, error)
+	GetNextItem() (*Item, error)
 	PutBack([]byte) error
 	Peek() ([]byte, error)
 	Flush() error
@@ -62,6 +71,8 @@ type Queue struct {
 	opts     *Options
 	head     uint64
 	tail     uint64
+	holes    uint64
+	offset   uint64
 	isOpened bool
 	isShared bool
 }
@@ -69,6 +80,9 @@ type Queue struct {
 // Options represents queue options
 type Options struct {
 	KeyPrefix []byte
+	// Timestamps enables storing enqueue time and
+	// stream offset of every item
+	Timestamps bool
 }
 
 // Item represents a queue item
@@ -76,6 +90,12 @@ type Item struct {
 	ID    uint64
 	Key   []byte
 	Value []byte
+	Group string
+	// Timestamp is the time item was enqueued at
+	Timestamp time.Time
+	// Offset is the total size of all values enqueued
+	// up to and including the item
+	Offset uint64
 }
 
 // Open creates a queue and opens underlying leveldb database
@@ -145,6 +165,9 @@ func (q *Queue) Head() uint64 { return q.head }
 // Tail returns current tail offset of the queue
 func (q *Queue) Tail() uint64 { return q.tail }
 
+// Holes returns number of items deleted by ID between head and tail
+func (q *Queue) Holes() uint64 { return q.holes }
+
 // Length returns current length of the queue
 func (q *Queue) Length() uint64 {
 	q.RLock()
@@ -159,31 +182,165 @@ func (q *Queue) IsEmpty() bool {
 
 // Enqueue adds new value to the queue
 func (q *Queue) Enqueue(value []byte) error {
+	return q.EnqueueItem(&Item{Value: value})
+}
+
+// EnqueueItem adds new item with its metadata to the queue
+// and assigns item ID
+func (q *Queue) EnqueueItem(item *Item) error {
+	return q.EnqueueBatch([]*Item{item})
+}
+
+// EnqueueBatch adds items to the queue with a single write
+// and assigns item IDs
+func (q *Queue) EnqueueBatch(items []*Item) error {
 	q.Lock()
 	defer q.Unlock()
+	return q.enqueue(new(leveldb.Batch), items, nil)
+}
 
-	err := q.db.Put(q.dbKey(q.tail+1), value, nil)
-	if err == nil {
-		q.tail++
+// SetTimestamps enables or disables storing enqueue time and
+// stream offset of new items
+func (q *Queue) SetTimestamps(enabled bool) error {
+	q.Lock()
+	defer q.Unlock()
+	if enabled && !q.opts.Timestamps {
+		iter := q.db.NewIterator(util.BytesPrefix(q.opts.KeyPrefix), nil)
+		defer iter.Release()
+		if err := q.loadOffset(iter); err != nil {
+			return err
+		}
 	}
-	return err
+	q.opts.Timestamps = enabled
+	return nil
+}
+
+// EnqueueSynced adds an item to the queue with a synced write
+func (q *Queue) EnqueueSynced(item *Item) error {
+	q.Lock()
+	defer q.Unlock()
+	return q.enqueue(new(leveldb.Batch), []*Item{item}, &opt.WriteOptions{Sync: true})
+}
+
+// enqueue writes items together with records already added to the batch
+func (q *Queue) enqueue(batch *leveldb.Batch, items []*Item, wo *opt.WriteOptions) error {
+	tail, offset := q.tail, q.offset
+	for _, item := range items {
+		tail++
+		key := q.dbKey(tail)
+		if q.opts.Timestamps {
+			if item.Timestamp.IsZero() {
+				item.Timestamp = time.Now()
+			}
+			offset += uint64(len(item.Value))
+			item.Offset = offset
+		}
+		batch.Put(key, item.Value)
+		if item.hasMeta() {
+			batch.Put(q.metaKey(key), item.encodeMeta())
+		}
+		item.ID = tail
+		item.Key = key
+	}
+	if err := q.db.Write(batch, wo); err != nil {
+		return err
+	}
+	q.tail, q.offset = tail, offset
+	return nil
 }
 
 // GetNext returns next value from queue
 func (q *Queue) GetNext() ([]byte, error) {
+	item, err := q.GetNextItem()
+	return item.Value, err
+}
+
+// GetNextItem returns next item from queue
+func (q *Queue) GetNextItem() (*Item, error) {
 	q.Lock()
 	defer q.Unlock()
 
-	item, err := q.readItemByID(q.head + 1)
+	item, err := q.headItem()
 	if err != nil {
-		return item.Value, err
+		return item, err
 	}
+	return item, q.deleteHead(item)
+}
 
-	err = q.db.Delete(item.Key, nil)
-	if err == nil {
-		q.head++
+// GetNextItemFunc returns next item from queue, fn is called with
+// the item before it's removed, an error of fn keeps the item
+func (q *Queue) GetNextItemFunc(fn func(*Item) error) (*Item, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	item, err := q.headItem()
+	if err != nil {
+		return item, err
 	}
-	return item.Value, err
+	if err = fn(item); err != nil {
+		return nil, err
+	}
+	return item, q.deleteHead(item)
+}
+
+// Trim removes items from the queue head while expired returns true
+// for the head item, but not more than limit items.
+// Expired is called with current queue length and
+// size in bytes (available only if Timestamps option is enabled).
+func (q *Queue) Trim(expired func(item *Item, length, size uint64) bool,
+	limit int) (int, error) {
+
+	q.Lock()
+	defer q.Unlock()
+
+	for n := 0; n < limit; n++ {
+		item, err := q.headItem()
+		if err == ErrIsEmpty {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if !expired(item, q.length(), q.size(item)) {
+			return n, nil
+		}
+		if err = q.deleteHead(item); err != nil {
+			return n, err
+		}
+	}
+	return limit, nil
+}
+
+// DeleteItemByID deletes an item with provided id from the queue.
+// An item in the middle of the queue leaves a hole that
+// is skipped by following reads.
+func (q *Queue) DeleteItemByID(id uint64) error {
+	q.Lock()
+	defer q.Unlock()
+
+	item, err := q.readItemByID(id)
+	if err != nil {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	batch.Delete(item.Key)
+	batch.Delete(q.metaKey(item.Key))
+	if id == q.head+1 {
+		if id == q.tail {
+			batch.Put(q.dbKey(0), q.encodeState(q.holes))
+		}
+		if err = q.db.Write(batch, nil); err == nil {
+			q.head++
+		}
+		return err
+	}
+	batch.Put(q.tombstoneKey(item.Key), nil)
+	batch.Put(q.dbKey(0), q.encodeState(q.holes+1))
+	if err = q.db.Write(batch, nil); err == nil {
+		q.holes++
+	}
+	return err
 }
 
 // PutBack returns value to the queue
@@ -202,10 +359,15 @@ func (q *Queue) PutBack(value []byte) error {
 
 // Peek returns next value without removing it from the queue
 func (q *Queue) Peek() ([]byte, error) {
+	item, err := q.PeekItem()
+	return item.Value, err
+}
+
+// PeekItem returns next item without removing it from the queue
+func (q *Queue) PeekItem() (*Item, error) {
 	q.RLock()
 	defer q.RUnlock()
-	item, err := q.readItemByID(q.head + 1)
-	return item.Value, err
+	return q.readItemByID(q.head + 1)
 }
 
 // ReadItemByID returns a value by it's id
@@ -223,10 +385,90 @@ func (q *Queue) readItemByID(id uint64) (*Item, error) {
 		return &Item{}, ErrIDOutOfBounds
 	}
 
+	return q.getItem(q.db, id)
+}
+
+// getItem reads an item and its meta from the database or its snapshot
+func (q *Queue) getItem(db reader, id uint64) (*Item, error) {
 	var err error
 	item := &Item{ID: id, Key: q.dbKey(id)}
-	item.Value, err = q.db.Get(item.Key, nil)
-	return item, err
+	item.Value, err = db.Get(item.Key, nil)
+	if err == leveldb.ErrNotFound {
+		return item, ErrItemNotFound
+	}
+	if err != nil {
+		return item, err
+	}
+	meta, err := db.Get(q.metaKey(item.Key), nil)
+	if err == leveldb.ErrNotFound {
+		return item, nil
+	}
+	if err != nil {
+		return item, err
+	}
+	return item, item.decodeMeta(meta)
+}
+
+// ReadItemAfter returns the first existing item with ID greater than id
+func (q *Queue) ReadItemAfter(id uint64) (*Item, error) {
+	q.RLock()
+	defer q.RUnlock()
+	return q.readItemAfter(id)
+}
+
+func (q *Queue) readItemAfter(id uint64) (*Item, error) {
+	if id < q.head {
+		id = q.head
+	}
+	for {
+		id++
+		item, err := q.readItemByID(id)
+		if err != ErrItemNotFound {
+			return item, err
+		}
+	}
+}
+
+// BytesAfter returns total size of items with ID greater than id.
+// It's available only if Timestamps option is enabled.
+func (q *Queue) BytesAfter(id uint64) uint64 {
+	q.RLock()
+	defer q.RUnlock()
+	item, err := q.readItemAfter(id)
+	if err != nil {
+		return 0
+	}
+	return q.size(item)
+}
+
+// SearchTime returns the lowest ID, such that all items before it
+// were enqueued before t (tail + 1 if there are no later items).
+// Items without timestamp are considered to be older than any time.
+func (q *Queue) SearchTime(t time.Time) (uint64, error) {
+	q.RLock()
+	defer q.RUnlock()
+
+	lo, hi := q.head+1, q.tail+1
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		item, err := q.readItemAfter(mid - 1)
+		if err == ErrIDOutOfBounds || err == ErrIsEmpty {
+			hi = mid
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case item.ID >= hi:
+			hi = mid
+		case item.Timestamp.Before(t):
+			lo = item.ID + 1
+		default:
+			hi = item.ID
+		}
+	}
+	return lo, nil
 }
 
 // ReadItemByOffset returns an item by offset from the queue head, starting from 0.
@@ -259,6 +501,27 @@ func (q *Queue) DeleteAll() error {
 	return q.initialize()
 }
 
+// Snapshot returns snapshots of the queue database followed by snapshots
+// of provided databases. Snapshots are taken while the queue is locked,
+// so all of them reflect the same state of the queue.
+func (q *Queue) Snapshot(dbs ...*leveldb.DB) ([]*leveldb.Snapshot, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	snapshots := []*leveldb.Snapshot{}
+	for _, db := range append([]*leveldb.DB{q.db}, dbs...) {
+		snapshot, err := db.GetSnapshot()
+		if err != nil {
+			for _, s := range snapshots {
+				s.Release()
+			}
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
+
 // Stats returns stats struct
 func (q *Queue) Stats() *Stats {
 	return q.stats
@@ -269,14 +532,21 @@ func (q *Queue) Path() string {
 	return q.DataDir + "/" + q.Name
 }
 
-func (q *Queue) open() error {
-	if validQueueNameRegex.MatchString(q.Name) || len(q.Name) < 1 {
+// ValidateName returns an error if the queue name is not valid
+func ValidateName(name string) error {
+	if validQueueNameRegex.MatchString(name) || len(name) < 1 {
 		return ErrInvalidName
 	}
-
-	if len(q.Name) > 100 {
+	if len(name) > 100 {
 		return ErrNameTooLong
 	}
+	return nil
+}
+
+func (q *Queue) open() error {
+	if err := ValidateName(q.Name); err != nil {
+		return err
+	}
 
 	if !q.isShared {
 		var err error
@@ -304,29 +574,159 @@ func (q *Queue) dbKey(id uint64) []byte {
 	return key
 }
 
+func (q *Queue) metaKey(key []byte) []byte {
+	metaKey := make([]byte, len(key)+1)
+	copy(metaKey, key)
+	metaKey[len(key)] = metaKeySuffix
+	return metaKey
+}
+
 func (q *Queue) dbKeyToID(key []byte) uint64 {
 	return binary.BigEndian.Uint64(key[len(q.opts.KeyPrefix):])
 }
 
+func (q *Queue) tombstoneKey(key []byte) []byte {
+	tombstoneKey := make([]byte, len(key)+1)
+	copy(tombstoneKey, key)
+	tombstoneKey[len(key)] = tombstoneKeySuffix
+	return tombstoneKey
+}
+
 func (q *Queue) length() uint64 {
-	return q.tail - q.head
+	return q.tail - q.head - q.holes
+}
+
+// size returns total size of values starting from the head item
+func (q *Queue) size(head *Item) uint64 {
+	start := uint64(0)
+	if head.Offset >= uint64(len(head.Value)) {
+		start = head.Offset - uint64(len(head.Value))
+	}
+	if start > q.offset {
+		return 0
+	}
+	return q.offset - start
+}
+
+// headItem reads the head item skipping deleted items
+func (q *Queue) headItem() (*Item, error) {
+	item, err := q.readItemByID(q.head + 1)
+	if err == ErrItemNotFound {
+		if err = q.skipHoles(); err != nil {
+			return item, err
+		}
+		item, err = q.readItemByID(q.head + 1)
+	}
+	return item, err
+}
+
+// deleteHead removes the head item
+func (q *Queue) deleteHead(item *Item) error {
+	batch := new(leveldb.Batch)
+	batch.Delete(item.Key)
+	batch.Delete(q.metaKey(item.Key))
+	if item.ID == q.tail {
+		// keep the tail, so IDs are not reused after restart
+		batch.Put(q.dbKey(0), q.encodeState(q.holes))
+	}
+	err := q.db.Write(batch, nil)
+	if err == nil {
+		q.head++
+	}
+	return err
+}
+
+// skipHoles moves queue head over deleted items
+func (q *Queue) skipHoles() error {
+	head, holes := q.head, q.holes
+	batch := new(leveldb.Batch)
+	for holes > 0 && head < q.tail {
+		key := q.tombstoneKey(q.dbKey(head + 1))
+		found, err := q.db.Has(key, nil)
+		if err != nil {
+			return err
+		}
+		if !found {
+			break
+		}
+		batch.Delete(key)
+		head++
+		holes--
+	}
+	if batch.Len() == 0 {
+		return nil
+	}
+	batch.Put(q.dbKey(0), q.encodeState(holes))
+	err := q.db.Write(batch, nil)
+	if err == nil {
+		q.head, q.holes = head, holes
+	}
+	return err
+}
+
+// encodeState serializes queue state record (number of holes and
+// the tail), which is stored under reserved item ID 0
+func (q *Queue) encodeState(holes uint64) []byte {
+	value := make([]byte, 16)
+	binary.BigEndian.PutUint64(value[:8], holes)
+	binary.BigEndian.PutUint64(value[8:], q.tail)
+	return value
 }
 
 func (q *Queue) initialize() error {
 	iter := q.db.NewIterator(util.BytesPrefix(q.opts.KeyPrefix), nil)
 	defer iter.Release()
 
-	if iter.First() {
+	var stateTail uint64
+	q.holes = 0
+	ok := iter.First()
+	// the state record is followed by transaction markers
+	for ok && q.dbKeyToID(iter.Key()) == 0 {
+		if state := iter.Value(); len(iter.Key()) == len(q.opts.KeyPrefix)+8 && len(state) == 16 {
+			q.holes = binary.BigEndian.Uint64(state[:8])
+			stateTail = binary.BigEndian.Uint64(state[8:])
+		}
+		ok = iter.Next()
+	}
+
+	if ok {
 		q.head = q.dbKeyToID(iter.Key()) - 1
 	} else {
-		q.head = 0
+		q.head = stateTail
 	}
 
-	if iter.Last() {
+	q.tail = stateTail
+	if iter.Last() && q.dbKeyToID(iter.Key()) > q.tail {
 		q.tail = q.dbKeyToID(iter.Key())
-	} else {
-		q.tail = 0
 	}
 
+	q.offset = 0
+	if q.opts.Timestamps {
+		if err := q.loadOffset(iter); err != nil {
+			return err
+		}
+	}
 	return iter.Error()
 }
+
+// loadOffset restores stream offset from the metadata
+// of the last item that has it
+func (q *Queue) loadOffset(iter iterator.Iterator) error {
+	metaKeyLen := len(q.opts.KeyPrefix) + 9
+	for ok := iter.Last(); ok; ok = iter.Prev() {
+		key := iter.Key()
+		if len(key) != metaKeyLen || key[metaKeyLen-1] != metaKeySuffix ||
+			q.dbKeyToID(key) == 0 {
+			continue
+		}
+		item := &Item{}
+		if err := item.decodeMeta(iter.Value()); err != nil {
+			return err
+		}
+		if item.Offset > 0 {
+			q.offset = item.Offset
+			return nil
+		}
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/syndtr/goleveldb/leveldb"
@@ -223,6 +224,48 @@ func testGetNext(t *testing.T, q *Queue) {
 	assert.EqualError(t, err, "queue: is empty")
 }
 
+func Test_GetNextItemFunc(t *testing.T) {
+	q, _ := Open(name, dir, &options)
+	defer q.Drop()
+	assert.NoError(t, q.EnqueueSynced(&Item{Value: []byte("1")}))
+	assert.NoError(t, q.Enqueue([]byte("2")))
+
+	// an error of fn keeps the item in the queue
+	_, err := q.GetNextItemFunc(func(item *Item) error {
+		assert.Equal(t, "1", string(item.Value))
+		return ErrIsEmpty
+	})
+	assert.Equal(t, ErrIsEmpty, err)
+	assert.EqualValues(t, 2, q.Length())
+
+	item, err := q.GetNextItemFunc(func(item *Item) error { return nil })
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(item.Value))
+	assert.EqualValues(t, 1, q.Length())
+}
+
+func Test_SetTimestamps(t *testing.T) {
+	q, _ := Open(name, dir, &Options{Timestamps: true})
+	assert.NoError(t, q.Enqueue([]byte("12")))
+	q.Close()
+
+	q, _ = Open(name, dir, &Options{})
+	defer q.Drop()
+	assert.NoError(t, q.Enqueue([]byte("3")))
+	item, err := q.ReadItemByID(2)
+	assert.NoError(t, err)
+	assert.True(t, item.Timestamp.IsZero())
+	assert.EqualValues(t, 0, item.Offset)
+
+	// stream offset continues from the last item with an offset
+	assert.NoError(t, q.SetTimestamps(true))
+	assert.NoError(t, q.Enqueue([]byte("456")))
+	item, err = q.ReadItemByID(3)
+	assert.NoError(t, err)
+	assert.False(t, item.Timestamp.IsZero())
+	assert.EqualValues(t, 5, item.Offset)
+}
+
 func Test_PutBack(t *testing.T) {
 	q, _ := Open(name, dir, &options)
 	testPutBack(t, q)
@@ -532,3 +575,157 @@ func Test_queuePath(t *testing.T) {
 	defer q.Drop()
 	assert.Equal(t, "./test_data/test_queue", q.Path())
 }
+
+func Test_DeleteItemByID(t *testing.T) {
+	q, _ := Open(name, dir, &options)
+	testDeleteItemByID(t, q)
+	q.Drop()
+
+	q, _ = Open(name, dir, &optionsWithKeyPrefix)
+	testDeleteItemByID(t, q)
+	q.Drop()
+
+	withSharedQueues(t, func(q *Queue) {
+		testDeleteItemByID(t, q)
+	})
+}
+
+func testDeleteItemByID(t *testing.T, q *Queue) {
+	assert.NoError(t, q.DeleteAll())
+	for i := 1; i <= 6; i++ {
+		q.Enqueue([]byte(strconv.Itoa(i)))
+	}
+
+	assert.NoError(t, q.DeleteItemByID(3))
+	assert.NoError(t, q.DeleteItemByID(4))
+	assert.NoError(t, q.DeleteItemByID(6))
+	assert.Equal(t, ErrItemNotFound, q.DeleteItemByID(3))
+	assert.Equal(t, ErrIDOutOfBounds, q.DeleteItemByID(7))
+	assert.EqualValues(t, 3, q.Length())
+	assert.EqualValues(t, 3, q.Holes())
+
+	_, err := q.ReadItemByID(3)
+	assert.Equal(t, ErrItemNotFound, err)
+
+	item, err := q.ReadItemAfter(2)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 5, item.ID)
+
+	// deleting the head item moves the head
+	assert.NoError(t, q.DeleteItemByID(1))
+	assert.EqualValues(t, 1, q.Head())
+	assert.EqualValues(t, 2, q.Length())
+
+	// holes and tail survive reinitialization
+	assert.NoError(t, q.initialize())
+	assert.EqualValues(t, 1, q.Head())
+	assert.EqualValues(t, 6, q.Tail())
+	assert.EqualValues(t, 3, q.Holes())
+	assert.EqualValues(t, 2, q.Length())
+
+	value, err := q.GetNext()
+	assert.NoError(t, err)
+	assert.Equal(t, "2", string(value))
+
+	value, err = q.GetNext()
+	assert.NoError(t, err)
+	assert.Equal(t, "5", string(value))
+	assert.EqualValues(t, 1, q.Holes())
+	assert.True(t, q.IsEmpty())
+
+	_, err = q.GetNext()
+	assert.Equal(t, ErrIsEmpty, err)
+	assert.EqualValues(t, 6, q.Head())
+	assert.EqualValues(t, 0, q.Holes())
+
+	// IDs are not reused after the tail item was deleted
+	assert.NoError(t, q.initialize())
+	assert.EqualValues(t, 6, q.Tail())
+	q.Enqueue([]byte("7"))
+	assert.EqualValues(t, 7, q.Tail())
+	q.DeleteAll()
+}
+
+func Test_Trim(t *testing.T) {
+	q, err := Open(name, dir, &Options{Timestamps: true})
+	assert.NoError(t, err)
+	defer q.Drop()
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, q.Enqueue([]byte(strconv.Itoa(i))))
+	}
+	assert.NoError(t, q.DeleteItemByID(2))
+
+	// trim by length
+	n, err := q.Trim(func(item *Item, length, size uint64) bool {
+		return length > 6
+	}, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.EqualValues(t, 6, q.Length())
+
+	item, err := q.PeekItem()
+	assert.NoError(t, err)
+	assert.Equal(t, "4", string(item.Value))
+
+	// trim by size
+	n, err = q.Trim(func(item *Item, length, size uint64) bool {
+		return size > 4
+	}, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.EqualValues(t, 4, q.Length())
+
+	// limit
+	n, err = q.Trim(func(item *Item, length, size uint64) bool {
+		return true
+	}, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	n, err = q.Trim(func(item *Item, length, size uint64) bool {
+		return true
+	}, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.True(t, q.IsEmpty())
+}
+
+func Test_SearchTime(t *testing.T) {
+	q, err := Open(name, dir, &Options{Timestamps: true})
+	assert.NoError(t, err)
+	defer q.Drop()
+
+	start := time.Now()
+	// legacy item without timestamp
+	assert.NoError(t, q.db.Put(q.dbKey(1), []byte("0"), nil))
+	q.tail = 1
+	for i := 1; i < 10; i++ {
+		item := &Item{Value: []byte(strconv.Itoa(i)), Timestamp: start.Add(time.Duration(i) * time.Second)}
+		assert.NoError(t, q.EnqueueItem(item))
+	}
+	assert.NoError(t, q.DeleteItemByID(5))
+	assert.NoError(t, q.DeleteItemByID(6))
+
+	cases := map[time.Duration]uint64{
+		-time.Hour:      2,
+		0:               2,
+		time.Second:     2,
+		2 * time.Second: 3,
+		4 * time.Second: 5,
+		5 * time.Second: 5,
+		9 * time.Second: 10,
+		time.Hour:       11,
+	}
+	for offset, expected := range cases {
+		id, err := q.SearchTime(start.Add(offset))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, id, offset.String())
+	}
+
+	_, err = q.GetNext()
+	assert.NoError(t, err)
+	id, err := q.SearchTime(start)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, id)
+}
@@ -0,0 +1,40 @@
+package queue
+
+import (
+	"encoding/binary"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// txnKeySuffix marks a transaction applied to the queue. Markers are
+// stored right after the queue state record, they share reserved
+// item ID 0, so they are never read as items.
+const txnKeySuffix = 't'
+
+// EnqueueTxn adds items to the queue with a synced write together
+// with a marker of the transaction, so a transaction replayed
+// after a crash can tell if its items were already stored
+func (q *Queue) EnqueueTxn(txn uint64, items []*Item) error {
+	q.Lock()
+	defer q.Unlock()
+	batch := new(leveldb.Batch)
+	batch.Put(q.txnKey(txn), nil)
+	return q.enqueue(batch, items, &opt.WriteOptions{Sync: true})
+}
+
+// HasTxn returns true if items of the transaction were stored
+func (q *Queue) HasTxn(txn uint64) (bool, error) {
+	return q.db.Has(q.txnKey(txn), nil)
+}
+
+// ForgetTxn removes the transaction marker
+func (q *Queue) ForgetTxn(txn uint64) error {
+	return q.db.Delete(q.txnKey(txn), nil)
+}
+
+func (q *Queue) txnKey(txn uint64) []byte {
+	key := append(q.dbKey(0), txnKeySuffix, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(key[len(key)-8:], txn)
+	return key
+}
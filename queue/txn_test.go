@@ -0,0 +1,36 @@
+package queue
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_EnqueueTxn(t *testing.T) {
+	q, _ := Open(name, dir, &optionsWithKeyPrefix)
+	q.Enqueue([]byte("1"))
+	q.GetNext()
+
+	assert.NoError(t, q.EnqueueTxn(7, []*Item{{Value: []byte("2")}, {Value: []byte("3")}}))
+	found, err := q.HasTxn(7)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	found, _ = q.HasTxn(8)
+	assert.False(t, found)
+
+	// markers are not read as items
+	q.Close()
+	q, _ = Open(name, dir, &optionsWithKeyPrefix)
+	assert.EqualValues(t, 1, q.Head())
+	assert.EqualValues(t, 3, q.Tail())
+	report, err := q.Check(false)
+	assert.NoError(t, err)
+	assert.True(t, report.OK())
+	value, _ := q.GetNext()
+	assert.Equal(t, "2", string(value))
+
+	assert.NoError(t, q.ForgetTxn(7))
+	found, _ = q.HasTxn(7)
+	assert.False(t, found)
+	q.Drop()
+}
@@ -0,0 +1,63 @@
+package queue
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// reader reads values from a database or its snapshot
+type reader interface {
+	Get(key []byte, ro *opt.ReadOptions) ([]byte, error)
+}
+
+// View is a read-only state of a queue taken at some point in time,
+// it's not affected by later changes of the queue, so it can be read
+// without locking the queue. View has to be released after use.
+type View struct {
+	queue    *Queue
+	snapshot *leveldb.Snapshot
+	head     uint64
+	tail     uint64
+}
+
+// View returns current state of the queue
+func (q *Queue) View() (*View, error) {
+	q.RLock()
+	defer q.RUnlock()
+	snapshot, err := q.db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &View{queue: q, snapshot: snapshot, head: q.head, tail: q.tail}, nil
+}
+
+// Head returns the queue head at the time the view was taken
+func (v *View) Head() uint64 { return v.head }
+
+// Tail returns the queue tail at the time the view was taken
+func (v *View) Tail() uint64 { return v.tail }
+
+// ReadItemAfter returns the first existing item with ID greater than id
+func (v *View) ReadItemAfter(id uint64) (*Item, error) {
+	if id < v.head {
+		id = v.head
+	}
+	for {
+		id++
+		if id > v.tail {
+			if v.head == v.tail {
+				return &Item{}, ErrIsEmpty
+			}
+			return &Item{}, ErrIDOutOfBounds
+		}
+		item, err := v.queue.getItem(v.snapshot, id)
+		if err != ErrItemNotFound {
+			return item, err
+		}
+	}
+}
+
+// Release releases the database snapshot of the view
+func (v *View) Release() {
+	v.snapshot.Release()
+}
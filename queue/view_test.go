@@ -0,0 +1,44 @@
+package queue
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_View(t *testing.T) {
+	q, _ := Open(name, dir, &options)
+	defer q.Drop()
+
+	q.Enqueue([]byte("1"))
+	q.Enqueue([]byte("2"))
+	q.Enqueue([]byte("3"))
+	assert.NoError(t, q.DeleteItemByID(2))
+
+	v, err := q.View()
+	assert.NoError(t, err)
+	defer v.Release()
+
+	// later changes are not visible in the view
+	q.GetNext()
+	q.Enqueue([]byte("4"))
+	assert.EqualValues(t, 0, v.Head())
+	assert.EqualValues(t, 3, v.Tail())
+
+	item, err := v.ReadItemAfter(0)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(item.Value))
+	item, err = v.ReadItemAfter(item.ID)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, item.ID)
+	_, err = v.ReadItemAfter(item.ID)
+	assert.Equal(t, ErrIDOutOfBounds, err)
+
+	q.GetNext()
+	q.GetNext()
+	empty, err := q.View()
+	assert.NoError(t, err)
+	defer empty.Release()
+	_, err = empty.ReadItemAfter(0)
+	assert.Equal(t, ErrIsEmpty, err)
+}
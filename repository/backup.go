@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bogdanovich/siberite/cgroup"
+	"github.com/bogdanovich/siberite/dump"
+)
+
+var (
+	// ErrBackupDisabled is returned when backup directory is not configured
+	ErrBackupDisabled = errors.New("repository: backup directory is not configured")
+	// ErrInvalidBackupName is returned when backup or export
+	// file name is not a plain file name
+	ErrInvalidBackupName = errors.New("repository: invalid backup name")
+)
+
+// Backup writes a consistent archive of provided queues, or of all
+// queues if none are provided, to a file in the backup directory.
+// Closed queues are opened. Returns the archive path.
+func (repo *QueueRepository) Backup(name string, queues []string) (string, error) {
+	path, err := repo.backupPath(name)
+	if err != nil {
+		return "", err
+	}
+	if len(queues) == 0 {
+		queues = repo.names()
+	}
+	for _, key := range queues {
+		if !repo.exists(key) {
+			return "", ErrQueueNotFound
+		}
+	}
+
+	tmp := path + ".tmp"
+	if err := repo.writeBackup(tmp, queues); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return path, os.Rename(tmp, path)
+}
+
+func (repo *QueueRepository) writeBackup(path string, queues []string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	b, err := cgroup.NewBackupWriter(file)
+	if err != nil {
+		return err
+	}
+	for _, key := range queues {
+		q, err := repo.CreateQueue(key)
+		if err != nil {
+			return err
+		}
+		if err = q.Backup(b); err != nil {
+			return err
+		}
+	}
+	if err = b.Close(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// Export writes pending items of the queue, or of its consumer group
+// if cursor is not empty, to a file in the backup directory.
+// File format is defined by the file extension, see dump.FormatFromPath.
+// Returns the file path and the number of exported items.
+func (repo *QueueRepository) Export(key, cursor, name string) (string, int, error) {
+	path, err := repo.backupPath(name)
+	if err != nil {
+		return "", 0, err
+	}
+	format, err := dump.FormatFromPath(name)
+	if err != nil {
+		return "", 0, err
+	}
+	q, err := repo.GetQueue(key)
+	if err != nil {
+		return "", 0, err
+	}
+	var source dump.Source = q
+	if cursor != "" {
+		if source, err = q.FindConsumerGroup(cursor); err != nil {
+			return "", 0, err
+		}
+	}
+
+	tmp := path + ".tmp"
+	n, err := writeExport(tmp, format, source)
+	if err != nil {
+		os.Remove(tmp)
+		return "", 0, err
+	}
+	return path, n, os.Rename(tmp, path)
+}
+
+func writeExport(path, format string, source dump.Source) (int, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	w, err := dump.NewWriter(file, format)
+	if err != nil {
+		return 0, err
+	}
+	n, err := dump.Export(w, source)
+	if err != nil {
+		return n, err
+	}
+	return n, file.Sync()
+}
+
+// Import appends items from a file in the backup directory to the queue.
+// Returns the number of imported items.
+func (repo *QueueRepository) Import(key, name string) (int, error) {
+	path, err := repo.backupPath(name)
+	if err != nil {
+		return 0, err
+	}
+	format, err := dump.FormatFromPath(name)
+	if err != nil {
+		return 0, err
+	}
+	q, err := repo.GetQueue(key)
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	r, err := dump.NewReader(file, format)
+	if err != nil {
+		return 0, err
+	}
+	return dump.Import(r, q.Queue)
+}
+
+// backupPath returns path of a file in the backup directory
+func (repo *QueueRepository) backupPath(name string) (string, error) {
+	if repo.opts.BackupDir == "" {
+		return "", ErrBackupDisabled
+	}
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return "", ErrInvalidBackupName
+	}
+	return filepath.Join(repo.opts.BackupDir, name), nil
+}
+
+// exists returns true if the queue is open or closed
+func (repo *QueueRepository) exists(key string) bool {
+	if _, ok := repo.get(key); ok {
+		return true
+	}
+	_, ok := repo.summary(key)
+	return ok
+}
@@ -0,0 +1,215 @@
+package repository
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bogdanovich/siberite/queue"
+)
+
+// routingKeySeparator separates words of routing keys and binding patterns
+const routingKeySeparator = ":"
+
+var (
+	// ErrInvalidPattern is returned when a binding pattern is not valid
+	ErrInvalidPattern = errors.New("repository: invalid binding pattern")
+	// ErrBindingNotFound is returned when a queue is not bound to a pattern
+	ErrBindingNotFound = errors.New("repository: binding not found")
+)
+
+var validPatternRegex = regexp.MustCompile(`^[a-zA-Z0-9_\-:*#]+$`)
+
+// Binding routes items published with a matching routing key to the queue.
+// Pattern words are separated by ":", "*" matches exactly one word and
+// "#" matches zero or more words, e.g. "orders:*:created" or "orders:#".
+type Binding struct {
+	Queue   string
+	Pattern string
+}
+
+// exchange routes published items to bound queues
+type exchange struct {
+	sync.RWMutex
+	store     *metadataStore
+	bindings  []Binding
+	unmatched uint64
+}
+
+// ValidatePattern checks a binding pattern, wildcards
+// have to be separate words
+func ValidatePattern(pattern string) error {
+	if !validPatternRegex.MatchString(pattern) {
+		return ErrInvalidPattern
+	}
+	for _, word := range strings.Split(pattern, routingKeySeparator) {
+		if len(word) > 1 && strings.ContainsAny(word, "*#") {
+			return ErrInvalidPattern
+		}
+	}
+	return nil
+}
+
+// Bind binds the queue to the pattern, binding twice does nothing
+func (repo *QueueRepository) Bind(key, pattern string) error {
+	if err := queue.ValidateName(key); err != nil {
+		return err
+	}
+	if err := ValidatePattern(pattern); err != nil {
+		return err
+	}
+	// the queue has to exist or be allowed to be created
+	if _, err := repo.GetQueue(key); err != nil {
+		return err
+	}
+	e := repo.exchange
+	e.Lock()
+	defer e.Unlock()
+	for _, b := range e.bindings {
+		if b.Queue == key && b.Pattern == pattern {
+			return nil
+		}
+	}
+	bindings := append(append([]Binding{}, e.bindings...), Binding{key, pattern})
+	sortBindings(bindings)
+	return e.save(bindings)
+}
+
+// Unbind removes the binding of the queue to the pattern
+func (repo *QueueRepository) Unbind(key, pattern string) error {
+	e := repo.exchange
+	e.Lock()
+	defer e.Unlock()
+	bindings := []Binding{}
+	for _, b := range e.bindings {
+		if b.Queue != key || b.Pattern != pattern {
+			bindings = append(bindings, b)
+		}
+	}
+	if len(bindings) == len(e.bindings) {
+		return ErrBindingNotFound
+	}
+	return e.save(bindings)
+}
+
+// Bindings returns bindings of the queue, or all
+// bindings if the queue name is empty
+func (repo *QueueRepository) Bindings(key string) []Binding {
+	e := repo.exchange
+	e.RLock()
+	defer e.RUnlock()
+	bindings := []Binding{}
+	for _, b := range e.bindings {
+		if key == "" || b.Queue == key {
+			bindings = append(bindings, b)
+		}
+	}
+	return bindings
+}
+
+// Route returns names of queues bound to patterns matching the
+// routing key, publishes that match no binding are counted
+func (repo *QueueRepository) Route(routingKey string) ([]string, error) {
+	if err := queue.ValidateName(routingKey); err != nil {
+		return nil, err
+	}
+	e := repo.exchange
+	e.RLock()
+	names := []string{}
+	for _, b := range e.bindings {
+		if (len(names) == 0 || names[len(names)-1] != b.Queue) && matchRoutingKey(b.Pattern, routingKey) {
+			names = append(names, b.Queue)
+		}
+	}
+	e.RUnlock()
+	if len(names) == 0 {
+		atomic.AddUint64(&e.unmatched, 1)
+	}
+	return names, nil
+}
+
+// Unmatched returns the number of publishes that matched no binding
+func (repo *QueueRepository) Unmatched() uint64 {
+	return atomic.LoadUint64(&repo.exchange.unmatched)
+}
+
+// unbindQueue removes all bindings of a deleted queue
+func (repo *QueueRepository) unbindQueue(key string) error {
+	e := repo.exchange
+	e.Lock()
+	defer e.Unlock()
+	bindings := []Binding{}
+	for _, b := range e.bindings {
+		if b.Queue != key {
+			bindings = append(bindings, b)
+		}
+	}
+	if len(bindings) == len(e.bindings) {
+		return nil
+	}
+	return e.save(bindings)
+}
+
+// matchRoutingKey matches words of the routing key with the pattern
+func matchRoutingKey(pattern, routingKey string) bool {
+	return matchWords(strings.Split(pattern, routingKeySeparator),
+		strings.Split(routingKey, routingKeySeparator))
+}
+
+func matchWords(pattern, words []string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case "#":
+			for i := 0; i <= len(words); i++ {
+				if matchWords(pattern[1:], words[i:]) {
+					return true
+				}
+			}
+			return false
+		case "*":
+			if len(words) == 0 {
+				return false
+			}
+		default:
+			if len(words) == 0 || words[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, words = pattern[1:], words[1:]
+	}
+	return len(words) == 0
+}
+
+// sortBindings orders bindings by queue name, so
+// bindings of the same queue are adjacent
+func sortBindings(bindings []Binding) {
+	sort.Slice(bindings, func(i, j int) bool {
+		if bindings[i].Queue == bindings[j].Queue {
+			return bindings[i].Pattern < bindings[j].Pattern
+		}
+		return bindings[i].Queue < bindings[j].Queue
+	})
+}
+
+// newExchange creates an exchange with bindings loaded from metadata
+func newExchange(store *metadataStore) *exchange {
+	bindings := append([]Binding{}, store.data.Bindings...)
+	sortBindings(bindings)
+	return &exchange{store: store, bindings: bindings}
+}
+
+// save persists bindings and replaces bindings
+// in memory once they are written
+func (e *exchange) save(bindings []Binding) error {
+	err := e.store.update(func(m *metadata) {
+		m.Bindings = bindings
+	})
+	if err != nil {
+		return err
+	}
+	e.bindings = bindings
+	return nil
+}
@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// metadataFile keeps repository metadata, like bindings and read
+// groups, it's hidden, so it's never opened as a queue
+const metadataFile = ".metadata"
+
+// metadata is persisted to the metadata file
+type metadata struct {
+	Bindings   []Binding         `json:",omitempty"`
+	ReadGroups []ReadGroupConfig `json:",omitempty"`
+}
+
+// metadataStore writes metadata of all repository features to one file
+type metadataStore struct {
+	sync.Mutex
+	path string
+	data metadata
+}
+
+// loadMetadata reads the metadata file of the data directory
+func loadMetadata(dataPath string) (*metadataStore, error) {
+	s := &metadataStore{path: filepath.Join(dataPath, metadataFile)}
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &s.data); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// update applies the change to a copy of metadata and
+// keeps the copy once it's written to the metadata file
+func (s *metadataStore) update(change func(m *metadata)) error {
+	s.Lock()
+	defer s.Unlock()
+	m := s.data
+	change(&m)
+	data, err := json.Marshal(&m)
+	if err != nil {
+		return err
+	}
+	if err = writeSynced(s.path, data); err != nil {
+		return err
+	}
+	s.data = m
+	return nil
+}
+
+// writeSynced durably replaces the file: data is written to a temporary
+// file, synced and renamed, then the directory is synced to persist
+// the rename. A crash leaves either the old or the new file.
+func writeSynced(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir persists renames and removals of directory entries
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = dir.Sync()
+	dir.Close()
+	return err
+}
@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+
+	"github.com/bogdanovich/siberite/cgroup"
+)
+
+const (
+	// quarantineDir keeps data of queues that failed to open, it's
+	// hidden, so it's never opened as a queue
+	quarantineDir = ".quarantine"
+	// quarantineReasonFile keeps the error that caused quarantine
+	quarantineReasonFile = "_.reason"
+)
+
+// QuarantinedQueue represents a queue moved to quarantine
+// because it couldn't be opened or recovered
+type QuarantinedQueue struct {
+	Name   string
+	Path   string
+	Reason string
+}
+
+// Quarantined returns queues moved to quarantine
+func (repo *QueueRepository) Quarantined() []QuarantinedQueue {
+	repo.Lock()
+	defer repo.Unlock()
+	return append([]QuarantinedQueue{}, repo.quarantined...)
+}
+
+// openQueue opens a queue, a corrupted queue is recovered and opened
+// again. A queue that can't be recovered is reported as corrupted,
+// other errors are returned as is.
+func (repo *QueueRepository) openQueue(name string) (*cgroup.CGQueue, error) {
+	q, err := cgroup.CGQueueOpen(name, repo.DataPath)
+	if !isCorrupted(err) {
+		return q, err
+	}
+	log.Printf("queue \"%s\": %s, recovering", name, err.Error())
+	if err = cgroup.CGQueueRecover(name, repo.DataPath); err == nil {
+		q, err = cgroup.CGQueueOpen(name, repo.DataPath)
+	}
+	if err != nil {
+		return nil, errors.NewErrCorrupted(storage.FileDesc{}, err)
+	}
+	return q, nil
+}
+
+// isCorrupted reports whether a queue failed to open because of corrupted data
+func isCorrupted(err error) bool {
+	return errors.IsCorrupted(err)
+}
+
+// quarantine moves queue data directory to the quarantine directory
+func (repo *QueueRepository) quarantine(name string, cause error) error {
+	dir := filepath.Join(repo.DataPath, quarantineDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s.%d", name, time.Now().UnixNano()))
+	if err := os.Rename(filepath.Join(repo.DataPath, name), path); err != nil {
+		return err
+	}
+	reason := cause.Error()
+	if err := ioutil.WriteFile(filepath.Join(path, quarantineReasonFile), []byte(reason), 0644); err != nil {
+		return err
+	}
+	repo.Lock()
+	repo.quarantined = append(repo.quarantined, QuarantinedQueue{name, path, reason})
+	repo.Unlock()
+	log.Printf("queue \"%s\": moved to quarantine %s: %s", name, path, reason)
+	return nil
+}
+
+// loadQuarantined lists queues quarantined earlier
+func (repo *QueueRepository) loadQuarantined() error {
+	dir := filepath.Join(repo.DataPath, quarantineDir)
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		if i := strings.LastIndex(name, "."); i > 0 {
+			name = name[:i]
+		}
+		path := filepath.Join(dir, entry.Name())
+		reason, _ := ioutil.ReadFile(filepath.Join(path, quarantineReasonFile))
+		repo.quarantined = append(repo.quarantined, QuarantinedQueue{name, path, string(reason)})
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bogdanovich/siberite/queue"
+)
+
+const (
+	// ReadGroupPrefix marks read group names in GET commands
+	ReadGroupPrefix = "@"
+	// readGroupWildcard at the end of a member name matches all
+	// queues with the name prefix
+	readGroupWildcard = "*"
+	// maxReadGroupWeight limits member weights
+	maxReadGroupWeight = 1000
+)
+
+var (
+	// ErrReadGroupNotFound is returned when a read group doesn't exist
+	ErrReadGroupNotFound = errors.New("repository: read group not found")
+	// ErrReadGroupMemberNotFound is returned when a queue
+	// is not a member of the read group
+	ErrReadGroupMemberNotFound = errors.New("repository: read group member not found")
+	// ErrInvalidWeight is returned when a member weight is out of range
+	ErrInvalidWeight = errors.New("repository: weight must be between 1 and 1000")
+)
+
+// ReadGroupMember is a queue or, if the name ends with "*",
+// all queues with the name prefix
+type ReadGroupMember struct {
+	Queue  string
+	Weight int
+}
+
+// ReadGroupConfig is a persisted read group definition
+type ReadGroupConfig struct {
+	Name    string
+	Members []ReadGroupMember
+}
+
+// ReadGroup is a virtual queue that serves items of its member queues,
+// so a worker pool can share many queues fairly. Members are chosen by
+// smooth weighted round-robin, a member with weight 3 serves three times
+// as many items as a member with weight 1 while both have items.
+type ReadGroup struct {
+	sync.Mutex
+	Name    string
+	members []ReadGroupMember
+	names   func() []string
+	summary func(string) (*Summary, bool)
+	current map[string]int
+	served  map[string]uint64
+}
+
+// readGroups keeps read groups of the repository
+type readGroups struct {
+	sync.RWMutex
+	store   *metadataStore
+	names   func() []string
+	summary func(string) (*Summary, bool)
+	groups  map[string]*ReadGroup
+}
+
+func newReadGroups(store *metadataStore, names func() []string,
+	summary func(string) (*Summary, bool)) *readGroups {
+	r := &readGroups{store: store, names: names, summary: summary, groups: map[string]*ReadGroup{}}
+	for _, config := range store.data.ReadGroups {
+		r.groups[config.Name] = r.newReadGroup(config)
+	}
+	return r
+}
+
+func (r *readGroups) newReadGroup(config ReadGroupConfig) *ReadGroup {
+	return &ReadGroup{
+		Name:    config.Name,
+		members: config.Members,
+		names:   r.names,
+		summary: r.summary,
+		current: map[string]int{},
+		served:  map[string]uint64{},
+	}
+}
+
+// ReadGroup returns the read group
+func (repo *QueueRepository) ReadGroup(name string) (*ReadGroup, error) {
+	r := repo.readGroups
+	r.RLock()
+	defer r.RUnlock()
+	g, ok := r.groups[name]
+	if !ok {
+		return nil, ErrReadGroupNotFound
+	}
+	return g, nil
+}
+
+// ReadGroups returns definitions of all read groups
+func (repo *QueueRepository) ReadGroups() []ReadGroupConfig {
+	r := repo.readGroups
+	r.RLock()
+	defer r.RUnlock()
+	configs := []ReadGroupConfig{}
+	for _, g := range r.groups {
+		configs = append(configs, g.Config())
+	}
+	sort.Slice(configs, func(i, j int) bool { return configs[i].Name < configs[j].Name })
+	return configs
+}
+
+// AddReadGroupMember adds a queue, or a name prefix ending with "*",
+// to the read group, the read group is created if it doesn't exist.
+// Weight of an existing member is updated.
+func (repo *QueueRepository) AddReadGroupMember(name, member string, weight int) error {
+	if err := queue.ValidateName(name); err != nil {
+		return err
+	}
+	if err := queue.ValidateName(strings.TrimSuffix(member, readGroupWildcard)); err != nil {
+		return err
+	}
+	if weight < 1 || weight > maxReadGroupWeight {
+		return ErrInvalidWeight
+	}
+
+	r := repo.readGroups
+	r.Lock()
+	defer r.Unlock()
+	config := ReadGroupConfig{Name: name}
+	if g, ok := r.groups[name]; ok {
+		config = g.Config()
+	}
+	members := []ReadGroupMember{}
+	for _, m := range config.Members {
+		if m.Queue != member {
+			members = append(members, m)
+		}
+	}
+	config.Members = append(members, ReadGroupMember{member, weight})
+	return r.save(config)
+}
+
+// RemoveReadGroupMember removes a member of the read group,
+// the read group is deleted along with its last member
+func (repo *QueueRepository) RemoveReadGroupMember(name, member string) error {
+	r := repo.readGroups
+	r.Lock()
+	defer r.Unlock()
+	g, ok := r.groups[name]
+	if !ok {
+		return ErrReadGroupNotFound
+	}
+	config := g.Config()
+	members := []ReadGroupMember{}
+	for _, m := range config.Members {
+		if m.Queue != member {
+			members = append(members, m)
+		}
+	}
+	if len(members) == len(config.Members) {
+		return ErrReadGroupMemberNotFound
+	}
+	config.Members = members
+	return r.save(config)
+}
+
+// save persists the read group definition and replaces the read group,
+// a read group without members is deleted
+func (r *readGroups) save(config ReadGroupConfig) error {
+	configs := []ReadGroupConfig{}
+	for _, g := range r.groups {
+		if g.Name != config.Name {
+			configs = append(configs, g.Config())
+		}
+	}
+	if len(config.Members) > 0 {
+		configs = append(configs, config)
+	}
+	sort.Slice(configs, func(i, j int) bool { return configs[i].Name < configs[j].Name })
+	err := r.store.update(func(m *metadata) {
+		m.ReadGroups = configs
+	})
+	if err != nil {
+		return err
+	}
+
+	g, ok := r.groups[config.Name]
+	if len(config.Members) == 0 {
+		delete(r.groups, config.Name)
+	} else if ok {
+		g.Lock()
+		g.members = config.Members
+		g.Unlock()
+	} else {
+		r.groups[config.Name] = r.newReadGroup(config)
+	}
+	return nil
+}
+
+// Config returns the read group definition
+func (g *ReadGroup) Config() ReadGroupConfig {
+	g.Lock()
+	defer g.Unlock()
+	return ReadGroupConfig{g.Name, append([]ReadGroupMember{}, g.members...)}
+}
+
+// Members resolves member queues, it's done once per read. Closed queues
+// that had no items to read from the queue, or through its consumer group
+// if one is given, are skipped, so reads don't reopen idle queues.
+func (g *ReadGroup) Members(consumerGroup string) []ReadGroupMember {
+	g.Lock()
+	defer g.Unlock()
+	members := []ReadGroupMember{}
+	for _, m := range g.queues() {
+		if s, closed := g.summary(m.Queue); closed && s.empty(consumerGroup) {
+			continue
+		}
+		members = append(members, m)
+	}
+	return members
+}
+
+// Next returns the member queue to read next, skipping tried queues,
+// an empty string is returned when all member queues were tried
+func (g *ReadGroup) Next(members []ReadGroupMember, tried map[string]bool) string {
+	g.Lock()
+	defer g.Unlock()
+	total, best := 0, ""
+	for _, m := range members {
+		if tried[m.Queue] {
+			continue
+		}
+		g.current[m.Queue] += m.Weight
+		total += m.Weight
+		if best == "" || g.current[m.Queue] > g.current[best] {
+			best = m.Queue
+		}
+	}
+	if best != "" {
+		g.current[best] -= total
+	}
+	return best
+}
+
+// Served counts an item served from the member queue
+func (g *ReadGroup) Served(name string) {
+	g.Lock()
+	g.served[name]++
+	g.Unlock()
+}
+
+// stats returns stat items of member queues
+func (g *ReadGroup) stats() []StatItem {
+	g.Lock()
+	defer g.Unlock()
+	prefix := "readgroup_" + g.Name + "_"
+	stats := []StatItem{}
+	for _, m := range g.queues() {
+		stats = append(stats,
+			StatItem{prefix + m.Queue + "_weight", fmt.Sprintf("%d", m.Weight)},
+			StatItem{prefix + m.Queue + "_served", fmt.Sprintf("%d", g.served[m.Queue])})
+	}
+	return stats
+}
+
+// queues resolves members to existing queues ordered by name, so reads
+// never create queues. A queue listed by name takes the weight of its
+// member over prefixes.
+func (g *ReadGroup) queues() []ReadGroupMember {
+	listed := map[string]int{}
+	for _, m := range g.members {
+		if !strings.HasSuffix(m.Queue, readGroupWildcard) {
+			listed[m.Queue] = m.Weight
+		}
+	}
+	weights := map[string]int{}
+	for _, name := range g.names() {
+		if weight, ok := listed[name]; ok {
+			weights[name] = weight
+			continue
+		}
+		for _, m := range g.members {
+			prefix := strings.TrimSuffix(m.Queue, readGroupWildcard)
+			if prefix != m.Queue && strings.HasPrefix(name, prefix) {
+				weights[name] = m.Weight
+				break
+			}
+		}
+	}
+	queues := make([]ReadGroupMember, 0, len(weights))
+	for name, weight := range weights {
+		queues = append(queues, ReadGroupMember{name, weight})
+	}
+	sort.Slice(queues, func(i, j int) bool { return queues[i].Queue < queues[j].Queue })
+	return queues
+}
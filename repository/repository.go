@@ -1,27 +1,64 @@
 package repository
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/orcaman/concurrent-map"
 
 	"github.com/bogdanovich/siberite/cgroup"
+	"github.com/bogdanovich/siberite/queue"
 )
 
 // Version represents siberite version
 const Version = "siberite-0.6.4"
 
+// ErrQueueNotFound is returned when a queue doesn't exist
+// and can't be created implicitly
+var ErrQueueNotFound = errors.New("repository: queue does not exist")
+
 // QueueRepository represents a repository of queues
 type QueueRepository struct {
 	sync.Mutex
-	storage  cmap.ConcurrentMap
-	DataPath string
-	Stats    *Stats
+	storage     cmap.ConcurrentMap
+	used        cmap.ConcurrentMap
+	closed      map[string]*Summary
+	quarantined []QuarantinedQueue
+	exchange    *exchange
+	readGroups  *readGroups
+	txns        *transactionLog
+	DataPath    string
+	Stats       *Stats
+	opts        *Options
+}
+
+// Options represents repository options
+type Options struct {
+	// Strict disables implicit queue creation, queues have to be
+	// listed in Queues or created with CreateQueue
+	Strict bool
+	// AutoCreate limits names of implicitly created queues, the pattern
+	// is matched as is, so it has to be anchored to match whole names.
+	// Any name is allowed if it's nil.
+	AutoCreate *regexp.Regexp
+	// Queues are created on start
+	Queues []string
+	// IdleTimeout closes queues that were not used for that long,
+	// closed queues are opened on first use. Queues are opened on
+	// start and kept open if it's 0
+	IdleTimeout time.Duration
+	// BackupDir is the directory for backup archives and export files,
+	// backups, exports and imports are disabled if it's empty
+	BackupDir string
 }
 
 // Stats keeps service stat fields
@@ -42,53 +79,105 @@ type StatItem struct {
 
 // NewRepository and open all queues in the data directory
 func NewRepository(dataDir string) (*QueueRepository, error) {
+	return NewRepositoryWithOptions(dataDir, &Options{})
+}
+
+// NewRepositoryWithOptions creates a repository with provided options
+// and opens all queues in the data directory
+func NewRepositoryWithOptions(dataDir string, opts *Options) (*QueueRepository, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
 	dataPath, err := filepath.Abs(dataDir)
 	if err != nil {
 		return nil, err
 	}
 	stats := &Stats{Version, time.Now().Unix(), 0, 0, 0, 0}
-	repo := QueueRepository{storage: cmap.New(), DataPath: dataPath, Stats: stats}
+	repo := QueueRepository{
+		storage:  cmap.New(),
+		used:     cmap.New(),
+		closed:   map[string]*Summary{},
+		txns:     newTransactionLog(filepath.Join(dataPath, transactionDir)),
+		DataPath: dataPath,
+		Stats:    stats,
+		opts:     opts,
+	}
 	return &repo, repo.initialize()
 }
 
 // GetQueue returns existing queue from repository,
-// creates a new one if it doesn't exist
+// creates a new one if it doesn't exist and implicit creation is allowed
 func (repo *QueueRepository) GetQueue(key string) (*cgroup.CGQueue, error) {
-	if q, ok := repo.get(key); ok {
+	if q, ok := repo.lookup(key); ok {
 		return q, nil
 	}
+	if _, closed := repo.summary(key); !closed && (repo.opts.Strict ||
+		(repo.opts.AutoCreate != nil && !repo.opts.AutoCreate.MatchString(key))) {
+		return nil, ErrQueueNotFound
+	}
+	return repo.CreateQueue(key)
+}
+
+// OpenQueue returns the queue if it's open and records its use,
+// so it's not closed as idle. A queue is never created or reopened.
+func (repo *QueueRepository) OpenQueue(key string) (*cgroup.CGQueue, bool) {
+	return repo.lookup(key)
+}
+
+// CreateQueue returns existing queue from repository,
+// creates a new one if it doesn't exist
+func (repo *QueueRepository) CreateQueue(key string) (*cgroup.CGQueue, error) {
+	if q, ok := repo.lookup(key); ok {
+		return q, nil
+	}
+	if err := queue.ValidateName(key); err != nil {
+		return nil, err
+	}
 
 	repo.Lock()
 	defer repo.Unlock()
 
 	// now that we have acquired the lock, recheck to see if someone else
 	// already managed to create the queue while we were waiting on the lock
-	if q, ok := repo.get(key); ok {
+	if q, ok := repo.lookup(key); ok {
 		return q, nil
 	}
 
-	// ok, we are the first - create the queue
-	q, err := cgroup.CGQueueOpen(key, repo.DataPath)
+	// ok, we are the first - create or reopen the queue
+	q, err := repo.openQueue(key)
 	if err != nil {
 		return nil, err
 	}
-	repo.storage.Set(key, q)
+	if err = repo.add(q); err != nil {
+		q.Close()
+		return nil, err
+	}
 	return q, nil
 }
 
-// DeleteQueue deletes a queue from the repository
+// DeleteQueue deletes a queue from the repository along with its bindings
 func (repo *QueueRepository) DeleteQueue(key string) error {
+	if err := repo.unbindQueue(key); err != nil {
+		return err
+	}
 	if q, ok := repo.get(key); ok {
 		q.Drop()
 		repo.storage.Remove(key)
+		repo.used.Remove(key)
+	}
+	repo.Lock()
+	defer repo.Unlock()
+	if _, ok := repo.closed[key]; ok {
+		delete(repo.closed, key)
+		return os.RemoveAll(filepath.Join(repo.DataPath, key))
 	}
 	return nil
 }
 
 // DeleteAllQueues deletes all queues from the repo
 func (repo *QueueRepository) DeleteAllQueues() error {
-	for pair := range repo.storage.IterBuffered() {
-		if err := repo.DeleteQueue(pair.Key); err != nil {
+	for _, key := range repo.names() {
+		if err := repo.DeleteQueue(key); err != nil {
 			return err
 		}
 	}
@@ -97,8 +186,8 @@ func (repo *QueueRepository) DeleteAllQueues() error {
 
 // FlushAllQueues removes all items from all the queues
 func (repo *QueueRepository) FlushAllQueues() error {
-	for pair := range repo.storage.IterBuffered() {
-		q, err := repo.GetQueue(pair.Key)
+	for _, key := range repo.names() {
+		q, err := repo.CreateQueue(key)
 		if err != nil {
 			return err
 		}
@@ -109,18 +198,35 @@ func (repo *QueueRepository) FlushAllQueues() error {
 	return nil
 }
 
-// CloseAllQueues closes all queues
+// CloseAllQueues closes all queues, queue summaries are saved
+// if idle queues are closed, so queues are opened lazily on next start
 func (repo *QueueRepository) CloseAllQueues() error {
 	for pair := range repo.storage.IterBuffered() {
-		q, err := repo.GetQueue(pair.Key)
-		if err != nil {
-			return err
+		q := pair.Val.(*cgroup.CGQueue)
+		if repo.opts.IdleTimeout > 0 {
+			if err := saveSummary(q.Path(), summarize(q)); err != nil {
+				return err
+			}
 		}
 		q.Close()
 	}
 	return nil
 }
 
+// Maintain runs background maintenance tasks for all queues
+func (repo *QueueRepository) Maintain() {
+	for pair := range repo.storage.IterBuffered() {
+		q := pair.Val.(*cgroup.CGQueue)
+		if err := q.Maintain(); err != nil {
+			log.Printf("queue %s maintenance: %s", q.Name, err.Error())
+		}
+	}
+	repo.retryTransactions()
+	if repo.opts.IdleTimeout > 0 {
+		repo.closeIdleQueues(time.Now().Add(-repo.opts.IdleTimeout))
+	}
+}
+
 // FullStats gets repository stats
 func (repo *QueueRepository) FullStats() []StatItem {
 	stats := []StatItem{}
@@ -132,25 +238,44 @@ func (repo *QueueRepository) FullStats() []StatItem {
 	stats = append(stats, StatItem{"total_connections", fmt.Sprintf("%d", repo.Stats.TotalConnections)})
 	stats = append(stats, StatItem{"cmd_get", fmt.Sprintf("%d", repo.Stats.CmdGet)})
 	stats = append(stats, StatItem{"cmd_set", fmt.Sprintf("%d", repo.Stats.CmdSet)})
+	stats = append(stats, StatItem{"quarantined_queues", fmt.Sprintf("%d", len(repo.Quarantined()))})
+	stats = append(stats, StatItem{"exchange_bindings", fmt.Sprintf("%d", len(repo.Bindings("")))})
+	stats = append(stats, StatItem{"exchange_unmatched", fmt.Sprintf("%d", repo.Unmatched())})
+	for _, config := range repo.ReadGroups() {
+		if g, err := repo.ReadGroup(config.Name); err == nil {
+			stats = append(stats, g.stats()...)
+		}
+	}
 
-	var q *cgroup.CGQueue
-	var cg *cgroup.ConsumerGroup
 	for pair := range repo.storage.IterBuffered() {
-		q = pair.Val.(*cgroup.CGQueue)
-		stats = append(stats, StatItem{"queue_" + q.Name + "_items", fmt.Sprintf("%d", q.Length())})
-		stats = append(stats, StatItem{"queue_" + q.Name + "_open_transactions", fmt.Sprintf("%d", q.Stats().OpenReads)})
-		for pair := range q.ConsumerGroupIterator() {
-			cg = pair.Val.(*cgroup.ConsumerGroup)
-			stats = append(stats, StatItem{"queue_" + q.Name + "." + cg.Name + "_items", fmt.Sprintf("%d", cg.Length())})
-			stats = append(stats, StatItem{"queue_" + q.Name + "." + cg.Name + "_open_transactions", fmt.Sprintf("%d", cg.Stats().OpenReads)})
-		}
+		q := pair.Val.(*cgroup.CGQueue)
+		stats = append(stats, summarize(q).stats(q.Name, currentTime)...)
+	}
+	repo.Lock()
+	defer repo.Unlock()
+	for name, s := range repo.closed {
+		stats = append(stats, s.stats(name, currentTime)...)
 	}
 	return stats
 }
 
+// Length returns number of items in the queue,
+// a closed queue is not reopened
+func (repo *QueueRepository) Length(key string) (uint64, error) {
+	if q, ok := repo.get(key); ok {
+		return q.Length(), nil
+	}
+	if s, ok := repo.summary(key); ok {
+		return s.Items, nil
+	}
+	return 0, ErrQueueNotFound
+}
+
 // Count returns a total number of queues
 func (repo *QueueRepository) Count() int {
-	return repo.storage.Count()
+	repo.Lock()
+	defer repo.Unlock()
+	return repo.storage.Count() + len(repo.closed)
 }
 
 func (repo *QueueRepository) initialize() error {
@@ -159,20 +284,124 @@ func (repo *QueueRepository) initialize() error {
 		return fmt.Errorf("error opening data directory (%s): %s",
 			repo.DataPath, err.Error())
 	}
+	if err = repo.loadQuarantined(); err != nil {
+		return err
+	}
+	store, err := loadMetadata(repo.DataPath)
+	if err != nil {
+		return fmt.Errorf("error loading metadata: %s", err.Error())
+	}
+	repo.exchange = newExchange(store)
+	repo.readGroups = newReadGroups(store, repo.names, repo.summary)
+	names := []string{}
 	for _, dir := range dirs {
-		if dir.IsDir() {
-			// queue init
-			q, err := repo.GetQueue(dir.Name())
-			if err != nil {
-				log.Fatalf("queue %s...%s", dir.Name(), err.Error())
+		// hidden directories, like quarantine, are not queues
+		if !dir.IsDir() || strings.HasPrefix(dir.Name(), ".") {
+			continue
+		}
+		if repo.opts.IdleTimeout > 0 {
+			if s, err := loadSummary(filepath.Join(repo.DataPath, dir.Name())); err == nil {
+				repo.closed[dir.Name()] = s
+				log.Printf("queue \"%s\": size %d, head %d, tail %d, closed",
+					dir.Name(), s.Items, s.Head, s.Tail)
+				continue
 			}
-			log.Printf("queue \"%s\": size %d, head %d, tail %d",
-				dir.Name(), q.Length(), q.Head(), q.Tail())
+		}
+		names = append(names, dir.Name())
+	}
+	if err = repo.initQueues(names); err != nil {
+		return err
+	}
+	for _, name := range repo.opts.Queues {
+		if _, err = repo.CreateQueue(name); err != nil {
+			return fmt.Errorf("error creating queue %s: %s", name, err.Error())
+		}
+	}
+	return repo.replayTransactions()
+}
+
+// initQueues opens queues in parallel, corrupted queues
+// that can't be recovered are quarantined
+func (repo *QueueRepository) initQueues(names []string) error {
+	jobs := make(chan string)
+	errs := make(chan error, len(names))
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range jobs {
+				errs <- repo.initQueue(name)
+			}
+		}()
+	}
+	for _, name := range names {
+		jobs <- name
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			// release queues opened so far, startup fails anyway
+			repo.CloseAllQueues()
+			return err
 		}
 	}
 	return nil
 }
 
+func (repo *QueueRepository) initQueue(name string) error {
+	q, err := repo.openQueue(name)
+	if isCorrupted(err) {
+		return repo.quarantine(name, err)
+	}
+	if err != nil {
+		return fmt.Errorf("error opening queue %s: %s", name, err.Error())
+	}
+	repo.Lock()
+	err = repo.add(q)
+	repo.Unlock()
+	if err != nil {
+		q.Close()
+		return fmt.Errorf("error opening queue %s: %s", name, err.Error())
+	}
+	log.Printf("queue \"%s\": size %d, head %d, tail %d",
+		name, q.Length(), q.Head(), q.Tail())
+	return nil
+}
+
+// add puts an opened queue into the repository,
+// must be called with the repository lock held
+func (repo *QueueRepository) add(q *cgroup.CGQueue) error {
+	if err := removeSummary(q.Path()); err != nil {
+		return err
+	}
+	delete(repo.closed, q.Name)
+	repo.storage.Set(q.Name, q)
+	repo.touch(q.Name)
+	return nil
+}
+
+// names returns names of open and closed queues
+func (repo *QueueRepository) names() []string {
+	repo.Lock()
+	defer repo.Unlock()
+	names := repo.storage.Keys()
+	for name := range repo.closed {
+		names = append(names, name)
+	}
+	return names
+}
+
+// summary returns the persisted summary of a closed queue
+func (repo *QueueRepository) summary(key string) (*Summary, bool) {
+	repo.Lock()
+	defer repo.Unlock()
+	s, ok := repo.closed[key]
+	return s, ok
+}
+
 func (repo *QueueRepository) get(key string) (*cgroup.CGQueue, bool) {
 	if val, ok := repo.storage.Get(key); ok {
 		return val.(*cgroup.CGQueue), ok
@@ -2,12 +2,18 @@ package repository
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/bogdanovich/siberite/cgroup"
+	"github.com/bogdanovich/siberite/dump"
+	"github.com/bogdanovich/siberite/queue"
 )
 
 var dir = "./test_data"
@@ -87,7 +93,8 @@ func Test_FullStats(t *testing.T) {
 
 	statItemKeys := []string{
 		"uptime", "time", "version", "curr_connections", "total_connections",
-		"cmd_get", "cmd_set", "queue_test1_items", "queue_test1_open_transactions",
+		"cmd_get", "cmd_set", "quarantined_queues", "exchange_bindings", "exchange_unmatched",
+		"queue_test1_items", "queue_test1_open_transactions",
 	}
 
 	for i, statItem := range repo.FullStats() {
@@ -132,3 +139,492 @@ func Test_Count(t *testing.T) {
 	repo.GetQueue("test2")
 	assert.Equal(t, 2, repo.Count())
 }
+
+func Test_NewRepositoryWithOptions_Strict(t *testing.T) {
+	repo, err := NewRepositoryWithOptions(dir, &Options{Strict: true, Queues: []string{"declared"}})
+	assert.NoError(t, err)
+	defer repo.DeleteAllQueues()
+
+	_, err = repo.GetQueue("declared")
+	assert.NoError(t, err)
+
+	_, err = repo.GetQueue("unknown")
+	assert.Equal(t, ErrQueueNotFound, err)
+	assert.Equal(t, 1, repo.Count())
+
+	_, err = repo.CreateQueue("unknown")
+	assert.NoError(t, err)
+	_, err = repo.GetQueue("unknown")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, repo.Count())
+}
+
+func Test_NewRepositoryWithOptions_AutoCreate(t *testing.T) {
+	repo, err := NewRepositoryWithOptions(dir, &Options{AutoCreate: regexp.MustCompile(`^(?:jobs_.*)$`)})
+	assert.NoError(t, err)
+	defer repo.DeleteAllQueues()
+
+	_, err = repo.GetQueue("jobs_1")
+	assert.NoError(t, err)
+
+	_, err = repo.GetQueue("job_1")
+	assert.Equal(t, ErrQueueNotFound, err)
+	_, err = repo.GetQueue("xjobs_1")
+	assert.Equal(t, ErrQueueNotFound, err)
+	assert.Equal(t, 1, repo.Count())
+}
+
+func Test_Maintain_ClosesIdleQueues(t *testing.T) {
+	repo, err := NewRepositoryWithOptions(dir, &Options{IdleTimeout: time.Hour})
+	assert.NoError(t, err)
+	defer repo.DeleteAllQueues()
+
+	q, _ := repo.GetQueue("idle")
+	q.Enqueue([]byte("1"))
+	q.Enqueue([]byte("2"))
+	cg, _ := q.ConsumerGroup("cg")
+	cg.GetNext()
+	busy, _ := repo.GetQueue("busy")
+	busy.Enqueue([]byte("1"))
+
+	repo.Maintain()
+	assert.Equal(t, 2, repo.Count())
+	_, closed := repo.summary("idle")
+	assert.False(t, closed)
+
+	repo.used.Set("idle", time.Now().Add(-2*time.Hour))
+	repo.Maintain()
+	assert.Equal(t, 2, repo.Count())
+	_, open := repo.get("idle")
+	assert.False(t, open)
+	_, open = repo.get("busy")
+	assert.True(t, open)
+
+	length, err := repo.Length("idle")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, length)
+	_, err = repo.Length("unknown")
+	assert.Equal(t, ErrQueueNotFound, err)
+
+	stats := map[string]string{}
+	for _, item := range repo.FullStats() {
+		stats[item.Key] = item.Value
+	}
+	assert.Equal(t, "2", stats["queue_idle_items"])
+	assert.Equal(t, "1", stats["queue_idle.cg_items"])
+	assert.Equal(t, "1", stats["queue_idle.cg_cursor"])
+	assert.Equal(t, "1", stats["queue_busy_items"])
+
+	q, err = repo.GetQueue("idle")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, q.Length())
+	_, closed = repo.summary("idle")
+	assert.False(t, closed)
+	_, err = os.Stat(q.Path() + "/" + summaryFile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_GetQueue_IdleClose(t *testing.T) {
+	repo, err := NewRepositoryWithOptions(dir, &Options{IdleTimeout: time.Hour})
+	assert.NoError(t, err)
+	defer repo.DeleteAllQueues()
+
+	_, err = repo.GetQueue("race")
+	assert.NoError(t, err)
+
+	// a queue returned by GetQueue is never closed by a concurrent idle close
+	for i := 0; i < 100; i++ {
+		cutoff := time.Now()
+		repo.used.Set("race", cutoff.Add(-time.Hour))
+		done := make(chan struct{})
+		go func() {
+			repo.closeQueue("race", cutoff)
+			close(done)
+		}()
+		q, err := repo.GetQueue("race")
+		<-done
+		assert.NoError(t, err)
+		current, open := repo.get("race")
+		assert.True(t, open)
+		assert.Equal(t, q, current)
+		assert.NoError(t, q.Enqueue([]byte("1")))
+	}
+}
+
+func Test_NewRepositoryWithOptions_IdleTimeout(t *testing.T) {
+	opts := &Options{Strict: true, Queues: []string{"lazy"}, IdleTimeout: time.Hour}
+	repo, err := NewRepositoryWithOptions(dir, opts)
+	assert.NoError(t, err)
+	q, _ := repo.GetQueue("lazy")
+	q.Enqueue([]byte("1"))
+	repo.GetQueue("other")
+	repo.CloseAllQueues()
+
+	opts.Queues = nil
+	repo, err = NewRepositoryWithOptions(dir, opts)
+	assert.NoError(t, err)
+	defer repo.DeleteAllQueues()
+
+	assert.Equal(t, 1, repo.Count())
+	assert.Equal(t, 0, repo.storage.Count())
+	length, err := repo.Length("lazy")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, length)
+
+	// closed queues exist in strict mode
+	q, err = repo.GetQueue("lazy")
+	assert.NoError(t, err)
+	value, _ := q.GetNext()
+	assert.Equal(t, []byte("1"), value)
+	assert.Equal(t, 1, repo.Count())
+}
+
+func Test_NewRepository_Quarantine(t *testing.T) {
+	// a corrupted queue with a file in place of its metadata
+	// leveldb directory can't be recovered
+	assert.NoError(t, os.MkdirAll(dir+"/broken/broken", 0777))
+	assert.NoError(t, ioutil.WriteFile(dir+"/broken/broken/CURRENT", []byte("garbage"), 0644))
+	assert.NoError(t, ioutil.WriteFile(dir+"/broken/_.metadata", []byte("data"), 0644))
+	defer os.RemoveAll(dir + "/" + quarantineDir)
+
+	repo, err := NewRepository(dir)
+	assert.NoError(t, err)
+	q, _ := repo.GetQueue("healthy")
+	q.Enqueue([]byte("1"))
+	repo.CloseAllQueues()
+
+	// corrupt manifest is recovered
+	manifests, _ := filepath.Glob(dir + "/healthy/healthy/MANIFEST-*")
+	assert.NotEmpty(t, manifests)
+	for _, manifest := range manifests {
+		assert.NoError(t, ioutil.WriteFile(manifest, []byte("garbage"), 0644))
+	}
+
+	repo, err = NewRepository(dir)
+	assert.NoError(t, err)
+	defer repo.DeleteAllQueues()
+
+	quarantined := repo.Quarantined()
+	assert.Len(t, quarantined, 1)
+	assert.Equal(t, "broken", quarantined[0].Name)
+	assert.NotEmpty(t, quarantined[0].Reason)
+	_, err = os.Stat(quarantined[0].Path + "/broken")
+	assert.NoError(t, err)
+	_, err = os.Stat(dir + "/broken")
+	assert.True(t, os.IsNotExist(err))
+
+	q, _ = repo.GetQueue("healthy")
+	assert.EqualValues(t, 1, q.Length())
+	value, _ := q.GetNext()
+	assert.Equal(t, []byte("1"), value)
+
+	repo.CloseAllQueues()
+	repo, err = NewRepository(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, quarantined, repo.Quarantined())
+}
+
+func Test_NewRepository_Locked(t *testing.T) {
+	repo, err := NewRepository(dir)
+	assert.NoError(t, err)
+	defer repo.DeleteAllQueues()
+	q, _ := repo.GetQueue("locked")
+	q.Enqueue([]byte("1"))
+
+	// a queue locked by another repository is not quarantined
+	_, err = NewRepository(dir)
+	assert.Error(t, err)
+	assert.Empty(t, repo.Quarantined())
+	_, err = os.Stat(dir + "/" + quarantineDir)
+	assert.True(t, os.IsNotExist(err))
+	assert.EqualValues(t, 1, q.Length())
+}
+
+func Test_Backup(t *testing.T) {
+	backupDir := "./test_backups"
+	assert.NoError(t, os.MkdirAll(backupDir, 0777))
+	defer os.RemoveAll(backupDir)
+
+	repo, err := NewRepository(dir)
+	assert.NoError(t, err)
+	_, err = repo.Backup("all.gz", nil)
+	assert.Equal(t, ErrBackupDisabled, err)
+	repo.CloseAllQueues()
+
+	repo, err = NewRepositoryWithOptions(dir, &Options{BackupDir: backupDir})
+	assert.NoError(t, err)
+	defer repo.DeleteAllQueues()
+
+	q1, _ := repo.GetQueue("test1")
+	q1.Enqueue([]byte("1"))
+	repo.GetQueue("test2")
+
+	path, err := repo.Backup("all.gz", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(backupDir, "all.gz"), path)
+
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+	restored, err := cgroup.Restore(file, backupDir, func(name string) string { return name })
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"test1", "test2"}, restored)
+
+	_, err = repo.Backup("one.gz", []string{"test1", "unknown"})
+	assert.Equal(t, ErrQueueNotFound, err)
+	_, err = os.Stat(filepath.Join(backupDir, "one.gz"))
+	assert.True(t, os.IsNotExist(err))
+
+	for _, name := range []string{"", "../all.gz", ".hidden"} {
+		_, err = repo.Backup(name, nil)
+		assert.Equal(t, ErrInvalidBackupName, err)
+	}
+}
+
+func Test_ExportImport(t *testing.T) {
+	backupDir := "./test_backups"
+	assert.NoError(t, os.MkdirAll(backupDir, 0777))
+	defer os.RemoveAll(backupDir)
+
+	repo, err := NewRepositoryWithOptions(dir, &Options{BackupDir: backupDir})
+	assert.NoError(t, err)
+	defer repo.DeleteAllQueues()
+
+	q, _ := repo.GetQueue("source")
+	q.Enqueue([]byte("1"))
+	q.Enqueue([]byte("2"))
+	cg, _ := q.ConsumerGroup("cg1")
+	cg.GetNext()
+
+	path, n, err := repo.Export("source", "cg1", "cg1.dump")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, filepath.Join(backupDir, "cg1.dump"), path)
+
+	_, _, err = repo.Export("source", "unknown", "cg1.dump")
+	assert.Equal(t, cgroup.ErrNotFound, err)
+	_, _, err = repo.Export("source", "", "source.txt")
+	assert.Equal(t, dump.ErrUnknownFormat, err)
+
+	n, err = repo.Import("target", "cg1.dump")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	target, _ := repo.GetQueue("target")
+	value, _ := target.GetNext()
+	assert.Equal(t, "2", string(value))
+
+	_, err = repo.Import("target", "missing.jsonl")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_Commit_Replay(t *testing.T) {
+	repo, err := NewRepository(dir)
+	assert.NoError(t, err)
+
+	q, _ := repo.GetQueue("pipeline")
+	q.EnqueueItem(&queue.Item{Value: []byte("1"), Group: "g"})
+	q.EnqueueItem(&queue.Item{Value: []byte("2"), Group: "g"})
+	item, err := q.OpenNext()
+	assert.NoError(t, err)
+
+	txn := &Transaction{
+		Queue:  "pipeline",
+		Group:  "g",
+		ItemID: item.ID,
+		Items: []TransactionItem{
+			{Queue: "stage1", Value: []byte("a")},
+			{Queue: "stage2", Value: []byte("b")},
+		},
+	}
+	// the server stops after items of the first queue were stored
+	assert.NoError(t, repo.logTransaction(7, txn))
+	stage1, _ := repo.GetQueue("stage1")
+	assert.NoError(t, stage1.EnqueueTxn(7, []*queue.Item{{Value: []byte("a")}}))
+	repo.CloseAllQueues()
+
+	repo, err = NewRepository(dir)
+	assert.NoError(t, err)
+	defer repo.DeleteAllQueues()
+	for _, name := range []string{"stage1", "stage2"} {
+		q, _ = repo.GetQueue(name)
+		assert.EqualValues(t, 1, q.Length())
+		found, _ := q.HasTxn(7)
+		assert.False(t, found)
+	}
+	q, _ = repo.GetQueue("pipeline")
+	assert.EqualValues(t, 1, q.Length())
+	value, _ := q.GetNext()
+	assert.Equal(t, "2", string(value))
+	entries, _ := filepath.Glob(filepath.Join(dir, transactionDir, "*.*"))
+	assert.Empty(t, entries)
+
+	// committed transactions are not left in the log
+	q.Enqueue([]byte("3"))
+	q.OpenNext()
+	assert.NoError(t, repo.Commit(&Transaction{Queue: "pipeline", Items: []TransactionItem{
+		{Queue: "stage1", Value: []byte("c")},
+		{Queue: "stage2", Value: []byte("d")},
+	}}))
+	entries, _ = filepath.Glob(filepath.Join(dir, transactionDir, "*.*"))
+	assert.Empty(t, entries)
+	stage2, _ := repo.GetQueue("stage2")
+	assert.EqualValues(t, 2, stage2.Length())
+}
+
+func Test_Commit_Retry(t *testing.T) {
+	repo, err := NewRepository(dir)
+	assert.NoError(t, err)
+	defer repo.DeleteAllQueues()
+
+	// a logged transaction that failed to apply is applied by maintenance
+	txn := &Transaction{Queue: "pipeline", Items: []TransactionItem{
+		{Queue: "stage1", Value: []byte("a")},
+		{Queue: "stage2", Value: []byte("b")},
+	}}
+	id, err := repo.txns.nextID()
+	assert.NoError(t, err)
+	assert.NoError(t, repo.logTransaction(id, txn))
+	repo.txns.addPending(id, txn)
+
+	repo.Maintain()
+	assert.Empty(t, repo.txns.pendingTransactions())
+	for _, name := range []string{"stage1", "stage2"} {
+		q, _ := repo.GetQueue(name)
+		assert.EqualValues(t, 1, q.Length())
+	}
+	entries, _ := filepath.Glob(filepath.Join(dir, transactionDir, "*.*"))
+	assert.Empty(t, entries)
+
+	// transaction IDs are not reused after restart
+	repo.CloseAllQueues()
+	repo, err = NewRepository(dir)
+	assert.NoError(t, err)
+	next, err := repo.txns.nextID()
+	assert.NoError(t, err)
+	assert.True(t, next > id)
+}
+
+func Test_Route(t *testing.T) {
+	repo, err := NewRepository(dir)
+	assert.NoError(t, err)
+	defer repo.DeleteAllQueues()
+
+	assert.NoError(t, repo.Bind("orders_created", "orders:*:created"))
+	assert.NoError(t, repo.Bind("orders_all", "orders:#"))
+	assert.NoError(t, repo.Bind("orders_all", "orders:*:created"))
+	assert.NoError(t, repo.Bind("orders_all", "orders:#"))
+	assert.Equal(t, ErrInvalidPattern, repo.Bind("orders_all", "orders:*x"))
+	assert.Equal(t, queue.ErrInvalidName, repo.Bind("orders/all", "orders:#"))
+
+	for key, expected := range map[string][]string{
+		"orders:eu:created":    {"orders_all", "orders_created"},
+		"orders:eu:shipped":    {"orders_all"},
+		"orders":               {"orders_all"},
+		"orders:eu:created:x":  {"orders_all"},
+		"users:eu:created":     {},
+		"orders_eu_created":    {},
+		"orders:eu:eu:created": {"orders_all"},
+	} {
+		names, err := repo.Route(key)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, names, key)
+	}
+	assert.EqualValues(t, 2, repo.Unmatched())
+
+	repo.CloseAllQueues()
+	repo, err = NewRepository(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []Binding{{"orders_all", "orders:#"}, {"orders_all", "orders:*:created"},
+		{"orders_created", "orders:*:created"}}, repo.Bindings(""))
+
+	assert.NoError(t, repo.Unbind("orders_all", "orders:#"))
+	assert.Equal(t, ErrBindingNotFound, repo.Unbind("orders_all", "orders:#"))
+	assert.NoError(t, repo.DeleteQueue("orders_created"))
+	assert.Equal(t, []Binding{{"orders_all", "orders:*:created"}}, repo.Bindings(""))
+	assert.Empty(t, repo.Bindings("orders_created"))
+}
+
+func Test_ReadGroup(t *testing.T) {
+	repo, err := NewRepository(dir)
+	assert.NoError(t, err)
+	defer repo.DeleteAllQueues()
+
+	for _, name := range []string{"tenant_a", "tenant_b", "tenant_c", "other"} {
+		repo.GetQueue(name)
+	}
+	assert.NoError(t, repo.AddReadGroupMember("tenants", "tenant_*", 1))
+	assert.NoError(t, repo.AddReadGroupMember("tenants", "tenant_a", 2))
+	assert.Equal(t, ErrInvalidWeight, repo.AddReadGroupMember("tenants", "tenant_b", 0))
+	assert.Equal(t, queue.ErrInvalidName, repo.AddReadGroupMember("tenants", "tenant/*", 1))
+
+	g, err := repo.ReadGroup("tenants")
+	assert.NoError(t, err)
+	picks := map[string]int{}
+	for i := 0; i < 8; i++ {
+		picks[g.Next(g.Members(""), map[string]bool{})]++
+	}
+	assert.Equal(t, map[string]int{"tenant_a": 4, "tenant_b": 2, "tenant_c": 2}, picks)
+
+	members := g.Members("")
+	tried := map[string]bool{"tenant_a": true, "tenant_b": true}
+	assert.Equal(t, "tenant_c", g.Next(members, tried))
+	tried["tenant_c"] = true
+	assert.Equal(t, "", g.Next(members, tried))
+
+	g.Served("tenant_b")
+	stats := map[string]string{}
+	for _, item := range repo.FullStats() {
+		stats[item.Key] = item.Value
+	}
+	assert.Equal(t, "2", stats["readgroup_tenants_tenant_a_weight"])
+	assert.Equal(t, "1", stats["readgroup_tenants_tenant_b_served"])
+	assert.NotContains(t, stats, "readgroup_tenants_other_served")
+
+	repo.CloseAllQueues()
+	repo, err = NewRepository(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []ReadGroupConfig{{"tenants", []ReadGroupMember{{"tenant_*", 1}, {"tenant_a", 2}}}},
+		repo.ReadGroups())
+
+	assert.NoError(t, repo.RemoveReadGroupMember("tenants", "tenant_*"))
+	assert.Equal(t, ErrReadGroupMemberNotFound, repo.RemoveReadGroupMember("tenants", "tenant_*"))
+	assert.NoError(t, repo.RemoveReadGroupMember("tenants", "tenant_a"))
+	_, err = repo.ReadGroup("tenants")
+	assert.Equal(t, ErrReadGroupNotFound, err)
+}
+
+func Test_writeSynced(t *testing.T) {
+	path := dir + "/synced"
+	defer os.Remove(path)
+
+	assert.NoError(t, writeSynced(path, []byte("1")))
+	assert.NoError(t, writeSynced(path, []byte("2")))
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", string(data))
+	_, err = os.Stat(path + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Error(t, writeSynced(dir+"/missing/synced", []byte("3")))
+}
+
+func Test_ReadGroup_Members(t *testing.T) {
+	repo, err := NewRepositoryWithOptions(dir, &Options{IdleTimeout: time.Hour})
+	assert.NoError(t, err)
+	defer repo.DeleteAllQueues()
+
+	full, _ := repo.GetQueue("tenant_full")
+	full.Enqueue([]byte("1"))
+	q, _ := repo.GetQueue("tenant_empty")
+	_, err = q.ConsumerGroup("cg")
+	assert.NoError(t, err)
+	assert.NoError(t, repo.AddReadGroupMember("tenants", "tenant_*", 1))
+	repo.closeIdleQueues(time.Now().Add(time.Hour))
+
+	// closed queues without items are skipped and stay closed
+	g, _ := repo.ReadGroup("tenants")
+	assert.Equal(t, []ReadGroupMember{{"tenant_full", 1}}, g.Members(""))
+	assert.Equal(t, []ReadGroupMember{{"tenant_full", 1}}, g.Members("cg"))
+	assert.Equal(t, []ReadGroupMember{{"tenant_empty", 1}, {"tenant_full", 1}}, g.Members("other"))
+	assert.Equal(t, 0, repo.storage.Count())
+}
@@ -0,0 +1,262 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bogdanovich/siberite/cgroup"
+)
+
+// summaryFile is written to the queue data directory when an idle queue is closed
+const summaryFile = "_.summary"
+
+// Summary is a snapshot of queue stats. It's persisted when an idle
+// queue is closed, so stats of a closed queue don't require reopening it.
+type Summary struct {
+	Items          uint64
+	Head           uint64
+	Tail           uint64
+	OpenReads      int64
+	ProtectCursors bool
+	Retained       uint64
+	CursorTTL      bool
+	ExpiredCursors uint64
+	Cursors        []CursorSummary
+	Fanout         []FanoutSummary `json:",omitempty"`
+}
+
+// FanoutSummary is the number of items copied to a fanout child
+type FanoutSummary struct {
+	Child     string
+	Delivered uint64
+}
+
+// CursorSummary is a snapshot of consumer group stats
+type CursorSummary struct {
+	Name         string
+	Items        uint64
+	OpenReads    int64
+	Cursor       uint64
+	LagBytes     uint64
+	OldestUnread int64
+	FailedReads  uint64
+	LastRead     int64
+	ReadRate     float64
+	Sessions     int64
+}
+
+func summarize(q *cgroup.CGQueue) *Summary {
+	opts := q.Options()
+	s := &Summary{
+		Items:          q.Length(),
+		Head:           q.Head(),
+		Tail:           q.Tail(),
+		OpenReads:      q.Stats().OpenReads,
+		ProtectCursors: opts.ProtectCursors,
+		Retained:       q.Retained(),
+		CursorTTL:      opts.CursorTTL > 0,
+		ExpiredCursors: q.ExpiredConsumerGroups(),
+	}
+	for pair := range q.ConsumerGroupIterator() {
+		cg := pair.Val.(*cgroup.ConsumerGroup)
+		c := CursorSummary{
+			Name:        cg.Name,
+			Items:       cg.Length(),
+			OpenReads:   cg.Stats().OpenReads,
+			Cursor:      cg.Cursor(),
+			LagBytes:    cg.LagBytes(),
+			FailedReads: cg.FailedReads(),
+			ReadRate:    cg.Activity().Rate(),
+			Sessions:    cg.Activity().Sessions(),
+		}
+		if oldest := cg.OldestUnread(); !oldest.IsZero() {
+			c.OldestUnread = oldest.Unix()
+		}
+		if last := cg.Activity().LastRead(); !last.IsZero() {
+			c.LastRead = last.Unix()
+		}
+		s.Cursors = append(s.Cursors, c)
+	}
+	delivered := q.Delivered()
+	for _, child := range q.FanoutChildren() {
+		s.Fanout = append(s.Fanout, FanoutSummary{child, delivered[child]})
+	}
+	return s
+}
+
+// stats returns stat items of the queue
+// empty returns true if the summary shows no items to read from
+// the queue, or through its consumer group if one is given
+func (s *Summary) empty(consumerGroup string) bool {
+	if consumerGroup == "" {
+		return s.Items == 0
+	}
+	for _, c := range s.Cursors {
+		if c.Name == consumerGroup {
+			return c.Items == 0
+		}
+	}
+	return false
+}
+
+func (s *Summary) stats(name string, currentTime int64) []StatItem {
+	prefix := "queue_" + name
+	stats := []StatItem{
+		{prefix + "_items", fmt.Sprintf("%d", s.Items)},
+		{prefix + "_open_transactions", fmt.Sprintf("%d", s.OpenReads)},
+	}
+	if s.ProtectCursors {
+		stats = append(stats, StatItem{prefix + "_retained", fmt.Sprintf("%d", s.Retained)})
+	}
+	if s.CursorTTL {
+		stats = append(stats, StatItem{prefix + "_expired_cursors", fmt.Sprintf("%d", s.ExpiredCursors)})
+	}
+	for _, f := range s.Fanout {
+		stats = append(stats, StatItem{prefix + "_fanout_" + f.Child + "_delivered", fmt.Sprintf("%d", f.Delivered)})
+	}
+	for _, c := range s.Cursors {
+		stats = append(stats, c.stats(prefix+"."+c.Name, currentTime)...)
+	}
+	return stats
+}
+
+func (c *CursorSummary) stats(prefix string, currentTime int64) []StatItem {
+	var oldestAge int64
+	if c.OldestUnread > 0 && c.OldestUnread < currentTime {
+		oldestAge = currentTime - c.OldestUnread
+	}
+	return []StatItem{
+		{prefix + "_items", fmt.Sprintf("%d", c.Items)},
+		{prefix + "_open_transactions", fmt.Sprintf("%d", c.OpenReads)},
+		{prefix + "_cursor", fmt.Sprintf("%d", c.Cursor)},
+		{prefix + "_lag_bytes", fmt.Sprintf("%d", c.LagBytes)},
+		{prefix + "_oldest_item_age", fmt.Sprintf("%d", oldestAge)},
+		{prefix + "_failed_reads", fmt.Sprintf("%d", c.FailedReads)},
+		{prefix + "_last_read", fmt.Sprintf("%d", c.LastRead)},
+		{prefix + "_read_rate", fmt.Sprintf("%.2f", c.ReadRate)},
+		{prefix + "_sessions", fmt.Sprintf("%d", c.Sessions)},
+	}
+}
+
+// idle returns true if the queue can be closed: it has no open reads,
+// no active sessions and no background tasks that need it open
+func idle(q *cgroup.CGQueue) bool {
+	opts := q.Options()
+	if q.Stats().OpenReads > 0 || opts.HasRetention() || opts.CursorTTL > 0 {
+		return false
+	}
+	for pair := range q.ConsumerGroupIterator() {
+		cg := pair.Val.(*cgroup.ConsumerGroup)
+		if cg.Stats().OpenReads > 0 || cg.Activity().Sessions() > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func saveSummary(path string, s *Summary) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(path, summaryFile+".tmp")
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(path, summaryFile))
+}
+
+func loadSummary(path string) (*Summary, error) {
+	data, err := ioutil.ReadFile(filepath.Join(path, summaryFile))
+	if err != nil {
+		return nil, err
+	}
+	s := &Summary{}
+	return s, json.Unmarshal(data, s)
+}
+
+// removeSummary deletes the summary of a reopened queue,
+// it becomes stale on the first write
+func removeSummary(path string) error {
+	err := os.Remove(filepath.Join(path, summaryFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// touch records the time the queue was last used
+func (repo *QueueRepository) touch(key string) {
+	if repo.opts.IdleTimeout > 0 {
+		repo.used.Set(key, time.Now())
+	}
+}
+
+func (repo *QueueRepository) lastUsed(key string) time.Time {
+	if val, ok := repo.used.Get(key); ok {
+		return val.(time.Time)
+	}
+	return time.Time{}
+}
+
+// closeIdleQueues closes queues that were not used since the cutoff time
+func (repo *QueueRepository) closeIdleQueues(cutoff time.Time) {
+	for pair := range repo.storage.IterBuffered() {
+		q := pair.Val.(*cgroup.CGQueue)
+		if repo.lastUsed(q.Name).After(cutoff) || !idle(q) {
+			continue
+		}
+		if err := repo.closeQueue(q.Name, cutoff); err != nil {
+			log.Printf("queue %s close: %s", q.Name, err.Error())
+		}
+	}
+}
+
+// lookup returns an open queue and records its use. The queue is looked
+// up again after it's touched: closeQueue removes a queue before it checks
+// the last use, so a queue being closed is either kept open or not returned.
+func (repo *QueueRepository) lookup(key string) (*cgroup.CGQueue, bool) {
+	q, ok := repo.get(key)
+	if !ok {
+		return nil, false
+	}
+	repo.touch(key)
+	if current, ok := repo.get(key); !ok || current != q {
+		return nil, false
+	}
+	return q, true
+}
+
+func (repo *QueueRepository) closeQueue(key string, cutoff time.Time) error {
+	repo.Lock()
+	defer repo.Unlock()
+
+	q, ok := repo.get(key)
+	if !ok {
+		return nil
+	}
+	// recheck after the queue is removed, it could be used while
+	// we were waiting on the lock or get touched by lookup meanwhile
+	repo.storage.Remove(key)
+	since := repo.lastUsed(key)
+	if since.After(cutoff) {
+		repo.storage.Set(key, q)
+		return nil
+	}
+	s := summarize(q)
+	if err := saveSummary(q.Path(), s); err != nil {
+		repo.storage.Set(key, q)
+		return err
+	}
+	repo.used.Remove(key)
+	repo.closed[key] = s
+	q.Close()
+	log.Printf("queue \"%s\": closed, idle since %s",
+		key, since.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,364 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bogdanovich/siberite/cgroup"
+	"github.com/bogdanovich/siberite/queue"
+)
+
+// transactionDir keeps transactions that are committed, but not applied
+// yet, it's hidden, so it's never opened as a queue
+const transactionDir = ".transactions"
+
+const (
+	txnLogSuffix  = ".txn"
+	txnDoneSuffix = ".done"
+	// txnReservedFile keeps the last reserved transaction ID
+	txnReservedFile = "reserved"
+	// txnIDBlock is the number of transaction IDs reserved with one write
+	txnIDBlock = 1000
+)
+
+// Transaction is a reliable read together with items enqueued while
+// the read item was processed. Committed items are stored
+// and the read item is confirmed at once.
+type Transaction struct {
+	Queue  string
+	Cursor string `json:",omitempty"`
+	// Group and ItemID identify a read item of a message group, such
+	// items stay in the group queue until they are confirmed, other
+	// items are removed from the queue when they are read
+	Group  string `json:",omitempty"`
+	ItemID uint64 `json:",omitempty"`
+	Items  []TransactionItem
+}
+
+// TransactionItem is an item staged by a transaction
+type TransactionItem struct {
+	Queue string
+	// Parent is set for a copy of an item delivered to a fanout child
+	Parent string `json:",omitempty"`
+	Group  string `json:",omitempty"`
+	Value  []byte
+}
+
+// Commit stores transaction items and confirms the read item.
+// Items of a single queue are stored with one write. Otherwise the
+// transaction is written to the transaction log first, every queue
+// marks the transaction in the same write as its items, and
+// a transaction interrupted by a crash is replayed on start.
+// A logged transaction is committed even if it fails to apply,
+// it's applied again by maintenance or on next start.
+func (repo *QueueRepository) Commit(txn *Transaction) error {
+	names, items := txn.queueItems()
+	queues := make([]*cgroup.CGQueue, len(names))
+	for i, name := range names {
+		var err error
+		if queues[i], err = repo.GetQueue(name); err != nil {
+			return err
+		}
+	}
+
+	if len(names) == 1 && txn.Group == "" {
+		if err := queues[0].EnqueueBatch(items[names[0]]); err != nil {
+			return err
+		}
+		repo.countDelivered(txn)
+		return nil
+	}
+
+	id, err := repo.txns.nextID()
+	if err != nil {
+		return err
+	}
+	if err = repo.logTransaction(id, txn); err != nil {
+		return err
+	}
+	if err = repo.applyTransaction(id, txn); err != nil {
+		log.Printf("transaction %d: %s, will retry", id, err.Error())
+		repo.txns.addPending(id, txn)
+		return nil
+	}
+	repo.countDelivered(txn)
+	return nil
+}
+
+// retryTransactions applies logged transactions that failed to apply
+func (repo *QueueRepository) retryTransactions() {
+	for id, txn := range repo.txns.pendingTransactions() {
+		if err := repo.applyTransaction(id, txn); err != nil {
+			log.Printf("transaction %d: %s, will retry", id, err.Error())
+			continue
+		}
+		repo.txns.removePending(id)
+		repo.countDelivered(txn)
+	}
+}
+
+// countDelivered counts copies of items delivered to fanout children
+func (repo *QueueRepository) countDelivered(txn *Transaction) {
+	for _, item := range txn.Items {
+		if item.Parent == "" {
+			continue
+		}
+		if parent, ok := repo.get(item.Parent); ok {
+			parent.CountDelivered(item.Queue, 1)
+		}
+	}
+}
+
+// queueItems returns names of queues in the order they were
+// staged and items of every queue
+func (txn *Transaction) queueItems() ([]string, map[string][]*queue.Item) {
+	names := []string{}
+	items := map[string][]*queue.Item{}
+	for _, item := range txn.Items {
+		if _, ok := items[item.Queue]; !ok {
+			names = append(names, item.Queue)
+		}
+		items[item.Queue] = append(items[item.Queue], &queue.Item{Value: item.Value, Group: item.Group})
+	}
+	return names, items
+}
+
+// logTransaction durably writes the transaction to the transaction log
+func (repo *QueueRepository) logTransaction(id uint64, txn *Transaction) error {
+	if err := os.MkdirAll(repo.txns.dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(txn)
+	if err != nil {
+		return err
+	}
+	return writeSynced(repo.txns.path(id, txnLogSuffix), data)
+}
+
+// applyTransaction stores items in queues that don't have the
+// transaction marker yet, confirms the read item and removes
+// the transaction from the log
+func (repo *QueueRepository) applyTransaction(id uint64, txn *Transaction) error {
+	names, items := txn.queueItems()
+	for _, name := range names {
+		q, err := repo.CreateQueue(name)
+		if err != nil {
+			return err
+		}
+		done, err := q.HasTxn(id)
+		if err == nil && !done {
+			err = q.EnqueueTxn(id, items[name])
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if txn.Group != "" {
+		if err := repo.closeTransactionItem(txn); err != nil {
+			return err
+		}
+	}
+	// the log is marked as applied before the markers are forgotten,
+	// so a crash in between never applies the transaction twice
+	path := repo.txns.path(id, txnDoneSuffix)
+	if err := os.Rename(repo.txns.path(id, txnLogSuffix), path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := syncDir(repo.txns.dir); err != nil {
+		return err
+	}
+	return repo.forgetTransaction(id, txn, path)
+}
+
+// forgetTransaction removes markers of an applied transaction
+// and then its log file
+func (repo *QueueRepository) forgetTransaction(id uint64, txn *Transaction, path string) error {
+	names, _ := txn.queueItems()
+	for _, name := range names {
+		if !repo.exists(name) {
+			continue
+		}
+		q, err := repo.CreateQueue(name)
+		if err != nil {
+			return err
+		}
+		if err = q.ForgetTxn(id); err != nil {
+			return err
+		}
+	}
+	return os.Remove(path)
+}
+
+// closeTransactionItem confirms the read item of a message group
+func (repo *QueueRepository) closeTransactionItem(txn *Transaction) error {
+	q, err := repo.CreateQueue(txn.Queue)
+	if err != nil {
+		return err
+	}
+	var consumer cgroup.GroupConsumer = q
+	if txn.Cursor != "" {
+		consumer, err = q.FindConsumerGroup(txn.Cursor)
+		if err == cgroup.ErrNotFound {
+			// the consumer group was deleted along with its items
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return consumer.CloseGroupItem(txn.Group, txn.ItemID)
+}
+
+// replayTransactions applies transactions interrupted by
+// a stop of the server, unfinished log writes are removed
+func (repo *QueueRepository) replayTransactions() error {
+	if err := repo.txns.loadReserved(); err != nil {
+		return err
+	}
+	entries, err := ioutil.ReadDir(repo.txns.dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			os.Remove(filepath.Join(repo.txns.dir, entry.Name()))
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		suffix := filepath.Ext(name)
+		if suffix != txnLogSuffix && suffix != txnDoneSuffix {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, suffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(repo.txns.dir, name)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		txn := &Transaction{}
+		if err = json.Unmarshal(data, txn); err != nil {
+			return fmt.Errorf("transaction %s: %s", path, err.Error())
+		}
+		repo.txns.seen(id)
+		if suffix == txnDoneSuffix {
+			err = repo.forgetTransaction(id, txn, path)
+		} else {
+			err = repo.applyTransaction(id, txn)
+		}
+		if err != nil {
+			return fmt.Errorf("transaction %s: %s", path, err.Error())
+		}
+		if suffix == txnLogSuffix {
+			log.Printf("transaction %d: replayed, %d items", id, len(txn.Items))
+		}
+	}
+	return nil
+}
+
+// transactionLog allocates transaction IDs and keeps logged
+// transactions that failed to apply. IDs are reserved in blocks,
+// the last reserved ID is persisted, so IDs are never reused.
+type transactionLog struct {
+	sync.Mutex
+	dir      string
+	last     uint64
+	reserved uint64
+	pending  map[uint64]*Transaction
+}
+
+func newTransactionLog(dir string) *transactionLog {
+	return &transactionLog{dir: dir, pending: map[uint64]*Transaction{}}
+}
+
+// nextID returns a new transaction ID
+func (l *transactionLog) nextID() (uint64, error) {
+	l.Lock()
+	defer l.Unlock()
+	if l.last >= l.reserved {
+		if err := os.MkdirAll(l.dir, 0755); err != nil {
+			return 0, err
+		}
+		reserved := l.last + txnIDBlock
+		data := []byte(strconv.FormatUint(reserved, 10))
+		if err := writeSynced(filepath.Join(l.dir, txnReservedFile), data); err != nil {
+			return 0, err
+		}
+		l.reserved = reserved
+	}
+	l.last++
+	return l.last, nil
+}
+
+// loadReserved skips IDs that could be used before restart
+func (l *transactionLog) loadReserved() error {
+	data, err := ioutil.ReadFile(filepath.Join(l.dir, txnReservedFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	reserved, err := strconv.ParseUint(string(data), 10, 64)
+	if err != nil {
+		return fmt.Errorf("transaction log %s: %s", txnReservedFile, err.Error())
+	}
+	l.seen(reserved)
+	return nil
+}
+
+// seen makes sure the ID is never used again
+func (l *transactionLog) seen(id uint64) {
+	l.Lock()
+	defer l.Unlock()
+	if id > l.last {
+		l.last = id
+	}
+}
+
+// path returns a path of the transaction log file
+func (l *transactionLog) path(id uint64, suffix string) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d%s", id, suffix))
+}
+
+func (l *transactionLog) addPending(id uint64, txn *Transaction) {
+	l.Lock()
+	defer l.Unlock()
+	l.pending[id] = txn
+}
+
+func (l *transactionLog) removePending(id uint64) {
+	l.Lock()
+	defer l.Unlock()
+	delete(l.pending, id)
+}
+
+// pendingTransactions returns a copy of transactions that failed to apply
+func (l *transactionLog) pendingTransactions() map[uint64]*Transaction {
+	l.Lock()
+	defer l.Unlock()
+	pending := make(map[uint64]*Transaction, len(l.pending))
+	for id, txn := range l.pending {
+		pending[id] = txn
+	}
+	return pending
+}
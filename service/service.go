@@ -10,9 +10,12 @@ import (
 	"github.com/bogdanovich/siberite/repository"
 )
 
+const maintenanceInterval = time.Second
+
 // Service represents a siberite tcp server
 type Service struct {
 	dataDir string
+	opts    *repository.Options
 	repo    *repository.QueueRepository
 	ch      chan struct{}
 	wg      *sync.WaitGroup
@@ -20,8 +23,14 @@ type Service struct {
 
 // New creates a new service
 func New(dataDir string) *Service {
+	return NewWithOptions(dataDir, &repository.Options{})
+}
+
+// NewWithOptions creates a new service with provided repository options
+func NewWithOptions(dataDir string, opts *repository.Options) *Service {
 	s := &Service{
 		dataDir: dataDir,
+		opts:    opts,
 		repo:    &repository.QueueRepository{},
 		ch:      make(chan struct{}),
 		wg:      &sync.WaitGroup{},
@@ -36,7 +45,7 @@ func (s *Service) Serve(laddr *net.TCPAddr) {
 
 	log.Println("initializing...")
 	var err error
-	s.repo, err = repository.NewRepository(s.dataDir)
+	s.repo, err = repository.NewRepositoryWithOptions(s.dataDir, s.opts)
 	log.Println("data directory: ", s.dataDir)
 	if err != nil {
 		log.Fatal(err)
@@ -48,6 +57,9 @@ func (s *Service) Serve(laddr *net.TCPAddr) {
 	}
 	log.Println("listening on", listener.Addr())
 
+	s.wg.Add(1)
+	go s.maintain()
+
 	for {
 		select {
 		case <-s.ch:
@@ -76,6 +88,20 @@ func (s *Service) Stop() {
 	s.wg.Wait()
 }
 
+func (s *Service) maintain() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(maintenanceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ch:
+			return
+		case <-ticker.C:
+			s.repo.Maintain()
+		}
+	}
+}
+
 func (s *Service) handleConnection(conn *net.TCPConn) {
 	defer conn.Close()
 	defer s.wg.Done()
@@ -44,3 +44,75 @@ func Test_StartGetVersionAndStop(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, fmt.Sprintf("VERSION %s\r\n", s.Version()), answer)
 }
+
+func Test_Subscribe(t *testing.T) {
+	s := New(dir)
+	laddr, err := net.ResolveTCPAddr("tcp", "127.0.0.1:22141")
+	assert.Nil(t, err)
+	go s.Serve(laddr)
+	defer s.Stop()
+	time.Sleep(1 * time.Second)
+
+	producer, err := net.Dial("tcp", "127.0.0.1:22141")
+	assert.Nil(t, err)
+	defer producer.Close()
+	producerReader := bufio.NewReader(producer)
+	set := func(value string) {
+		fmt.Fprintf(producer, "set work 0 0 %d\r\n%s\r\n", len(value), value)
+		answer, err := producerReader.ReadString('\n')
+		assert.Nil(t, err)
+		assert.Equal(t, "STORED\r\n", answer)
+	}
+	for _, value := range []string{"1", "2", "3"} {
+		set(value)
+	}
+
+	conn, err := net.Dial("tcp", "127.0.0.1:22141")
+	assert.Nil(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	read := func(n int) string {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		lines := ""
+		for i := 0; i < n; i++ {
+			line, err := reader.ReadString('\n')
+			assert.Nil(t, err)
+			lines += line
+		}
+		return lines
+	}
+
+	fmt.Fprintf(conn, "subscribe work prefetch=2\r\n")
+	assert.Equal(t, "END\r\nVALUE work 0 1 1\r\n1\r\nVALUE work 0 1 2\r\n2\r\n", read(5))
+
+	// nothing is pushed until an item is acknowledged
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, err = reader.ReadString('\n')
+	assert.Error(t, err)
+
+	fmt.Fprintf(conn, "ack 1\r\n")
+	assert.Equal(t, "VALUE work 0 1 3\r\n3\r\n", read(2))
+	fmt.Fprintf(conn, "nak 2\r\n")
+	assert.Equal(t, "VALUE work 0 1 4\r\n2\r\n", read(2))
+
+	fmt.Fprintf(conn, "ack 3 4\r\n")
+	set("5")
+	assert.Equal(t, "VALUE work 0 1 5\r\n5\r\n", read(2))
+
+	fmt.Fprintf(conn, "unsubscribe\r\n")
+	assert.Equal(t, "END\r\n", read(1))
+	fmt.Fprintf(conn, "get work\r\n")
+	assert.Equal(t, "VALUE work 0 1\r\n5\r\nEND\r\n", read(3))
+
+	// unacknowledged items are returned when the session finishes
+	set("6")
+	fmt.Fprintf(conn, "subscribe work\r\n")
+	assert.Equal(t, "END\r\nVALUE work 0 1 1\r\n6\r\n", read(3))
+	fmt.Fprintf(conn, "get work\r\n")
+	assert.Equal(t, "CLIENT_ERROR Unsubscribe first\r\n", read(1))
+	time.Sleep(100 * time.Millisecond)
+	fmt.Fprintf(producer, "get work\r\n")
+	answer, err := producerReader.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "VALUE work 0 1\r\n", answer)
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/bogdanovich/siberite/cgroup"
+	"github.com/bogdanovich/siberite/dump"
+	"github.com/bogdanovich/siberite/kestrel"
+)
+
+var (
+	dataDir   = flag.String("data", "./data", "path to data directory of a stopped server")
+	queueName = flag.String("queue", "", "queue name, all queues if empty")
+	repair    = flag.Bool("repair", false, "fix problems found by check")
+	newName   = flag.String("name", "", "restore or import the queue under a new name, requires -queue")
+	cursor    = flag.String("cursor", "", "export pending items of the consumer group")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] list|check|compact\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [options] restore|export|import <file>\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [options] kestrel <journal directory>\n\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "  list     list queues and their consumer groups")
+	fmt.Fprintln(os.Stderr, "  check    detect gaps in ID sequence, unreadable items and orphaned keys")
+	fmt.Fprintln(os.Stderr, "  compact  compact queue databases")
+	fmt.Fprintln(os.Stderr, "  restore  restore queues from a backup archive")
+	fmt.Fprintln(os.Stderr, "  export   export pending items of -queue to a .jsonl or .dump file")
+	fmt.Fprintln(os.Stderr, "  import   append items from a .jsonl or .dump file to -queue")
+	fmt.Fprintln(os.Stderr, "  kestrel  import queues from Kestrel journal files")
+	fmt.Fprintln(os.Stderr)
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 2 {
+		var err error
+		switch flag.Arg(0) {
+		case "restore":
+			err = restore(flag.Arg(1))
+		case "export":
+			err = export(flag.Arg(1))
+		case "import":
+			err = importFile(flag.Arg(1))
+		case "kestrel":
+			err = importKestrel(flag.Arg(1))
+		default:
+			usage()
+			os.Exit(2)
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	var command func(name string) error
+	switch flag.Arg(0) {
+	case "list":
+		command = list
+	case "check":
+		command = check
+	case "compact":
+		command = compact
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	names, err := queueNames()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	failed := false
+	for _, name := range names {
+		if err = command(name); err != nil {
+			fmt.Printf("queue %s: error: %s\n", name, err.Error())
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func queueNames() ([]string, error) {
+	if *queueName != "" {
+		return []string{*queueName}, nil
+	}
+	dirs, err := ioutil.ReadDir(*dataDir)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, dir := range dirs {
+		// skip quarantine and other hidden directories
+		if dir.IsDir() && dir.Name()[0] != '.' {
+			names = append(names, dir.Name())
+		}
+	}
+	return names, nil
+}
+
+// dataPath returns absolute data directory path,
+// checking that the queue exists
+func dataPath(name string) (string, error) {
+	path, err := filepath.Abs(*dataDir)
+	if err != nil {
+		return "", err
+	}
+	_, err = os.Stat(filepath.Join(path, name))
+	return path, err
+}
+
+func openQueue(name string) (*cgroup.CGQueue, error) {
+	path, err := dataPath(name)
+	if err != nil {
+		return nil, err
+	}
+	return cgroup.CGQueueOpen(name, path)
+}
+
+func list(name string) error {
+	q, err := openQueue(name)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+
+	fmt.Printf("queue %s: head %d, tail %d, length %d\n", name, q.Head(), q.Tail(), q.Length())
+	cursors := []*cgroup.ConsumerGroup{}
+	for pair := range q.ConsumerGroupIterator() {
+		cursors = append(cursors, pair.Val.(*cgroup.ConsumerGroup))
+	}
+	sort.Slice(cursors, func(i, j int) bool { return cursors[i].Name < cursors[j].Name })
+	for _, cg := range cursors {
+		fmt.Printf("  cursor %s: position %d, length %d, failed reads %d\n",
+			cg.Name, cg.Cursor(), cg.Length(), cg.FailedReads())
+	}
+	return nil
+}
+
+func check(name string) error {
+	path, err := dataPath(name)
+	if err != nil {
+		return err
+	}
+	report, err := cgroup.Check(name, path, *repair)
+	if err != nil {
+		return err
+	}
+
+	status := "ok"
+	if !report.OK() {
+		status = "problems found"
+		if *repair {
+			status = "repaired"
+		}
+	}
+	fmt.Printf("queue %s: %s\n", name, status)
+	if len(report.Gaps) > 0 {
+		fmt.Printf("  gaps in ID sequence: %s\n", formatIDs(report.Gaps))
+	}
+	if len(report.Unreadable) > 0 {
+		fmt.Printf("  unreadable items: %s\n", formatIDs(report.Unreadable))
+	}
+	if len(report.Orphaned) > 0 {
+		fmt.Printf("  orphaned item records: %s\n", formatIDs(report.Orphaned))
+	}
+	if report.Holes != report.Tombstones {
+		fmt.Printf("  deleted items: %d recorded, %d found\n", report.Holes, report.Tombstones)
+	}
+	for _, cursor := range report.InvalidCursors {
+		fmt.Printf("  invalid cursor: %q\n", cursor)
+	}
+	if len(report.OrphanedKeys) > 0 {
+		fmt.Printf("  orphaned consumer group keys: %d\n", len(report.OrphanedKeys))
+	}
+	return nil
+}
+
+func compact(name string) error {
+	q, err := openQueue(name)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+
+	if err = q.Compact(); err != nil {
+		return err
+	}
+	fmt.Printf("queue %s: compacted\n", name)
+	return nil
+}
+
+// restore loads queues from the archive into the data directory,
+// existing queues are not overwritten
+func restore(archive string) error {
+	if *newName != "" && *queueName == "" {
+		return fmt.Errorf("-name requires -queue")
+	}
+	path, err := filepath.Abs(*dataDir)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	restored, err := cgroup.Restore(file, path, func(name string) string {
+		switch {
+		case *queueName == "":
+			return name
+		case *queueName != name:
+			return ""
+		case *newName != "":
+			return *newName
+		}
+		return name
+	})
+	for _, name := range restored {
+		fmt.Printf("queue %s: restored\n", name)
+	}
+	return err
+}
+
+func export(path string) error {
+	if *queueName == "" {
+		return fmt.Errorf("%s requires -queue", flag.Arg(0))
+	}
+	format, err := dump.FormatFromPath(path)
+	if err != nil {
+		return err
+	}
+	q, err := openQueue(*queueName)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+	var source dump.Source = q
+	if *cursor != "" {
+		if source, err = q.FindConsumerGroup(*cursor); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w, err := dump.NewWriter(file, format)
+	if err != nil {
+		return err
+	}
+	n, err := dump.Export(w, source)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("queue %s: exported %d items\n", *queueName, n)
+	return nil
+}
+
+func importFile(path string) error {
+	if *queueName == "" {
+		return fmt.Errorf("%s requires -queue", flag.Arg(0))
+	}
+	format, err := dump.FormatFromPath(path)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r, err := dump.NewReader(file, format)
+	if err != nil {
+		return err
+	}
+
+	// the queue is created if it doesn't exist
+	data, err := filepath.Abs(*dataDir)
+	if err != nil {
+		return err
+	}
+	q, err := cgroup.CGQueueOpen(*queueName, data)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+	n, err := dump.Import(r, q.Queue)
+	fmt.Printf("queue %s: imported %d items\n", *queueName, n)
+	return err
+}
+
+// importKestrel replays Kestrel journals and appends live items
+// to queues of the same name, open transactions are imported as
+// pending items
+func importKestrel(journalDir string) error {
+	if *newName != "" && *queueName == "" {
+		return fmt.Errorf("-name requires -queue")
+	}
+	data, err := filepath.Abs(*dataDir)
+	if err != nil {
+		return err
+	}
+	names := []string{*queueName}
+	if *queueName == "" {
+		if names, err = kestrel.Queues(journalDir); err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+	for _, name := range names {
+		target := name
+		if *newName != "" {
+			target = *newName
+		}
+		if err = importJournal(journalDir, name, target, data); err != nil {
+			fmt.Printf("queue %s: error: %s\n", name, err.Error())
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d queues failed to import", failed, len(names))
+	}
+	return nil
+}
+
+func importJournal(journalDir, name, target, data string) error {
+	s, err := kestrel.Replay(journalDir, name)
+	if err != nil {
+		return err
+	}
+	for _, path := range s.Truncated {
+		fmt.Printf("queue %s: journal %s is truncated, incomplete record skipped\n", name, path)
+	}
+
+	// the queue is created if it doesn't exist
+	q, err := cgroup.CGQueueOpen(target, data)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+	n, expired, err := s.Import(q.Queue, time.Now())
+	fmt.Printf("queue %s: imported %d items into %s, skipped %d expired\n", name, n, target, expired)
+	return err
+}
+
+// formatIDs prints up to 10 IDs
+func formatIDs(ids []uint64) string {
+	const limit = 10
+	if len(ids) <= limit {
+		return fmt.Sprint(ids)
+	}
+	return fmt.Sprintf("%v and %d more", ids[:limit], len(ids)-limit)
+}
@@ -8,10 +8,13 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"regexp"
 	"runtime"
 	"strconv"
+	"strings"
 	"syscall"
 
+	"github.com/bogdanovich/siberite/repository"
 	service "github.com/bogdanovich/siberite/service"
 )
 
@@ -20,13 +23,30 @@ var (
 	hostAndPort = flag.String("listen", "0.0.0.0:22133", "ip and port to listen")
 	pidPath     = flag.String("pid", "", "path to PID file to use")
 	versionFlag = flag.Bool("version", false, "prints current version")
+	strict      = flag.Bool("strict", false, "disable implicit queue creation")
+	autoCreate  = flag.String("auto_create", "", "regexp matching whole names of queues that can be created implicitly")
+	queues      = flag.String("queues", "", "comma separated list of queues to create on start")
+	backupDir   = flag.String("backup_dir", "", "directory for backup archives, backups are disabled if empty")
+	idleTimeout = flag.Duration("idle_timeout", 0, "close queues that were not used for that long, 0 keeps queues open")
 )
 
 func main() {
 	flag.Parse()
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
-	service := service.New(*dataDir)
+	opts := &repository.Options{Strict: *strict, IdleTimeout: *idleTimeout, BackupDir: *backupDir}
+	if len(*autoCreate) > 0 {
+		var err error
+		// the pattern has to match the whole queue name
+		if opts.AutoCreate, err = regexp.Compile("^(?:" + *autoCreate + ")$"); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if len(*queues) > 0 {
+		opts.Queues = strings.Split(*queues, ",")
+	}
+
+	service := service.NewWithOptions(*dataDir, opts)
 
 	if *versionFlag {
 		fmt.Println(service.Version())
@@ -48,7 +68,7 @@ func main() {
 
 	go service.Serve(laddr)
 
-	ch := make(chan os.Signal)
+	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
 	log.Println(<-ch)
 